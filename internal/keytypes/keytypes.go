@@ -0,0 +1,37 @@
+package keytypes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// names maps lego-compatible key type names to lego key types.
+// RSA 8192 is omitted because Key Vault does not accept keys larger than 4096 bits.
+var names = map[string]certcrypto.KeyType{
+	"rsa2048": certcrypto.RSA2048,
+	"rsa3072": certcrypto.RSA3072,
+	"rsa4096": certcrypto.RSA4096,
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+}
+
+// Parse converts a certificate key type name (e.g. rsa2048, ec256) to a lego key type
+func Parse(name string) (certcrypto.KeyType, error) {
+	keyType, ok := names[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "", fmt.Errorf("unsupported key type %q (supported: rsa2048, rsa3072, rsa4096, ec256, ec384)", name)
+	}
+	return keyType, nil
+}
+
+// Name returns the lego-compatible name of a key type
+func Name(keyType certcrypto.KeyType) string {
+	for name, kt := range names {
+		if kt == keyType {
+			return name
+		}
+	}
+	return string(keyType)
+}
@@ -0,0 +1,219 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	queryTimeout   = 10 * time.Second
+)
+
+// defaultNameservers are used when no nameservers are configured and the system configuration has none
+var defaultNameservers = []string{"8.8.8.8:53", "1.1.1.1:53"}
+
+// Resolver sends DNS queries to recursive nameservers. It reads record types that the
+// resolver of the standard library does not expose, and honours the dns-resolvers setting.
+type Resolver struct {
+	nameservers []string
+}
+
+// NewResolver creates a resolver using the given nameservers (host:port), or the
+// nameservers of the system configuration when none are given
+func NewResolver(nameservers []string) *Resolver {
+	if len(nameservers) == 0 {
+		nameservers = systemNameservers()
+	}
+	return &Resolver{nameservers: nameservers}
+}
+
+// Nameservers returns the nameservers queried by the resolver
+func (r *Resolver) Nameservers() []string {
+	return r.nameservers
+}
+
+// LookupCNAME returns the target of the CNAME record of a name, or an empty string when the name has no CNAME record
+func (r *Resolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	msg, err := r.query(ctx, name, dns.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+
+	for _, answer := range msg.Answer {
+		if cname, ok := answer.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, dns.Fqdn(name)) {
+			return strings.ToLower(strings.TrimSuffix(cname.Target, ".")), nil
+		}
+	}
+	return "", nil
+}
+
+// CAA is a CAA property (RFC 8659)
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// LookupCAA returns the CAA record set of a name, following CNAME records, or nil when the name has none
+func (r *Resolver) LookupCAA(ctx context.Context, name string) ([]CAA, error) {
+	msg, err := r.query(ctx, name, dns.TypeCAA)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []CAA
+	for _, answer := range msg.Answer {
+		if caa, ok := answer.(*dns.CAA); ok {
+			records = append(records, CAA{Flag: caa.Flag, Tag: strings.ToLower(caa.Tag), Value: caa.Value})
+		}
+	}
+	return records, nil
+}
+
+// LookupNS returns the nameserver names of the NS record set of a name, or nil when the name has none
+func (r *Resolver) LookupNS(ctx context.Context, name string) ([]string, error) {
+	msg, err := r.query(ctx, name, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	return nsNames(msg.Answer, name), nil
+}
+
+// LookupDelegation returns the name of the closest parent zone of a zone and the nameservers that the
+// parent zone delegates the zone to, asking the authoritative nameservers of the parent zone directly.
+// The nameservers are nil when the parent zone does not delegate the zone.
+func (r *Resolver) LookupDelegation(ctx context.Context, zone string) (string, []string, error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	// The closest parent zone is the closest ancestor name with an NS record set
+	parent, parentServers := zone, []string(nil)
+	for len(parentServers) == 0 {
+		_, rest, found := strings.Cut(parent, ".")
+		if !found || rest == "" {
+			return "", nil, fmt.Errorf("no parent zone found for %s", zone)
+		}
+		parent = rest
+
+		var err error
+		if parentServers, err = r.LookupNS(ctx, parent); err != nil {
+			return "", nil, err
+		}
+	}
+	parent = strings.TrimSuffix(parent, ".")
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(zone, dns.TypeNS)
+	msg.RecursionDesired = false
+
+	lastErr := fmt.Errorf("no address found for the nameservers of %s", parent)
+	for _, server := range parentServers {
+		addresses, err := r.lookupAddresses(ctx, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, address := range addresses {
+			resp, err := exchange(ctx, msg, net.JoinHostPort(address, "53"))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			switch resp.Rcode {
+			case dns.RcodeNameError:
+				return parent, nil, nil
+			case dns.RcodeSuccess:
+				// A referral lists the delegation in the authority section; a nameserver also
+				// authoritative for the zone answers with the NS record set of the zone itself
+				return parent, nsNames(append(resp.Answer, resp.Ns...), zone), nil
+			default:
+				lastErr = fmt.Errorf("NS query for %s failed at %s: %s", zone, server, dns.RcodeToString[resp.Rcode])
+			}
+		}
+	}
+	return parent, nil, lastErr
+}
+
+// lookupAddresses returns the IPv4 addresses of a host name
+func (r *Resolver) lookupAddresses(ctx context.Context, host string) ([]string, error) {
+	msg, err := r.query(ctx, host, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, answer := range msg.Answer {
+		if a, ok := answer.(*dns.A); ok {
+			addresses = append(addresses, a.A.String())
+		}
+	}
+	return addresses, nil
+}
+
+// nsNames returns the nameserver names of the NS records of a name, without the trailing dot
+func nsNames(records []dns.RR, name string) []string {
+	var names []string
+	for _, record := range records {
+		if ns, ok := record.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, dns.Fqdn(name)) {
+			names = append(names, strings.ToLower(strings.TrimSuffix(ns.Ns, ".")))
+		}
+	}
+	return names
+}
+
+// query sends a recursive query to the nameservers in turn until one of them answers.
+// Both successful and NXDOMAIN responses are answers; other response codes are errors.
+func (r *Resolver) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, false)
+
+	var lastErr error
+	for _, ns := range r.nameservers {
+		resp, err := exchange(ctx, msg, ns)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			lastErr = fmt.Errorf("%s query for %s failed at %s: %s", dns.TypeToString[qtype], name, ns, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// exchange sends a query over UDP, repeating it over TCP when the response is truncated
+func exchange(ctx context.Context, msg *dns.Msg, ns string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resp, _, err := (&dns.Client{Net: "udp"}).ExchangeContext(ctx, msg, ns)
+	if err == nil && resp.Truncated {
+		resp, _, err = (&dns.Client{Net: "tcp"}).ExchangeContext(ctx, msg, ns)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DNS query to %s failed: %v", ns, err)
+	}
+	return resp, nil
+}
+
+// systemNameservers returns the nameservers of the system resolver configuration
+func systemNameservers() []string {
+	config, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil || len(config.Servers) == 0 {
+		return defaultNameservers
+	}
+
+	nameservers := make([]string, 0, len(config.Servers))
+	for _, server := range config.Servers {
+		nameservers = append(nameservers, net.JoinHostPort(server, config.Port))
+	}
+	return nameservers
+}
@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startServer serves the resource records on a local UDP port and returns its address. Names starting
+// with "fail." are answered with SERVFAIL, other names without records with NXDOMAIN.
+func startServer(t *testing.T, records ...string) string {
+	t.Helper()
+
+	zone := make(map[string][]dns.RR)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %v", record, err)
+		}
+		zone[rr.Header().Name] = append(zone[rr.Header().Name], rr)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		question := req.Question[0]
+		switch rrs, ok := zone[question.Name]; {
+		case dns.IsSubDomain("fail.", question.Name):
+			resp.Rcode = dns.RcodeServerFailure
+		case !ok:
+			resp.Rcode = dns.RcodeNameError
+		default:
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == question.Qtype || rr.Header().Rrtype == dns.TypeCNAME {
+					resp.Answer = append(resp.Answer, rr)
+				}
+			}
+		}
+		w.WriteMsg(resp)
+	})
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestLookupCNAME(t *testing.T) {
+	addr := startServer(t, "_acme-challenge.www.example.com. 60 IN CNAME WWW.Validation.example.net.")
+	res := NewResolver([]string{addr})
+
+	got, err := res.LookupCNAME(context.Background(), "_acme-challenge.www.example.com")
+	if err != nil || got != "www.validation.example.net" {
+		t.Errorf("LookupCNAME() = %q, %v, want www.validation.example.net", got, err)
+	}
+
+	got, err = res.LookupCNAME(context.Background(), "_acme-challenge.shop.example.com")
+	if err != nil || got != "" {
+		t.Errorf("LookupCNAME() of a missing name = %q, %v, want no CNAME", got, err)
+	}
+
+	if _, err := res.LookupCNAME(context.Background(), "fail"); err == nil {
+		t.Error("LookupCNAME() succeeded although the nameserver failed")
+	}
+}
+
+func TestLookupCNAMEFallsBackToNextNameserver(t *testing.T) {
+	addr := startServer(t, "www.example.com. 60 IN CNAME example.com.")
+
+	// Nothing listens on the first nameserver
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused := conn.LocalAddr().String()
+	conn.Close()
+
+	res := NewResolver([]string{unused, addr})
+	if got, err := res.LookupCNAME(context.Background(), "www.example.com"); err != nil || got != "example.com" {
+		t.Errorf("LookupCNAME() = %q, %v, want the answer of the second nameserver", got, err)
+	}
+}
+
+func TestNewResolverUsesSystemNameservers(t *testing.T) {
+	if got := NewResolver([]string{"9.9.9.9:53"}).Nameservers(); len(got) != 1 || got[0] != "9.9.9.9:53" {
+		t.Errorf("Nameservers() = %v, want the given nameserver", got)
+	}
+	if got := NewResolver(nil).Nameservers(); len(got) == 0 {
+		t.Error("Nameservers() without configured nameservers is empty")
+	}
+}
+
+func TestLookupCAA(t *testing.T) {
+	addr := startServer(t,
+		`example.com. 60 IN CAA 0 issue "letsencrypt.org"`,
+		`example.com. 60 IN CAA 128 IssueWild ";"`,
+		`example.com. 60 IN TXT "v=spf1 -all"`,
+		`www.example.com. 60 IN A 192.0.2.1`,
+	)
+	res := NewResolver([]string{addr})
+
+	got, err := res.LookupCAA(context.Background(), "example.com")
+	want := []CAA{{Tag: "issue", Value: "letsencrypt.org"}, {Flag: 128, Tag: "issuewild", Value: ";"}}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("LookupCAA() = %v, %v, want %v", got, err, want)
+	}
+
+	for _, name := range []string{"www.example.com", "shop.example.com"} {
+		if got, err := res.LookupCAA(context.Background(), name); err != nil || got != nil {
+			t.Errorf("LookupCAA(%q) = %v, %v, want no records", name, got, err)
+		}
+	}
+}
+
+func TestLookupNS(t *testing.T) {
+	addr := startServer(t,
+		"example.com. 60 IN NS NS1-01.Azure-DNS.com.",
+		"example.com. 60 IN NS ns2-01.azure-dns.net.",
+		"example.com. 60 IN CAA 0 issue \"letsencrypt.org\"",
+	)
+	res := NewResolver([]string{addr})
+
+	got, err := res.LookupNS(context.Background(), "example.com.")
+	if want := []string{"ns1-01.azure-dns.com", "ns2-01.azure-dns.net"}; err != nil || !slices.Equal(got, want) {
+		t.Errorf("LookupNS() = %v, %v, want %v", got, err, want)
+	}
+	if got, err := res.LookupNS(context.Background(), "www.example.com"); err != nil || got != nil {
+		t.Errorf("LookupNS() of a name without NS records = %v, %v, want none", got, err)
+	}
+}
+
+func TestLookupDelegationWithoutParentZone(t *testing.T) {
+	// No name of the hierarchy has NS records
+	res := NewResolver([]string{startServer(t)})
+	if parent, _, err := res.LookupDelegation(context.Background(), "example.com"); err == nil {
+		t.Errorf("LookupDelegation() = parent %q, want an error", parent)
+	}
+}
@@ -10,7 +10,9 @@ import (
 type AcmeUser struct {
 	Email        string
 	Registration *registration.Resource
-	key          crypto.PrivateKey
+	// ExternalAccountKeyID is the EAB key ID the account was registered with, empty without EAB
+	ExternalAccountKeyID string
+	key                  crypto.PrivateKey
 }
 
 func (u *AcmeUser) GetEmail() string {
@@ -37,7 +39,9 @@ func (u *AcmeUser) SetRegistration(reg *registration.Resource) {
 type Account struct {
 	Email        string                 `json:"email"`
 	Registration *registration.Resource `json:"registration"`
-	key          crypto.PrivateKey      // Not serialized to JSON
+	// ExternalAccountKeyID is not part of the lego format, lego ignores it when reading the account
+	ExternalAccountKeyID string            `json:"externalAccountKeyId,omitempty"`
+	key                  crypto.PrivateKey // Not serialized to JSON
 }
 
 func (a *Account) GetEmail() string {
@@ -0,0 +1,17 @@
+package utilities
+
+import "runtime/debug"
+
+// Version is the provisioner version, set at build time with -ldflags "-X azure-ssl-certificate-provisioner/internal/utilities.Version=<version>"
+var Version = ""
+
+// GetVersion returns the provisioner version, falling back to the module version recorded by the Go toolchain
+func GetVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,188 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+)
+
+// CAA property tags (RFC 8659)
+const (
+	CAATagIssue     = "issue"
+	CAATagIssueWild = "issuewild"
+
+	// caaCriticalFlag marks properties that a CA must understand to issue
+	caaCriticalFlag = 128
+)
+
+// knownCAATags are the property tags that do not block issuance when marked critical
+var knownCAATags = map[string]bool{
+	CAATagIssue:     true,
+	CAATagIssueWild: true,
+	"iodef":         true,
+	"issuemail":     true,
+	"issuevmc":      true,
+	"contactemail":  true,
+	"contactphone":  true,
+}
+
+// CAAPolicy identifies the CA, account and validation method that CAA records must permit
+type CAAPolicy struct {
+	// Identities are the issuer domain names of the CA (caaIdentities of its ACME directory)
+	Identities []string
+	// AccountURI is compared with accounturi parameters (RFC 8657); parameters never match when it is empty
+	AccountURI string
+	// ValidationMethod is compared with validationmethods parameters (RFC 8657) when set
+	ValidationMethod string
+}
+
+// CAAStatus is the result of the CAA check of a name
+type CAAStatus struct {
+	FQDN     string
+	Wildcard bool
+	// Name is the owner of the relevant CAA record set, empty when no name of the hierarchy has CAA records
+	Name    string
+	Records []resolver.CAA
+	// Property is the property tag that applies to the name (issue, or issuewild for wildcard names)
+	Property  string
+	Permitted bool
+	// Restricted reports that the CA is listed, but only for other accounts or validation methods
+	Restricted bool
+	// UnknownCritical is the tag of a critical property that blocks issuance by every CA
+	UnknownCritical string
+	Reason          string
+}
+
+// Domain returns the name the status applies to, with the wildcard label of wildcard names
+func (s *CAAStatus) Domain() string {
+	if s.Wildcard {
+		return "*." + s.FQDN
+	}
+	return s.FQDN
+}
+
+// CheckCAA finds the relevant CAA record set of a name by climbing the DNS hierarchy from the name
+// towards the root, and checks whether it permits the CA of the policy to issue for the name.
+// Wildcard names (*.fqdn) are checked against the issuewild properties of the record set, if any.
+func CheckCAA(ctx context.Context, res *resolver.Resolver, fqdn string, wildcard bool, policy CAAPolicy) (*CAAStatus, error) {
+	status := &CAAStatus{FQDN: strings.ToLower(strings.TrimSuffix(fqdn, ".")), Wildcard: wildcard}
+
+	for name := status.FQDN; name != ""; {
+		records, err := res.LookupCAA(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("CAA lookup for %s failed: %v", name, err)
+		}
+		if len(records) > 0 {
+			status.Name = name
+			status.Records = records
+			evaluateCAA(status, policy)
+			return status, nil
+		}
+
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			break
+		}
+		name = parent
+	}
+
+	status.Permitted = true
+	status.Reason = "no CAA records"
+	return status, nil
+}
+
+// CheckCAAs checks the CAA records of every name of a target
+func CheckCAAs(ctx context.Context, res *resolver.Resolver, target *Target, policy CAAPolicy) ([]*CAAStatus, error) {
+	var statuses []*CAAStatus
+	var errs []error
+	for _, domain := range target.Domains() {
+		fqdn, wildcard := strings.CutPrefix(domain, "*.")
+		status, err := CheckCAA(ctx, res, fqdn, wildcard, policy)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, errors.Join(errs...)
+}
+
+// evaluateCAA decides whether a CAA record set permits the CA of the policy to issue for the name of the status
+func evaluateCAA(status *CAAStatus, policy CAAPolicy) {
+	status.Property = CAATagIssue
+	if status.Wildcard && slices.ContainsFunc(status.Records, func(r resolver.CAA) bool { return r.Tag == CAATagIssueWild }) {
+		status.Property = CAATagIssueWild
+	}
+
+	var properties []resolver.CAA
+	for _, record := range status.Records {
+		if record.Flag&caaCriticalFlag != 0 && !knownCAATags[record.Tag] {
+			status.UnknownCritical = record.Tag
+			status.Reason = fmt.Sprintf("CAA record set at %s has an unknown critical property %q", status.Name, record.Tag)
+			return
+		}
+		if record.Tag == status.Property {
+			properties = append(properties, record)
+		}
+	}
+
+	if len(properties) == 0 {
+		status.Permitted = true
+		status.Reason = fmt.Sprintf("CAA record set at %s has no %s property", status.Name, status.Property)
+		return
+	}
+
+	for _, property := range properties {
+		issuer, parameters := parseCAAValue(property.Value)
+		if issuer == "" || !slices.ContainsFunc(policy.Identities, func(identity string) bool { return strings.EqualFold(identity, issuer) }) {
+			continue
+		}
+
+		if accountURI, ok := parameters["accounturi"]; ok && accountURI != policy.AccountURI {
+			status.Restricted = true
+			status.Reason = fmt.Sprintf("CAA record set at %s permits %s only for account %s (%s %q)", status.Name, issuer, accountURI, status.Property, property.Value)
+			continue
+		}
+		if methods, ok := parameters["validationmethods"]; ok && policy.ValidationMethod != "" &&
+			!slices.Contains(strings.Split(strings.ToLower(methods), ","), policy.ValidationMethod) {
+			status.Restricted = true
+			status.Reason = fmt.Sprintf("CAA record set at %s permits %s only with validation methods %s (%s %q)", status.Name, issuer, methods, status.Property, property.Value)
+			continue
+		}
+
+		status.Permitted = true
+		status.Restricted = false
+		status.Reason = fmt.Sprintf("CAA record set at %s permits %s (%s %q)", status.Name, issuer, status.Property, property.Value)
+		return
+	}
+
+	if !status.Restricted {
+		status.Reason = fmt.Sprintf("CAA record set at %s does not permit %s (%s)", status.Name, strings.Join(policy.Identities, ", "), caaValues(properties))
+	}
+}
+
+// parseCAAValue splits the value of an issue or issuewild property into the issuer domain name
+// and its parameters. An empty issuer domain name permits no CA.
+func parseCAAValue(value string) (string, map[string]string) {
+	parts := strings.Split(value, ";")
+	parameters := make(map[string]string)
+	for _, part := range parts[1:] {
+		if key, val, found := strings.Cut(strings.TrimSpace(part), "="); found {
+			parameters[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(parts[0]), ".")), parameters
+}
+
+// caaValues formats the values of CAA properties for log messages
+func caaValues(properties []resolver.CAA) string {
+	values := make([]string, 0, len(properties))
+	for _, property := range properties {
+		values = append(values, fmt.Sprintf("%s %q", property.Tag, property.Value))
+	}
+	return strings.Join(values, ", ")
+}
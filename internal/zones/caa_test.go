@@ -0,0 +1,123 @@
+package zones
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+)
+
+var letsEncrypt = CAAPolicy{
+	Identities:       []string{"letsencrypt.org"},
+	AccountURI:       "https://acme-v02.api.letsencrypt.org/acme/acct/1",
+	ValidationMethod: "dns-01",
+}
+
+func TestCheckCAAClimbsToRelevantRecordSet(t *testing.T) {
+	res := testResolver(t,
+		`example.com. 60 IN CAA 0 issue "pki.goog"`,
+		`example.com. 60 IN CAA 0 issue "letsencrypt.org"`,
+		`shop.example.com. 60 IN CAA 0 issue "pki.goog"`,
+	)
+	ctx := context.Background()
+
+	status, err := CheckCAA(ctx, res, "api.eu.example.com.", false, letsEncrypt)
+	if err != nil {
+		t.Fatalf("CheckCAA() error = %v", err)
+	}
+	if status.Name != "example.com" || !status.Permitted || len(status.Records) != 2 {
+		t.Errorf("CheckCAA() of api.eu.example.com = %+v, want the permitting record set of example.com", status)
+	}
+
+	// The record set closest to the name applies, even when a parent permits the CA
+	status, err = CheckCAA(ctx, res, "www.shop.example.com", false, letsEncrypt)
+	if err != nil {
+		t.Fatalf("CheckCAA() error = %v", err)
+	}
+	if status.Name != "shop.example.com" || status.Permitted || status.Restricted {
+		t.Errorf("CheckCAA() of www.shop.example.com = %+v, want the record set of shop.example.com refusing the CA", status)
+	}
+
+	status, err = CheckCAA(ctx, res, "www.example.net", false, letsEncrypt)
+	if err != nil || !status.Permitted || status.Name != "" {
+		t.Errorf("CheckCAA() without CAA records = %+v, %v, want permitted", status, err)
+	}
+}
+
+func TestCheckCAAs(t *testing.T) {
+	res := testResolver(t,
+		`example.com. 60 IN CAA 0 issue "letsencrypt.org"`,
+		`example.com. 60 IN CAA 0 issuewild ";"`,
+	)
+	target := &Target{Records: []*Record{
+		{FQDN: "example.com", Wildcard: true},
+		{FQDN: "www.example.com"},
+	}}
+
+	statuses, err := CheckCAAs(context.Background(), res, target, letsEncrypt)
+	if err != nil {
+		t.Fatalf("CheckCAAs() error = %v", err)
+	}
+	got := make(map[string]bool)
+	for _, status := range statuses {
+		got[status.Domain()] = status.Permitted
+	}
+	want := map[string]bool{"example.com": true, "*.example.com": false, "www.example.com": true}
+	if len(got) != len(want) {
+		t.Fatalf("CheckCAAs() checked %v, want %v", got, want)
+	}
+	for domain, permitted := range want {
+		if got[domain] != permitted {
+			t.Errorf("CheckCAAs() %s permitted = %t, want %t", domain, got[domain], permitted)
+		}
+	}
+}
+
+func TestEvaluateCAAAccountAndValidationMethod(t *testing.T) {
+	tests := map[string]struct {
+		values         []string
+		wantPermitted  bool
+		wantRestricted bool
+	}{
+		"matching account":           {values: []string{"letsencrypt.org; accounturi=https://acme-v02.api.letsencrypt.org/acme/acct/1"}, wantPermitted: true},
+		"other account":              {values: []string{"letsencrypt.org; accounturi=https://acme-v02.api.letsencrypt.org/acme/acct/2"}, wantRestricted: true},
+		"other account or any":       {values: []string{"letsencrypt.org; accounturi=https://acme-v02.api.letsencrypt.org/acme/acct/2", "letsencrypt.org"}, wantPermitted: true},
+		"matching validation method": {values: []string{"LetsEncrypt.org. ; ValidationMethods=http-01,DNS-01"}, wantPermitted: true},
+		"other validation method":    {values: []string{"letsencrypt.org; validationmethods=http-01"}, wantRestricted: true},
+		"other CA":                   {values: []string{"pki.goog"}},
+		"no CA":                      {values: []string{";"}},
+		"parameter without value":    {values: []string{"letsencrypt.org; reserved"}, wantPermitted: true},
+	}
+
+	for name, tt := range tests {
+		status := &CAAStatus{FQDN: "www.example.com", Name: "example.com"}
+		for _, value := range tt.values {
+			status.Records = append(status.Records, resolver.CAA{Tag: CAATagIssue, Value: value})
+		}
+		evaluateCAA(status, letsEncrypt)
+		if status.Permitted != tt.wantPermitted || status.Restricted != tt.wantRestricted {
+			t.Errorf("%s: permitted %t, restricted %t, want %t, %t (%s)", name, status.Permitted, status.Restricted, tt.wantPermitted, tt.wantRestricted, status.Reason)
+		}
+	}
+}
+
+func TestEvaluateCAACriticalProperties(t *testing.T) {
+	status := &CAAStatus{FQDN: "www.example.com", Name: "example.com", Records: []resolver.CAA{
+		{Flag: 128, Tag: CAATagIssue, Value: "letsencrypt.org"},
+		{Flag: 128, Tag: "iodef", Value: "mailto:security@example.com"},
+	}}
+	evaluateCAA(status, letsEncrypt)
+	if !status.Permitted {
+		t.Errorf("critical known properties: %s, want permitted", status.Reason)
+	}
+
+	status = &CAAStatus{FQDN: "www.example.com", Name: "example.com", Records: []resolver.CAA{
+		{Tag: CAATagIssue, Value: "letsencrypt.org"},
+		{Flag: 128, Tag: "tbs", Value: "unknown"},
+	}}
+	evaluateCAA(status, letsEncrypt)
+	if status.Permitted || status.UnknownCritical != "tbs" || !strings.Contains(status.Reason, "unknown critical property") {
+		t.Errorf("critical unknown property: %+v, want issuance blocked", status)
+	}
+}
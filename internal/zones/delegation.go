@@ -0,0 +1,132 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+)
+
+// challengeLabel is the label of the DNS-01 challenge record below a domain
+const challengeLabel = "_acme-challenge."
+
+// ChallengeAlias returns the name that the _acme-challenge record of the record is delegated to
+// with a CNAME record (acme-challenge-alias metadata), or an empty string without delegation
+func (r *Record) ChallengeAlias() string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(r.Metadata[MetadataChallengeAlias]), "."))
+}
+
+// SetChallengeAlias delegates the _acme-challenge record of the record to another name
+func (r *Record) SetChallengeAlias(alias string) {
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]string)
+	}
+	r.Metadata[MetadataChallengeAlias] = alias
+}
+
+// CheckChallengeDelegation verifies that _acme-challenge.<fqdn> is a CNAME record pointing to the alias
+func CheckChallengeDelegation(ctx context.Context, res *resolver.Resolver, fqdn, alias string) error {
+	name := challengeLabel + fqdn
+	target, err := res.LookupCNAME(ctx, name)
+	if err != nil {
+		return fmt.Errorf("challenge delegation of %s could not be checked: %v", fqdn, err)
+	}
+	if target == "" {
+		return fmt.Errorf("challenge delegation of %s is missing: %s has no CNAME record (expected %s)", fqdn, name, alias)
+	}
+	if target != alias {
+		return fmt.Errorf("challenge delegation of %s is wrong: %s points to %s (expected %s)", fqdn, name, target, alias)
+	}
+	return nil
+}
+
+// CheckChallengeDelegations verifies the challenge delegation of every record of a target with a challenge alias
+func CheckChallengeDelegations(ctx context.Context, res *resolver.Resolver, target *Target) error {
+	var errs []error
+	for _, record := range target.Records {
+		if alias := record.ChallengeAlias(); alias != "" {
+			if err := CheckChallengeDelegation(ctx, res, record.FQDN, alias); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Public delegation states of a DNS zone
+const (
+	DelegationOK        = "delegated"
+	DelegationPartial   = "partially delegated"
+	DelegationMissing   = "not delegated"
+	DelegationElsewhere = "delegated elsewhere"
+	DelegationUnknown   = "unknown"
+)
+
+// ZoneDelegation describes how the parent zone of an Azure DNS zone delegates it in public DNS
+type ZoneDelegation struct {
+	Zone   string
+	Parent string
+	// Expected are the nameservers Azure assigned to the zone
+	Expected []string
+	// Delegated are the nameservers of the NS records of the parent zone
+	Delegated []string
+	Status    string
+	Err       error
+}
+
+// Usable reports whether DNS validations in the zone can succeed: the parent zone delegates it to at
+// least one of its Azure nameservers, or the delegation could not be checked
+func (d *ZoneDelegation) Usable() bool {
+	return d.Status == DelegationOK || d.Status == DelegationPartial || d.Status == DelegationUnknown
+}
+
+// String describes the delegation for log messages
+func (d *ZoneDelegation) String() string {
+	switch d.Status {
+	case DelegationUnknown:
+		return fmt.Sprintf("%s (%v)", d.Status, d.Err)
+	case DelegationMissing:
+		return fmt.Sprintf("%s by %s (expected: %s)", d.Status, d.Parent, strings.Join(d.Expected, ", "))
+	default:
+		return fmt.Sprintf("%s by %s to %s (expected: %s)", d.Status, d.Parent, strings.Join(d.Delegated, ", "), strings.Join(d.Expected, ", "))
+	}
+}
+
+// CheckZoneDelegation compares the nameservers Azure assigned to a zone with the NS records that its
+// parent zone returns in public DNS
+func CheckZoneDelegation(ctx context.Context, res *resolver.Resolver, zone string, nameservers []string) *ZoneDelegation {
+	delegation := &ZoneDelegation{Zone: zone}
+	for _, ns := range nameservers {
+		delegation.Expected = append(delegation.Expected, strings.ToLower(strings.TrimSuffix(ns, ".")))
+	}
+
+	parent, delegated, err := res.LookupDelegation(ctx, zone)
+	delegation.Parent, delegation.Delegated = parent, delegated
+	if err != nil {
+		delegation.Status, delegation.Err = DelegationUnknown, err
+		return delegation
+	}
+	if len(delegated) == 0 {
+		delegation.Status = DelegationMissing
+		return delegation
+	}
+
+	matching := 0
+	for _, ns := range delegated {
+		if slices.Contains(delegation.Expected, ns) {
+			matching++
+		}
+	}
+	switch {
+	case matching == 0:
+		delegation.Status = DelegationElsewhere
+	case matching == len(delegated) && len(delegated) == len(delegation.Expected):
+		delegation.Status = DelegationOK
+	default:
+		delegation.Status = DelegationPartial
+	}
+	return delegation
+}
@@ -0,0 +1,134 @@
+package zones
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+)
+
+func TestChallengeAlias(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                              "",
+		"www.validation.example.net":    "www.validation.example.net",
+		" WWW.Validation.example.net. ": "www.validation.example.net",
+		"_acme-challenge.example.net.":  "_acme-challenge.example.net",
+	} {
+		record := &Record{FQDN: "www.example.com", Metadata: map[string]string{MetadataChallengeAlias: value}}
+		if got := record.ChallengeAlias(); got != want {
+			t.Errorf("ChallengeAlias() of %q = %q, want %q", value, got, want)
+		}
+	}
+
+	// Records built outside the enumerator may have no metadata
+	record := &Record{FQDN: "www.example.com"}
+	record.SetChallengeAlias("www.validation.example.net.")
+	if got := record.ChallengeAlias(); got != "www.validation.example.net" {
+		t.Errorf("ChallengeAlias() after SetChallengeAlias() = %q", got)
+	}
+}
+
+// testResolver returns a resolver querying a local nameserver that serves the resource records
+func testResolver(t *testing.T, records ...string) *resolver.Resolver {
+	t.Helper()
+
+	zone := make(map[string][]dns.RR)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %v", record, err)
+		}
+		zone[rr.Header().Name] = append(zone[rr.Header().Name], rr)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, NotifyStartedFunc: func() { close(started) }, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		for _, rr := range zone[req.Question[0].Name] {
+			if rr.Header().Rrtype == req.Question[0].Qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return resolver.NewResolver([]string{conn.LocalAddr().String()})
+}
+
+func TestCheckChallengeDelegations(t *testing.T) {
+	res := testResolver(t,
+		"_acme-challenge.www.example.com. 60 IN CNAME www.validation.example.net.",
+		"_acme-challenge.shop.example.com. 60 IN CNAME shop.old-validation.example.net.",
+	)
+
+	record := func(fqdn, alias string) *Record {
+		return &Record{FQDN: fqdn, Metadata: map[string]string{MetadataChallengeAlias: alias}}
+	}
+	target := &Target{Records: []*Record{
+		record("www.example.com", "www.validation.example.net"),
+		record("example.com", ""),
+	}}
+	if err := CheckChallengeDelegations(context.Background(), res, target); err != nil {
+		t.Errorf("CheckChallengeDelegations() of correct delegations error = %v", err)
+	}
+
+	target.Records = append(target.Records,
+		record("shop.example.com", "shop.validation.example.net"),
+		record("api.example.com", "api.validation.example.net"),
+	)
+	err := CheckChallengeDelegations(context.Background(), res, target)
+	if err == nil {
+		t.Fatal("CheckChallengeDelegations() of wrong and missing delegations succeeded")
+	}
+	for _, want := range []string{"delegation of shop.example.com is wrong", "delegation of api.example.com is missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckChallengeDelegations() error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestZoneDelegationStatus(t *testing.T) {
+	expected := []string{"ns1-01.azure-dns.com", "ns2-01.azure-dns.net"}
+	tests := []struct {
+		delegation ZoneDelegation
+		usable     bool
+		describes  string
+	}{
+		{ZoneDelegation{Parent: "com", Expected: expected, Delegated: expected, Status: DelegationOK}, true, "delegated by com to ns1-01.azure-dns.com, ns2-01.azure-dns.net"},
+		{ZoneDelegation{Parent: "com", Expected: expected, Delegated: expected[:1], Status: DelegationPartial}, true, "partially delegated by com to ns1-01.azure-dns.com"},
+		{ZoneDelegation{Parent: "com", Expected: expected, Status: DelegationMissing}, false, "not delegated by com (expected: ns1-01.azure-dns.com, ns2-01.azure-dns.net)"},
+		{ZoneDelegation{Parent: "com", Expected: expected, Delegated: []string{"ns1.example.net"}, Status: DelegationElsewhere}, false, "delegated elsewhere by com to ns1.example.net"},
+		{ZoneDelegation{Status: DelegationUnknown, Err: errors.New("timeout")}, true, "unknown (timeout)"},
+	}
+	for _, tt := range tests {
+		if got := tt.delegation.Usable(); got != tt.usable {
+			t.Errorf("Usable() of a zone %s = %t, want %t", tt.delegation.Status, got, tt.usable)
+		}
+		if got := tt.delegation.String(); !strings.HasPrefix(got, tt.describes) {
+			t.Errorf("String() = %q, want it to start with %q", got, tt.describes)
+		}
+	}
+}
+
+func TestCheckZoneDelegationUnknown(t *testing.T) {
+	res := testResolver(t)
+	delegation := CheckZoneDelegation(context.Background(), res, "example.com", []string{"NS1-01.azure-dns.com."})
+	if delegation.Status != DelegationUnknown || delegation.Err == nil || !delegation.Usable() {
+		t.Errorf("CheckZoneDelegation() without a parent zone = %+v, want an unknown, usable delegation", delegation)
+	}
+	if len(delegation.Expected) != 1 || delegation.Expected[0] != "ns1-01.azure-dns.com" {
+		t.Errorf("expected nameservers = %v, want them normalized", delegation.Expected)
+	}
+}
@@ -0,0 +1,76 @@
+package zones
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+type testCredential struct{}
+
+func (testCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// graphRows answers every Resource Graph query with the same rows
+type graphRows []map[string]any
+
+func (rows graphRows) Do(req *http.Request) (*http.Response, error) {
+	data, _ := json.Marshal(map[string]any{"data": rows, "count": len(rows), "totalRecords": len(rows), "resultTruncated": "false"})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}, nil
+}
+
+func TestDiscoverAndProcess(t *testing.T) {
+	recordSet := func(subscription, zone, recordType, name string, metadata map[string]any) map[string]any {
+		return map[string]any{
+			"id":         "/subscriptions/" + subscription + "/resourceGroups/dns-rg/providers/Microsoft.Network/dnszones/" + zone + "/" + recordType + "/" + name,
+			"name":       name,
+			"type":       "microsoft.network/dnszones/" + recordType,
+			"properties": map[string]any{"metadata": metadata},
+		}
+	}
+	client, err := armresourcegraph.NewClient(testCredential{}, &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: graphRows{
+		recordSet("sub-1", "example.com", "a", "www", map[string]any{"acme": "true", MetadataGroup: "web"}),
+		recordSet("sub-2", "example.com", "cname", "shop", map[string]any{"acme": "true", MetadataGroup: "web"}),
+		recordSet("sub-2", "example.net", "a", "www", map[string]any{"acme": "true"}),
+		recordSet("sub-1", "example.com", "a", "api", map[string]any{"acme": "false"}),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enumerator := NewEnumerator(&azure.Clients{ResourceGraph: client})
+
+	var targets []*Target
+	err = enumerator.DiscoverAndProcess(context.Background(), azure.GraphScope{Subscriptions: []string{"sub-1", "sub-2"}}, []string{"Example.com"}, 30, nil,
+		func(ctx context.Context, target *Target, expireThreshold int) { targets = append(targets, target) })
+	if err != nil {
+		t.Fatalf("DiscoverAndProcess() error = %v", err)
+	}
+
+	// The records of one zone name in two subscriptions form one group; example.net is not selected
+	if len(targets) != 1 || len(targets[0].Records) != 2 {
+		t.Fatalf("DiscoverAndProcess() targets = %+v, want the web group", targets)
+	}
+	for _, record := range targets[0].Records {
+		want := map[string]string{"www.example.com": "sub-1", "shop.example.com": "sub-2"}[record.FQDN]
+		if record.Subscription != want || record.ResourceGroup != "dns-rg" || record.Zone != "example.com" {
+			t.Errorf("record %s = subscription %q, resource group %q, zone %q, want subscription %q", record.FQDN, record.Subscription, record.ResourceGroup, record.Zone, want)
+		}
+	}
+}
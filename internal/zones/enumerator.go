@@ -3,19 +3,67 @@ package zones
 import (
 	"context"
 	"log"
+	"slices"
 	"strings"
+	"time"
 
+	"azure-ssl-certificate-provisioner/internal/resolver"
 	"azure-ssl-certificate-provisioner/pkg/azure"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
 )
 
-// ProcessorFunc defines the function signature for processing FQDNs
-type ProcessorFunc func(ctx context.Context, fqdn string, expireThreshold int)
+// Metadata keys understood on DNS record sets
+const (
+	MetadataACME     = "acme"
+	MetadataKeyType  = "acme-key-type"
+	MetadataGroup    = "acme-group"
+	MetadataWildcard = "acme-wildcard"
+	MetadataCertName = "acme-cert-name"
+
+	MetadataThreshold     = "acme-threshold"
+	MetadataVault         = "acme-vault"
+	MetadataServer        = "acme-server"
+	MetadataExtraSANs     = "acme-extra-sans"
+	MetadataDisabledUntil = "acme-disabled-until"
+
+	MetadataChallenge      = "acme-challenge"
+	MetadataChallengeAlias = "acme-challenge-alias"
+)
+
+// Record describes a DNS record set marked for ACME processing. Subscription is empty for records in
+// the subscription of the Azure clients. Settings holds the parsed per-record overrides of Metadata.
+type Record struct {
+	FQDN          string
+	Zone          string
+	ResourceGroup string
+	Subscription  string
+	Name          string
+	Type          string
+	Wildcard      bool
+	Metadata      map[string]string
+	Settings      Settings
+}
+
+// ProcessorFunc defines the function signature for processing certificate targets
+type ProcessorFunc func(ctx context.Context, target *Target, expireThreshold int)
+
+// PrepareFunc inspects all certificate targets before any of them is processed and returns the ones to process
+type PrepareFunc func(targets []*Target) []*Target
 
 // Enumerator handles DNS zone and record enumeration
 type Enumerator struct {
 	azureClients *azure.Clients
+
+	// delegationResolver checks the public delegation of the zones when set
+	delegationResolver *resolver.Resolver
+	delegations        []*ZoneDelegation
+
+	// metadataErrors are the invalid metadata values of the records found so far
+	metadataErrors []*MetadataError
+	// skipped are the records with invalid metadata or a suspension, kept until the targets are built
+	// so that the certificate of their group is skipped as a whole
+	skipped map[*Record]bool
 }
 
 // NewEnumerator creates a new zones enumerator
@@ -25,8 +73,26 @@ func NewEnumerator(azureClients *azure.Clients) *Enumerator {
 	}
 }
 
-// EnumerateAndProcess enumerates DNS zones and records, calling the processor function for each valid FQDN
-func (e *Enumerator) EnumerateAndProcess(ctx context.Context, zones []string, resourceGroupName string, expireThreshold int, processor ProcessorFunc) error {
+// SetDelegationCheck makes the enumerator compare the nameservers of every zone with the NS records of
+// its parent zone in public DNS, and skip zones that are not delegated to their Azure nameservers
+func (e *Enumerator) SetDelegationCheck(res *resolver.Resolver) {
+	e.delegationResolver = res
+}
+
+// ZoneDelegations returns the delegation status of the zones checked so far
+func (e *Enumerator) ZoneDelegations() []*ZoneDelegation {
+	return e.delegations
+}
+
+// MetadataErrors returns the invalid metadata values of the records found so far. Records with invalid
+// values are not processed.
+func (e *Enumerator) MetadataErrors() []*MetadataError {
+	return e.metadataErrors
+}
+
+// EnumerateAndProcess enumerates DNS zones and records, calling the processor function for each certificate target.
+// The optional prepare function receives all targets first, e.g. to assign and validate certificate names.
+func (e *Enumerator) EnumerateAndProcess(ctx context.Context, zones []string, resourceGroupName string, expireThreshold int, prepare PrepareFunc, processor ProcessorFunc) error {
 	// Determine which zones to process
 	zonesToProcess, err := e.determineZonesToProcess(ctx, zones, resourceGroupName)
 	if err != nil {
@@ -38,20 +104,117 @@ func (e *Enumerator) EnumerateAndProcess(ctx context.Context, zones []string, re
 		return nil
 	}
 
-	// Process zones
+	// Collect records from all zones first, so that groups can span zones
+	var records []*Record
 	for _, zone := range zonesToProcess {
-		if err := e.processZone(ctx, zone, resourceGroupName, expireThreshold, processor); err != nil {
+		zoneRecords, err := e.processZone(ctx, zone, resourceGroupName)
+		if err != nil {
 			log.Printf("Zone processing failed: zone=%s, error=%v", zone, err)
 			continue
 		}
+		records = append(records, zoneRecords...)
+	}
+
+	targets := e.processableTargets(BuildTargets(records))
+	if prepare != nil {
+		targets = prepare(targets)
+	}
+
+	// Process certificate targets
+	for _, target := range targets {
+		processor(ctx, target, expireThreshold)
+	}
+
+	return nil
+}
+
+// ListZones returns the zones that EnumerateAndProcess would process: the given zones, or all
+// DNS zones of the resource group when none are given
+func (e *Enumerator) ListZones(ctx context.Context, zones []string, resourceGroupName string) ([]string, error) {
+	return e.determineZonesToProcess(ctx, zones, resourceGroupName)
+}
+
+// DiscoverAndProcess finds the records marked for ACME processing in all DNS zones of the scope with
+// Azure Resource Graph, instead of walking the zones of one resource group, and calls the processor
+// function for each certificate target. Each record keeps its own subscription, resource group and zone.
+// When zones are given, only records in zones with these names are processed.
+func (e *Enumerator) DiscoverAndProcess(ctx context.Context, scope azure.GraphScope, zones []string, expireThreshold int, prepare PrepareFunc, processor ProcessorFunc) error {
+	log.Printf("Discovering records with Resource Graph: %s", scope)
+	recordSets, err := azure.QueryACMERecordSets(ctx, e.azureClients.ResourceGraph, scope)
+	if err != nil {
+		log.Printf("Record discovery failed: error=%v", err)
+		return err
+	}
+
+	skipped, err := e.undelegatedGraphZones(ctx, scope)
+	if err != nil {
+		return err
+	}
+
+	var records []*Record
+	for _, found := range recordSets {
+		if len(zones) > 0 && !slices.ContainsFunc(zones, func(zone string) bool { return strings.EqualFold(zone, found.Zone.Name) }) {
+			continue
+		}
+		if !isACMERecordSet(found.RecordSet) {
+			continue
+		}
+
+		record, errs := newRecord(found.RecordSet, found.Zone.Name, found.Zone.ResourceGroup)
+		record.Subscription = found.Zone.Subscription
+		if skipped[found.Zone] {
+			// Keep the record, so that a group with members in the zone is skipped as a whole
+			e.skip(record)
+		} else {
+			e.shouldProcessRecord(record, errs)
+		}
+
+		log.Printf("Found record %s (%s) in subscription %s, resource group %s.", record.FQDN, record.Type, record.Subscription, record.ResourceGroup)
+		records = append(records, record)
+	}
+
+	log.Printf("Discovered %d record(s) marked for ACME processing", len(records))
+
+	targets := e.processableTargets(BuildTargets(records))
+	if prepare != nil {
+		targets = prepare(targets)
+	}
+
+	for _, target := range targets {
+		processor(ctx, target, expireThreshold)
 	}
 
 	return nil
 }
 
+// undelegatedGraphZones checks the public delegation of the DNS zones of a Resource Graph scope when
+// the delegation check is enabled, and returns the zones to skip
+func (e *Enumerator) undelegatedGraphZones(ctx context.Context, scope azure.GraphScope) (map[azure.DNSZone]bool, error) {
+	skipped := make(map[azure.DNSZone]bool)
+	if e.delegationResolver == nil {
+		return skipped, nil
+	}
+
+	graphZones, err := azure.QueryDNSZones(ctx, e.azureClients.ResourceGraph, scope)
+	if err != nil {
+		log.Printf("DNS zone discovery failed: error=%v", err)
+		return nil, err
+	}
+
+	for _, graphZone := range graphZones {
+		delegation := CheckZoneDelegation(ctx, e.delegationResolver, graphZone.Zone.Name, graphZone.NameServers)
+		e.delegations = append(e.delegations, delegation)
+		if !e.logDelegation(delegation) {
+			skipped[graphZone.Zone] = true
+		}
+	}
+	return skipped, nil
+}
+
 // determineZonesToProcess determines which zones to process based on input
 func (e *Enumerator) determineZonesToProcess(ctx context.Context, zones []string, resourceGroupName string) ([]string, error) {
 	var zonesToProcess []string
+	nameservers := make(map[string][]string)
 
 	if len(zones) == 0 {
 		// If no zones specified, get all zones from the resource group
@@ -68,6 +231,7 @@ func (e *Enumerator) determineZonesToProcess(ctx context.Context, zones []string
 			for _, zone := range zonesPage.Value {
 				if zone != nil && zone.Name != nil {
 					zonesToProcess = append(zonesToProcess, *zone.Name)
+					nameservers[*zone.Name] = zoneNameservers(zone)
 				}
 			}
 		}
@@ -78,19 +242,76 @@ func (e *Enumerator) determineZonesToProcess(ctx context.Context, zones []string
 		log.Printf("Processing specified zones: %v", zonesToProcess)
 	}
 
+	if e.delegationResolver != nil {
+		zonesToProcess = e.delegatedZones(ctx, zonesToProcess, nameservers, resourceGroupName)
+	}
+
 	return zonesToProcess, nil
 }
 
-// processZone processes a single DNS zone
-func (e *Enumerator) processZone(ctx context.Context, zone string, resourceGroupName string, expireThreshold int, processor ProcessorFunc) error {
+// delegatedZones checks the public delegation of the zones and returns the ones that are delegated
+// to their Azure nameservers, or whose delegation could not be checked
+func (e *Enumerator) delegatedZones(ctx context.Context, zones []string, nameservers map[string][]string, resourceGroupName string) []string {
+	var delegated []string
+	for _, zone := range zones {
+		zoneNS, ok := nameservers[zone]
+		if !ok {
+			resp, err := e.azureClients.DNSZones.Get(ctx, resourceGroupName, zone, nil)
+			if err != nil {
+				log.Printf("DNS zone lookup failed: zone=%s, error=%v", zone, err)
+				delegated = append(delegated, zone)
+				continue
+			}
+			zoneNS = zoneNameservers(&resp.Zone)
+		}
+
+		delegation := CheckZoneDelegation(ctx, e.delegationResolver, zone, zoneNS)
+		e.delegations = append(e.delegations, delegation)
+		if e.logDelegation(delegation) {
+			delegated = append(delegated, zone)
+		}
+	}
+	return delegated
+}
+
+// logDelegation logs the delegation status of a zone and reports whether the zone is processed
+func (e *Enumerator) logDelegation(delegation *ZoneDelegation) bool {
+	switch {
+	case delegation.Status == DelegationOK:
+		log.Printf("Zone delegation verified: zone=%s, parent=%s", delegation.Zone, delegation.Parent)
+	case delegation.Usable():
+		log.Printf("Warning: Zone delegation could not be fully verified: zone=%s, status=%s", delegation.Zone, delegation)
+	default:
+		log.Printf("Warning: Skipping zone without public delegation to Azure DNS: zone=%s, status=%s", delegation.Zone, delegation)
+		return false
+	}
+	return true
+}
+
+// zoneNameservers returns the nameservers Azure assigned to a zone
+func zoneNameservers(zone *armdns.Zone) []string {
+	var nameservers []string
+	if zone.Properties != nil {
+		for _, ns := range zone.Properties.NameServers {
+			if ns != nil {
+				nameservers = append(nameservers, *ns)
+			}
+		}
+	}
+	return nameservers
+}
+
+// processZone collects the records marked for ACME processing in a single DNS zone
+func (e *Enumerator) processZone(ctx context.Context, zone string, resourceGroupName string) ([]*Record, error) {
 	log.Printf("Processing DNS zone: %s", zone)
 	pager := e.azureClients.DNS.NewListAllByDNSZonePager(resourceGroupName, zone, nil)
 
+	var records []*Record
 	for pager.More() {
 		page, err := pager.NextPage(ctx)
 		if err != nil {
 			log.Printf("Record set listing failed: zone=%s, error=%v", zone, err)
-			return err
+			return nil, err
 		}
 
 		for _, rs := range page.Value {
@@ -99,28 +320,83 @@ func (e *Enumerator) processZone(ctx context.Context, zone string, resourceGroup
 			}
 
 			// Check if this record should be processed
-			if !e.shouldProcessRecord(rs) {
+			if !isACMERecordSet(rs) {
 				continue
 			}
 
-			fqdn := *rs.Name + "." + zone
-			rsType := strings.TrimPrefix(*rs.Type, "Microsoft.Network/dnszones/")
+			record, errs := newRecord(rs, zone, resourceGroupName)
+			e.shouldProcessRecord(record, errs)
 
-			log.Printf("Found record %s (%s).", fqdn, rsType)
-			processor(ctx, fqdn, expireThreshold)
+			log.Printf("Found record %s (%s).", record.FQDN, record.Type)
+			records = append(records, record)
 		}
 	}
 
-	return nil
+	return records, nil
 }
 
-// shouldProcessRecord determines if a DNS record should be processed
-func (e *Enumerator) shouldProcessRecord(rs *armdns.RecordSet) bool {
+// shouldProcessRecord determines if a record marked for ACME processing should be processed. Records with
+// invalid metadata values are reported and skipped, so that no certificate is issued with settings the
+// record did not ask for, and records with acme-disabled-until in the future are skipped. Skipped records
+// are remembered, and the targets containing them are dropped by processableTargets.
+func (e *Enumerator) shouldProcessRecord(record *Record, errs []*MetadataError) bool {
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("Warning: Invalid record metadata: fqdn=%s, key=%s, value=%q, error=%v", err.FQDN, err.Key, err.Value, err.Err)
+		}
+		log.Printf("Warning: Skipping record with invalid metadata: fqdn=%s", record.FQDN)
+		e.metadataErrors = append(e.metadataErrors, errs...)
+		e.skip(record)
+		return false
+	}
+
+	if until := record.Settings.DisabledUntil; time.Now().Before(until) {
+		log.Printf("Record disabled: fqdn=%s, until=%s", record.FQDN, until.Format(time.RFC3339))
+		e.skip(record)
+		return false
+	}
+
+	return true
+}
+
+// skip remembers a record that is not processed
+func (e *Enumerator) skip(record *Record) {
+	if e.skipped == nil {
+		e.skipped = make(map[*Record]bool)
+	}
+	e.skipped[record] = true
+}
+
+// processableTargets drops the targets with a skipped record. A group certificate is skipped as a whole
+// when one of its members is skipped, because issuing it without that member would remove a name that
+// the current certificate covers.
+func (e *Enumerator) processableTargets(targets []*Target) []*Target {
+	var result []*Target
+	for _, target := range targets {
+		var skipped []string
+		for _, record := range target.Records {
+			if e.skipped[record] {
+				skipped = append(skipped, record.FQDN)
+			}
+		}
+		if len(skipped) == 0 {
+			result = append(result, target)
+			continue
+		}
+		if target.Group != "" {
+			log.Printf("Warning: Skipping certificate group with skipped members: group=%s, skipped=%s", target.Group, strings.Join(skipped, ","))
+		}
+	}
+	return result
+}
+
+// isACMERecordSet determines if a DNS record set is an A or CNAME record set marked with acme=true
+func isACMERecordSet(rs *armdns.RecordSet) bool {
 	if rs.Properties == nil || rs.Properties.Metadata == nil {
 		return false
 	}
 
-	val, ok := rs.Properties.Metadata["acme"]
+	val, ok := rs.Properties.Metadata[MetadataACME]
 	if val == nil || !ok || strings.ToLower(*val) != "true" {
 		return false
 	}
@@ -136,3 +412,32 @@ func (e *Enumerator) shouldProcessRecord(rs *armdns.RecordSet) bool {
 
 	return true
 }
+
+// newRecord builds a record descriptor from an Azure DNS record set and parses its override metadata
+func newRecord(rs *armdns.RecordSet, zone, resourceGroupName string) (*Record, []*MetadataError) {
+	metadata := make(map[string]string, len(rs.Properties.Metadata))
+	for key, value := range rs.Properties.Metadata {
+		if value != nil {
+			metadata[strings.ToLower(key)] = *value
+		}
+	}
+
+	// The "@" record set represents the zone apex
+	fqdn := *rs.Name + "." + zone
+	if *rs.Name == "@" {
+		fqdn = zone
+	}
+
+	settings, errs := ParseSettings(fqdn, metadata)
+
+	return &Record{
+		FQDN:          fqdn,
+		Zone:          zone,
+		ResourceGroup: resourceGroupName,
+		Name:          *rs.Name,
+		Type:          strings.TrimPrefix(*rs.Type, "Microsoft.Network/dnszones/"),
+		Wildcard:      strings.ToLower(metadata[MetadataWildcard]) == "true",
+		Metadata:      metadata,
+		Settings:      settings,
+	}, errs
+}
@@ -0,0 +1,105 @@
+package zones
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+)
+
+func TestNewRecord(t *testing.T) {
+	recordSet := func(name string, metadata map[string]*string) *armdns.RecordSet {
+		return &armdns.RecordSet{
+			Name:       to.Ptr(name),
+			Type:       to.Ptr("Microsoft.Network/dnszones/A"),
+			Properties: &armdns.RecordSetProperties{Metadata: metadata},
+		}
+	}
+
+	apex, _ := newRecord(recordSet("@", map[string]*string{"ACME": to.Ptr("true")}), "example.com", "dns-rg")
+	if apex.FQDN != "example.com" || apex.Name != "@" || apex.Type != "A" || apex.ResourceGroup != "dns-rg" {
+		t.Errorf("apex record = %+v, want FQDN example.com", apex)
+	}
+	if apex.Metadata[MetadataACME] != "true" {
+		t.Errorf("apex metadata = %v, want lower-case keys", apex.Metadata)
+	}
+
+	wildcard, _ := newRecord(recordSet("*", nil), "example.com", "dns-rg")
+	if wildcard.FQDN != "*.example.com" || wildcard.Wildcard {
+		t.Errorf("wildcard record set = %+v, want FQDN *.example.com without the acme-wildcard flag", wildcard)
+	}
+
+	flagged, _ := newRecord(recordSet("www", map[string]*string{MetadataWildcard: to.Ptr("True"), "empty": nil}), "example.com", "dns-rg")
+	if flagged.FQDN != "www.example.com" || !flagged.Wildcard {
+		t.Errorf("acme-wildcard record = %+v, want FQDN www.example.com with the wildcard flag", flagged)
+	}
+	if _, ok := flagged.Metadata["empty"]; ok {
+		t.Error("metadata without value was kept")
+	}
+
+	overridden, errs := newRecord(recordSet("shop", map[string]*string{MetadataThreshold: to.Ptr("21"), MetadataServer: to.Ptr("example")}), "example.com", "dns-rg")
+	if overridden.Settings.Threshold == nil || *overridden.Settings.Threshold != 21 {
+		t.Errorf("record settings = %+v, want the parsed threshold", overridden.Settings)
+	}
+	if len(errs) != 1 || errs[0].Key != MetadataServer {
+		t.Errorf("newRecord() errors = %v, want the invalid server", errs)
+	}
+}
+
+func TestShouldProcessRecord(t *testing.T) {
+	enumerator := NewEnumerator(nil)
+	record := &Record{FQDN: "www.example.com"}
+
+	if !enumerator.shouldProcessRecord(record, nil) {
+		t.Error("record without overrides is skipped")
+	}
+
+	record.Settings.DisabledUntil = time.Now().Add(time.Hour)
+	if enumerator.shouldProcessRecord(record, nil) {
+		t.Error("record disabled until a future time is processed")
+	}
+	record.Settings.DisabledUntil = time.Now().Add(-time.Hour)
+	if !enumerator.shouldProcessRecord(record, nil) {
+		t.Error("record disabled until a past time is skipped")
+	}
+
+	invalid := &MetadataError{FQDN: record.FQDN, Key: MetadataThreshold, Value: "soon"}
+	if enumerator.shouldProcessRecord(record, []*MetadataError{invalid}) {
+		t.Error("record with invalid metadata is processed")
+	}
+	if errs := enumerator.MetadataErrors(); len(errs) != 1 || errs[0] != invalid {
+		t.Errorf("MetadataErrors() = %v, want the invalid value", errs)
+	}
+}
+
+func TestProcessableTargetsSkipGroupsWithSkippedMembers(t *testing.T) {
+	enumerator := NewEnumerator(nil)
+	suspended := &Record{FQDN: "shop.example.com", Metadata: map[string]string{MetadataGroup: "web"}, Settings: Settings{DisabledUntil: time.Now().Add(time.Hour)}}
+	enumerator.shouldProcessRecord(suspended, nil)
+
+	records := []*Record{
+		{FQDN: "www.example.com", Metadata: map[string]string{MetadataGroup: "web"}},
+		suspended,
+		{FQDN: "api.example.com"},
+		{FQDN: "mail.example.com", Metadata: map[string]string{MetadataGroup: "mail"}},
+	}
+	var names []string
+	for _, target := range enumerator.processableTargets(BuildTargets(records)) {
+		names = append(names, target.Name())
+	}
+	if len(names) != 2 || !slices.Contains(names, "api.example.com") || slices.Contains(names, "web") {
+		t.Errorf("processableTargets() = %v, want the targets without the web group", names)
+	}
+}
+
+func TestZoneNameservers(t *testing.T) {
+	zone := &armdns.Zone{Properties: &armdns.ZoneProperties{NameServers: []*string{to.Ptr("ns1-01.azure-dns.com."), nil, to.Ptr("ns2-01.azure-dns.net.")}}}
+	if got := zoneNameservers(zone); len(got) != 2 || got[0] != "ns1-01.azure-dns.com." || got[1] != "ns2-01.azure-dns.net." {
+		t.Errorf("zoneNameservers() = %v", got)
+	}
+	if got := zoneNameservers(&armdns.Zone{}); got != nil {
+		t.Errorf("zoneNameservers() of a zone without properties = %v, want none", got)
+	}
+}
@@ -0,0 +1,132 @@
+package zones
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"azure-ssl-certificate-provisioner/internal/keytypes"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+)
+
+// validSAN matches DNS names accepted as additional subject alternative names, optionally with a wildcard label
+var validSAN = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Settings are the per-record overrides of the global settings, parsed from the metadata of a record set.
+// Empty values select the global setting.
+type Settings struct {
+	// Threshold is the renewal threshold in days (acme-threshold), nil for the expire-threshold setting
+	Threshold *int
+	// KeyType is the lowercase key type name (acme-key-type)
+	KeyType string
+	// Vault is the URL of the Key Vault the certificate is stored in (acme-vault)
+	Vault string
+	// Server is the ACME directory URL the certificate is ordered from (acme-server, a preset name or URL)
+	Server string
+	// CertName is the certificate name (acme-cert-name), checked when the targets are named
+	CertName string
+	// ExtraSANs are additional names of the certificate (acme-extra-sans)
+	ExtraSANs []string
+	// DisabledUntil suspends processing of the record until the given time (acme-disabled-until)
+	DisabledUntil time.Time
+}
+
+// MetadataError reports an invalid metadata value of a record set
+type MetadataError struct {
+	FQDN  string
+	Key   string
+	Value string
+	Err   error
+}
+
+// Error implements the error interface
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("invalid %s metadata %q on %s: %v", e.Key, e.Value, e.FQDN, e.Err)
+}
+
+// ParseSettings parses the override metadata of a record set. Invalid values are returned as errors and
+// leave the corresponding setting empty.
+func ParseSettings(fqdn string, metadata map[string]string) (Settings, []*MetadataError) {
+	var settings Settings
+	var errs []*MetadataError
+	invalid := func(key string, err error) {
+		errs = append(errs, &MetadataError{FQDN: fqdn, Key: key, Value: metadata[key], Err: err})
+	}
+
+	if value := strings.TrimSpace(metadata[MetadataThreshold]); value != "" {
+		if days, err := strconv.Atoi(value); err != nil || days < 0 {
+			invalid(MetadataThreshold, fmt.Errorf("expected a number of days"))
+		} else {
+			settings.Threshold = &days
+		}
+	}
+
+	if value := strings.ToLower(strings.TrimSpace(metadata[MetadataKeyType])); value != "" {
+		if _, err := keytypes.Parse(value); err != nil {
+			invalid(MetadataKeyType, err)
+		} else {
+			settings.KeyType = value
+		}
+	}
+	settings.CertName = strings.TrimSpace(metadata[MetadataCertName])
+
+	if value := strings.TrimSpace(metadata[MetadataVault]); value != "" {
+		if vault, err := parseVaultURL(value); err != nil {
+			invalid(MetadataVault, err)
+		} else {
+			settings.Vault = vault
+		}
+	}
+
+	if value := strings.TrimSpace(metadata[MetadataServer]); value != "" {
+		if server, err := acme.ResolveServerURL(value, false); err != nil {
+			invalid(MetadataServer, err)
+		} else {
+			settings.Server = server
+		}
+	}
+
+	for _, san := range strings.FieldsFunc(strings.ToLower(metadata[MetadataExtraSANs]), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		san = strings.TrimSuffix(san, ".")
+		if !validSAN.MatchString(san) {
+			invalid(MetadataExtraSANs, fmt.Errorf("%q is not a DNS name", san))
+			continue
+		}
+		settings.ExtraSANs = append(settings.ExtraSANs, san)
+	}
+
+	if value := strings.TrimSpace(metadata[MetadataDisabledUntil]); value != "" {
+		if until, err := parseDisabledUntil(value); err != nil {
+			invalid(MetadataDisabledUntil, err)
+		} else {
+			settings.DisabledUntil = until
+		}
+	}
+
+	return settings, errs
+}
+
+// parseVaultURL checks a Key Vault URL and returns it without a trailing slash
+func parseVaultURL(value string) (string, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || strings.Trim(parsed.Path, "/") != "" {
+		return "", fmt.Errorf("expected a Key Vault URL, e.g. https://my-vault.vault.azure.net")
+	}
+	return "https://" + strings.ToLower(parsed.Host), nil
+}
+
+// parseDisabledUntil parses an RFC 3339 time or a date, which ends the suspension at midnight UTC
+func parseDisabledUntil(value string) (time.Time, error) {
+	if until, err := time.Parse(time.RFC3339, value); err == nil {
+		return until, nil
+	}
+	if until, err := time.Parse(time.DateOnly, value); err == nil {
+		return until, nil
+	}
+	return time.Time{}, fmt.Errorf("expected a date (2006-01-02) or an RFC 3339 time (2006-01-02T15:04:05Z)")
+}
@@ -0,0 +1,90 @@
+package zones
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSettings(t *testing.T) {
+	settings, errs := ParseSettings("www.example.com", map[string]string{
+		MetadataACME:          "true",
+		MetadataThreshold:     " 30 ",
+		MetadataKeyType:       "EC256",
+		MetadataCertName:      " web ",
+		MetadataVault:         "https://My-Vault.vault.azure.net/",
+		MetadataServer:        "zerossl",
+		MetadataExtraSANs:     "Example.com., *.example.com  www.example.net",
+		MetadataDisabledUntil: "2030-01-02",
+	})
+	if len(errs) != 0 {
+		t.Fatalf("ParseSettings() errors = %v", errs)
+	}
+	if settings.Threshold == nil || *settings.Threshold != 30 {
+		t.Errorf("Threshold = %v, want 30", settings.Threshold)
+	}
+	if settings.KeyType != "ec256" || settings.CertName != "web" {
+		t.Errorf("KeyType, CertName = %q, %q, want ec256, web", settings.KeyType, settings.CertName)
+	}
+	if settings.Vault != "https://my-vault.vault.azure.net" {
+		t.Errorf("Vault = %q, want the URL without trailing slash", settings.Vault)
+	}
+	if settings.Server != "https://acme.zerossl.com/v2/DV90" {
+		t.Errorf("Server = %q, want the directory of the zerossl preset", settings.Server)
+	}
+	if want := []string{"example.com", "*.example.com", "www.example.net"}; !slices.Equal(settings.ExtraSANs, want) {
+		t.Errorf("ExtraSANs = %v, want %v", settings.ExtraSANs, want)
+	}
+	if want := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC); !settings.DisabledUntil.Equal(want) {
+		t.Errorf("DisabledUntil = %s, want %s", settings.DisabledUntil, want)
+	}
+
+	// A record without overrides uses the global settings
+	if settings, errs := ParseSettings("www.example.com", map[string]string{MetadataACME: "true"}); len(errs) != 0 || settings.Threshold != nil || settings.KeyType != "" || settings.Vault != "" || settings.ExtraSANs != nil {
+		t.Errorf("ParseSettings() without overrides = %+v, %v", settings, errs)
+	}
+}
+
+func TestParseSettingsInvalidValues(t *testing.T) {
+	invalid := map[string][]string{
+		MetadataThreshold:     {"-1", "30d"},
+		MetadataKeyType:       {"ec521", "rsa8192"},
+		MetadataVault:         {"http://my-vault.vault.azure.net", "https://my-vault.vault.azure.net/secrets", "my-vault"},
+		MetadataServer:        {"example", "ftp://acme.example.com/directory"},
+		MetadataDisabledUntil: {"tomorrow", "02.01.2030"},
+	}
+	for key, values := range invalid {
+		for _, value := range values {
+			settings, errs := ParseSettings("www.example.com", map[string]string{key: value})
+			if len(errs) != 1 || errs[0].Key != key || errs[0].Value != value || errs[0].FQDN != "www.example.com" {
+				t.Errorf("ParseSettings() of %s %q errors = %v, want one naming the record and value", key, value, errs)
+			}
+			if settings.Threshold != nil || settings.KeyType != "" || settings.Vault != "" || settings.Server != "" || !settings.DisabledUntil.IsZero() {
+				t.Errorf("ParseSettings() of %s %q = %+v, want the setting left empty", key, value, settings)
+			}
+		}
+	}
+
+	// Valid additional names are kept next to an invalid one
+	settings, errs := ParseSettings("www.example.com", map[string]string{MetadataExtraSANs: "www.example.com,bad_name.example.com"})
+	if len(errs) != 1 || errs[0].Key != MetadataExtraSANs || !slices.Equal(settings.ExtraSANs, []string{"www.example.com"}) {
+		t.Errorf("ParseSettings() of an invalid extra SAN = %v, %v", settings.ExtraSANs, errs)
+	}
+}
+
+func TestParseDisabledUntil(t *testing.T) {
+	for value, want := range map[string]time.Time{
+		"2030-01-02":                time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC),
+		"2030-01-02T15:04:05Z":      time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+		"2030-01-02T15:04:05+02:00": time.Date(2030, 1, 2, 13, 4, 5, 0, time.UTC),
+	} {
+		if got, err := parseDisabledUntil(value); err != nil || !got.Equal(want) {
+			t.Errorf("parseDisabledUntil(%q) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"2030-01-02 15:04", "2030-13-01"} {
+		if got, err := parseDisabledUntil(value); err == nil {
+			t.Errorf("parseDisabledUntil(%q) = %s, want an error", value, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package zones
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Target describes a single certificate covering one or more DNS records
+type Target struct {
+	Group   string
+	Records []*Record
+	// CertName is the certificate name assigned before processing, empty to use the default name
+	CertName string
+}
+
+// Name returns the group name of a grouped target, or the FQDN of a single-record target
+func (t *Target) Name() string {
+	if t.Group != "" {
+		return t.Group
+	}
+	return t.Records[0].FQDN
+}
+
+// Domains returns the distinct names covered by the target in enumeration order.
+// Records flagged with acme-wildcard contribute both their FQDN and "*." + FQDN, followed by
+// the names of their acme-extra-sans metadata. The first domain becomes the certificate common name.
+func (t *Target) Domains() []string {
+	seen := make(map[string]bool, len(t.Records))
+	var domains []string
+	add := func(domain string) {
+		domain = strings.ToLower(domain)
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+
+	for _, record := range t.Records {
+		add(record.FQDN)
+		if record.Wildcard {
+			add("*." + record.FQDN)
+		}
+		for _, san := range record.Settings.ExtraSANs {
+			add(san)
+		}
+	}
+	return domains
+}
+
+// Threshold returns the renewal threshold in days of the target: the largest acme-threshold override
+// of its records, so that the certificate is renewed in time for every record, or the default threshold
+func (t *Target) Threshold(defaultThreshold int) int {
+	threshold := -1
+	for _, record := range t.Records {
+		if record.Settings.Threshold != nil && *record.Settings.Threshold > threshold {
+			threshold = *record.Settings.Threshold
+		}
+	}
+	if threshold < 0 {
+		return defaultThreshold
+	}
+	return threshold
+}
+
+// Vault returns the Key Vault URL of the acme-vault override, or an empty string for the configured vault.
+// All records of a group that set the override must agree on its value.
+func (t *Target) Vault() (string, error) {
+	return t.setting("Key Vaults", func(s Settings) string { return s.Vault })
+}
+
+// Server returns the ACME directory URL of the acme-server override, or an empty string for the configured
+// server. All records of a group that set the override must agree on its value.
+func (t *Target) Server() (string, error) {
+	return t.setting("ACME servers", func(s Settings) string { return s.Server })
+}
+
+// KeyType returns the key type name of the acme-key-type override, or an empty string for the configured
+// key type. All records of a group that set the override must agree on its value.
+func (t *Target) KeyType() (string, error) {
+	return t.setting("key types", func(s Settings) string { return s.KeyType })
+}
+
+// setting returns the value of an override shared by the records of the target
+func (t *Target) setting(description string, value func(Settings) string) (string, error) {
+	override := ""
+	for _, record := range t.Records {
+		v := value(record.Settings)
+		if v == "" {
+			continue
+		}
+		if override != "" && override != v {
+			return "", fmt.Errorf("conflicting %s %q and %q in group %s", description, override, v, t.Group)
+		}
+		override = v
+	}
+	return override, nil
+}
+
+// BuildTargets groups records sharing the acme-group metadata value into multi-SAN targets.
+// Records without a group get a target of their own. Targets keep the order in which
+// their first record was found.
+func BuildTargets(records []*Record) []*Target {
+	var targets []*Target
+	groups := make(map[string]*Target)
+
+	for _, record := range records {
+		group := strings.ToLower(strings.TrimSpace(record.Metadata[MetadataGroup]))
+		if group == "" {
+			targets = append(targets, &Target{Records: []*Record{record}})
+			continue
+		}
+
+		target, ok := groups[group]
+		if !ok {
+			target = &Target{Group: group}
+			groups[group] = target
+			targets = append(targets, target)
+		}
+		target.Records = append(target.Records, record)
+	}
+
+	for _, target := range targets {
+		if target.Group != "" {
+			log.Printf("Certificate group %s: domains=%s", target.Group, strings.Join(target.Domains(), ","))
+		}
+	}
+
+	return targets
+}
@@ -0,0 +1,180 @@
+package zones
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func record(fqdn, group string) *Record {
+	metadata := map[string]string{MetadataACME: "true"}
+	if group != "" {
+		metadata[MetadataGroup] = group
+	}
+	return &Record{FQDN: fqdn, Metadata: metadata}
+}
+
+func TestBuildTargets(t *testing.T) {
+	records := []*Record{
+		record("www.example.com", "Web"),
+		record("mail.example.com", ""),
+		record("example.net", "web "),
+		record("api.example.com", "api"),
+		record("www.example.net", "WEB"),
+	}
+
+	targets := BuildTargets(records)
+
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name())
+	}
+	if want := []string{"web", "mail.example.com", "api"}; !slices.Equal(names, want) {
+		t.Fatalf("BuildTargets() names = %v, want %v", names, want)
+	}
+
+	web := targets[0]
+	if want := []string{"www.example.com", "example.net", "www.example.net"}; !slices.Equal(web.Domains(), want) {
+		t.Errorf("group web domains = %v, want %v", web.Domains(), want)
+	}
+	if targets[1].Group != "" || len(targets[1].Records) != 1 {
+		t.Errorf("record without group = %+v, want a target of its own", targets[1])
+	}
+}
+
+func TestTargetDomainsDeduplicates(t *testing.T) {
+	target := &Target{Group: "web", Records: []*Record{
+		record("WWW.example.com", "web"),
+		record("www.example.com", "web"),
+		record("example.com", "web"),
+	}}
+
+	if got, want := target.Domains(), []string{"www.example.com", "example.com"}; !slices.Equal(got, want) {
+		t.Errorf("Domains() = %v, want %v", got, want)
+	}
+}
+
+func TestTargetDomainsWithWildcard(t *testing.T) {
+	apex := &Record{FQDN: "example.com", Wildcard: true}
+	www := &Record{FQDN: "www.example.com"}
+	target := &Target{Group: "site", Records: []*Record{apex, www, {FQDN: "*.example.com"}}}
+
+	if got, want := target.Domains(), []string{"example.com", "*.example.com", "www.example.com"}; !slices.Equal(got, want) {
+		t.Errorf("Domains() = %v, want %v", got, want)
+	}
+}
+
+func TestTargetDomainsWithExtraSANs(t *testing.T) {
+	www := record("www.example.com", "web")
+	www.Settings.ExtraSANs = []string{"example.com", "*.example.com"}
+	shop := record("shop.example.com", "web")
+	shop.Settings.ExtraSANs = []string{"example.com", "shop.example.net"}
+
+	target := &Target{Group: "web", Records: []*Record{www, shop}}
+	want := []string{"www.example.com", "example.com", "*.example.com", "shop.example.com", "shop.example.net"}
+	if got := target.Domains(); !slices.Equal(got, want) {
+		t.Errorf("Domains() = %v, want %v", got, want)
+	}
+}
+
+func TestTargetThreshold(t *testing.T) {
+	target := func(thresholds ...int) *Target {
+		target := &Target{Group: "web"}
+		for _, days := range thresholds {
+			record := record("www.example.com", "web")
+			if days >= 0 {
+				record.Settings.Threshold = &days
+			}
+			target.Records = append(target.Records, record)
+		}
+		return target
+	}
+
+	// -1 marks a record without override
+	for thresholds, want := range map[[3]int]int{
+		{-1, -1, -1}: 14,
+		{30, -1, -1}: 30,
+		{2, -1, -1}:  2,
+		{0, -1, -1}:  0,
+		{10, -1, 21}: 21,
+	} {
+		if got := target(thresholds[:]...).Threshold(14); got != want {
+			t.Errorf("Threshold(14) with overrides %v = %d, want %d", thresholds, got, want)
+		}
+	}
+}
+
+func TestTargetVault(t *testing.T) {
+	const vaultA, vaultB = "https://vault-a.vault.azure.net", "https://vault-b.vault.azure.net"
+	target := &Target{Group: "web", Records: []*Record{record("www.example.com", "web"), record("shop.example.com", "web")}}
+
+	if got, err := target.Vault(); err != nil || got != "" {
+		t.Errorf("Vault() without override = %q, %v, want the configured vault", got, err)
+	}
+
+	target.Records[1].Settings.Vault = vaultA
+	if got, err := target.Vault(); err != nil || got != vaultA {
+		t.Errorf("Vault() with an override of one member = %q, %v, want %s", got, err, vaultA)
+	}
+
+	target.Records[0].Settings.Vault = vaultB
+	if got, err := target.Vault(); err == nil {
+		t.Errorf("Vault() with conflicting overrides = %q, want an error", got)
+	}
+}
+
+func TestTargetKeyType(t *testing.T) {
+	target := &Target{Group: "web", Records: []*Record{record("www.example.com", "web"), record("shop.example.com", "web"), record("example.com", "web")}}
+	if got, err := target.KeyType(); err != nil || got != "" {
+		t.Errorf("KeyType() without override = %q, %v, want the configured key type", got, err)
+	}
+
+	target.Records[0].Settings.KeyType = "ec256"
+	target.Records[2].Settings.KeyType = "ec256"
+	if got, err := target.KeyType(); err != nil || got != "ec256" {
+		t.Errorf("KeyType() with the same override of two members = %q, %v, want ec256", got, err)
+	}
+
+	target.Records[1].Settings.KeyType = "rsa4096"
+	_, err := target.KeyType()
+	if err == nil || !strings.Contains(err.Error(), "key types") || !strings.Contains(err.Error(), "web") {
+		t.Errorf("KeyType() with conflicting overrides error = %v, want one naming the key types and group", err)
+	}
+}
+
+func TestTargetServer(t *testing.T) {
+	const letsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+	const zeroSSL = "https://acme.zerossl.com/v2/DV90"
+
+	tests := []struct {
+		name    string
+		servers []string
+		want    string
+		wantErr bool
+	}{
+		{name: "configured server", servers: []string{"", ""}},
+		{name: "override", servers: []string{zeroSSL}, want: zeroSSL},
+		{name: "override of one member", servers: []string{"", zeroSSL}, want: zeroSSL},
+		{name: "same override of all members", servers: []string{letsEncrypt, letsEncrypt}, want: letsEncrypt},
+		{name: "conflicting overrides", servers: []string{letsEncrypt, "", zeroSSL}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{Group: "web"}
+			for _, server := range tt.servers {
+				record := record("www.example.com", "web")
+				record.Settings.Server = server
+				// The vault override does not take part in the server resolution
+				record.Settings.Vault = fmt.Sprintf("https://vault-%d.vault.azure.net", len(target.Records))
+				target.Records = append(target.Records, record)
+			}
+
+			got, err := target.Server()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Server() = %q, %v, want %q (error %t)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
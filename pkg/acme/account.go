@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/json"
@@ -20,13 +21,40 @@ import (
 )
 
 const (
+	legoFolderName             = ".lego"
 	baseAccountsRootFolderName = "accounts"
 	baseKeysFolderName         = "keys"
 	accountFileName            = "account.json"
+	dirPerm                    = 0700
 	filePerm                   = 0600
+	pendingKeySuffix           = ".new"
 )
 
-// AccountStorage handles ACME account persistence
+// DefaultAccountKeyType is the key type of accounts created implicitly by the run command
+const DefaultAccountKeyType = certcrypto.RSA2048
+
+// AccountStore persists an ACME account and its private key
+type AccountStore interface {
+	// Location describes where the account is stored
+	Location() string
+	// Exists reports whether the account data has been saved
+	Exists(ctx context.Context) (bool, error)
+	// LoadAccount loads the saved account data and attaches the private key to it
+	LoadAccount(ctx context.Context, privateKey crypto.PrivateKey) (*types.Account, error)
+	// GetPrivateKey loads the account key, generating and saving a key of keyType when there is none
+	GetPrivateKey(ctx context.Context, keyType certcrypto.KeyType) (crypto.PrivateKey, error)
+	// SavePrivateKey saves the account key
+	SavePrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error
+	// Save saves the account data
+	Save(ctx context.Context, account *types.Account) error
+
+	// Account key changes keep the current key usable until the ACME server has accepted the new one
+	savePendingPrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error
+	commitPendingPrivateKey(ctx context.Context) error
+	discardPendingPrivateKey(ctx context.Context)
+}
+
+// AccountStorage handles ACME account persistence in the lego-compatible directory layout
 type AccountStorage struct {
 	email           string
 	serverURL       string
@@ -36,20 +64,25 @@ type AccountStorage struct {
 	accountFilePath string
 }
 
-// NewAccountStorage creates a new lego-compatible AccountStorage
-func NewAccountStorage(email, serverURL string) (*AccountStorage, error) {
+// DefaultLegoPath returns the default lego root directory, ~/.lego
+func DefaultLegoPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %v", err)
+		return "", fmt.Errorf("failed to get user home directory: %v", err)
 	}
+	return filepath.Join(homeDir, legoFolderName), nil
+}
 
+// NewAccountStorage creates a new lego-compatible AccountStorage under the lego root directory
+// (see DefaultLegoPath), using the same layout as the lego command run with --path legoPath
+func NewAccountStorage(legoPath, email, serverURL string) (*AccountStorage, error) {
 	// Parse server URL to create directory name (replicate lego's logic)
 	parsedURL, err := url.Parse(serverURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid server URL: %v", err)
 	}
 
-	rootPath := filepath.Join(homeDir, ".lego", baseAccountsRootFolderName)
+	rootPath := filepath.Join(legoPath, baseAccountsRootFolderName)
 	serverPath := strings.NewReplacer(":", "_", "/", string(os.PathSeparator)).Replace(parsedURL.Host)
 	accountsPath := filepath.Join(rootPath, serverPath)
 	rootUserPath := filepath.Join(accountsPath, email)
@@ -75,8 +108,18 @@ func (s *AccountStorage) ExistsAccountFilePath() bool {
 	return true
 }
 
+// Location returns the path of the account.json file
+func (s *AccountStorage) Location() string {
+	return s.accountFilePath
+}
+
+// Exists checks if the account file exists
+func (s *AccountStorage) Exists(ctx context.Context) (bool, error) {
+	return s.ExistsAccountFilePath(), nil
+}
+
 // Save saves account data to disk
-func (s *AccountStorage) Save(account *types.Account) error {
+func (s *AccountStorage) Save(ctx context.Context, account *types.Account) error {
 	// Create directory structure
 	if err := s.createUserFolder(); err != nil {
 		return err
@@ -97,7 +140,7 @@ func (s *AccountStorage) Save(account *types.Account) error {
 }
 
 // LoadAccount loads account data from disk
-func (s *AccountStorage) LoadAccount(privateKey crypto.PrivateKey) (*types.Account, error) {
+func (s *AccountStorage) LoadAccount(ctx context.Context, privateKey crypto.PrivateKey) (*types.Account, error) {
 	fileBytes, err := os.ReadFile(s.accountFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("could not load account file: %v", err)
@@ -113,8 +156,8 @@ func (s *AccountStorage) LoadAccount(privateKey crypto.PrivateKey) (*types.Accou
 }
 
 // GetPrivateKey loads or generates a private key
-func (s *AccountStorage) GetPrivateKey(keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
-	keyFilePath := filepath.Join(s.keysPath, s.email+".key")
+func (s *AccountStorage) GetPrivateKey(ctx context.Context, keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
+	keyFilePath := s.keyFilePath()
 
 	// Try to load existing key
 	if _, err := os.Stat(keyFilePath); err == nil {
@@ -136,12 +179,48 @@ func (s *AccountStorage) GetPrivateKey(keyType certcrypto.KeyType) (crypto.Priva
 	return privateKey, nil
 }
 
+// keyFilePath returns the path of the account private key
+func (s *AccountStorage) keyFilePath() string {
+	return filepath.Join(s.keysPath, s.email+".key")
+}
+
+// SavePrivateKey writes the account key, replacing the current one
+func (s *AccountStorage) SavePrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error {
+	if err := s.createKeysFolder(); err != nil {
+		return err
+	}
+	return writePrivateKey(s.keyFilePath(), privateKey)
+}
+
+// savePendingPrivateKey writes a replacement account key next to the current one. The key only
+// replaces the current key once commitPendingPrivateKey is called, so that a failed key change
+// on the ACME server never leaves the account without a working key.
+func (s *AccountStorage) savePendingPrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error {
+	if err := s.createKeysFolder(); err != nil {
+		return err
+	}
+	return writePrivateKey(s.keyFilePath()+pendingKeySuffix, privateKey)
+}
+
+// commitPendingPrivateKey replaces the account key with the pending one
+func (s *AccountStorage) commitPendingPrivateKey(ctx context.Context) error {
+	return os.Rename(s.keyFilePath()+pendingKeySuffix, s.keyFilePath())
+}
+
+// discardPendingPrivateKey removes the pending account key
+func (s *AccountStorage) discardPendingPrivateKey(ctx context.Context) {
+	if err := os.Remove(s.keyFilePath() + pendingKeySuffix); err != nil && !os.IsNotExist(err) {
+		log.Printf("Pending account key removal failed: error=%v", err)
+	}
+}
+
+// Folders are created owner-only, existing folders (e.g. mounted volumes) keep their permissions
 func (s *AccountStorage) createUserFolder() error {
-	return os.MkdirAll(s.rootUserPath, 0700)
+	return os.MkdirAll(s.rootUserPath, dirPerm)
 }
 
 func (s *AccountStorage) createKeysFolder() error {
-	return os.MkdirAll(s.keysPath, 0700)
+	return os.MkdirAll(s.keysPath, dirPerm)
 }
 
 func (s *AccountStorage) generatePrivateKey(file string, keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
@@ -150,23 +229,28 @@ func (s *AccountStorage) generatePrivateKey(file string, keyType certcrypto.KeyT
 		return nil, err
 	}
 
+	if err := writePrivateKey(file, privateKey); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+// writePrivateKey writes a private key in PEM format, readable by the owner only
+func writePrivateKey(file string, privateKey crypto.PrivateKey) error {
 	certOut, err := os.Create(file)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer certOut.Close()
 
 	pemKey := certcrypto.PEMBlock(privateKey)
 	if err := pem.Encode(certOut, pemKey); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Set file permissions to owner read/write only
-	if err := os.Chmod(file, filePerm); err != nil {
-		return nil, err
-	}
-
-	return privateKey, nil
+	return os.Chmod(file, filePerm)
 }
 
 func (s *AccountStorage) loadPrivateKey(file string) (crypto.PrivateKey, error) {
@@ -175,6 +259,11 @@ func (s *AccountStorage) loadPrivateKey(file string) (crypto.PrivateKey, error)
 		return nil, err
 	}
 
+	return parsePrivateKey(keyBytes)
+}
+
+// parsePrivateKey parses a PEM encoded account key
+func parsePrivateKey(keyBytes []byte) (crypto.PrivateKey, error) {
 	keyBlock, _ := pem.Decode(keyBytes)
 	if keyBlock == nil {
 		return nil, fmt.Errorf("failed to decode PEM block from key file")
@@ -192,42 +281,22 @@ func (s *AccountStorage) loadPrivateKey(file string) (crypto.PrivateKey, error)
 	}
 }
 
-// LoadOrCreateAccount loads existing account or creates a new one
-func LoadOrCreateAccount(email, serverURL string) (*types.AcmeUser, error) {
-	// Create lego-compatible account storage
-	accountsStorage, err := NewAccountStorage(email, serverURL)
+// LoadOrCreateAccount loads existing account or creates a new one. keyType is the type of the
+// private key generated for a new account; existing keys are used whatever their type.
+func LoadOrCreateAccount(ctx context.Context, store AccountStore, email string, keyType certcrypto.KeyType) (*types.AcmeUser, error) {
+	// Try to load existing account
+	exists, err := store.Exists(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create accounts storage: %v", err)
+		return nil, fmt.Errorf("failed to check account storage: %v", err)
 	}
-
-	// Try to load existing account
-	if accountsStorage.ExistsAccountFilePath() {
-		log.Printf("Loading existing ACME account for %s", email)
-
-		// Load private key
-		privateKey, err := accountsStorage.GetPrivateKey(certcrypto.RSA2048)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load private key: %v", err)
-		}
-
-		// Load account data
-		account, err := accountsStorage.LoadAccount(privateKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load account: %v", err)
-		}
-
-		user := &types.AcmeUser{
-			Email:        account.Email,
-			Registration: account.Registration,
-		}
-		user.SetPrivateKey(account.GetPrivateKey())
-		return user, nil
+	if exists {
+		return loadAccount(ctx, store, email)
 	}
 
 	// Create new account
 	log.Printf("Creating new ACME account for %s", email)
 
-	privateKey, err := accountsStorage.GetPrivateKey(certcrypto.RSA2048)
+	privateKey, err := store.GetPrivateKey(ctx, keyType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key: %v", err)
 	}
@@ -237,31 +306,118 @@ func LoadOrCreateAccount(email, serverURL string) (*types.AcmeUser, error) {
 	return user, nil
 }
 
-// SaveAccountData saves ACME account data to disk
-func SaveAccountData(user *types.AcmeUser, serverURL string) error {
-	// Create lego-compatible account storage
-	accountsStorage, err := NewAccountStorage(user.Email, serverURL)
+// LoadExistingAccount loads an existing account, failing when the account does not exist
+func LoadExistingAccount(ctx context.Context, store AccountStore, email string) (*types.AcmeUser, error) {
+	exists, err := store.Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check account storage: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("no ACME account for %s at %s", email, store.Location())
+	}
+
+	return loadAccount(ctx, store, email)
+}
+
+// loadAccount loads the account and its private key from storage
+func loadAccount(ctx context.Context, store AccountStore, email string) (*types.AcmeUser, error) {
+	log.Printf("Loading existing ACME account for %s", email)
+
+	// Load private key
+	privateKey, err := store.GetPrivateKey(ctx, DefaultAccountKeyType)
 	if err != nil {
-		return fmt.Errorf("failed to create accounts storage: %v", err)
+		return nil, fmt.Errorf("failed to load private key: %v", err)
 	}
 
+	// Load account data
+	account, err := store.LoadAccount(ctx, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account: %v", err)
+	}
+
+	user := &types.AcmeUser{
+		Email:                account.Email,
+		Registration:         account.Registration,
+		ExternalAccountKeyID: account.ExternalAccountKeyID,
+	}
+	user.SetPrivateKey(account.GetPrivateKey())
+	return user, nil
+}
+
+// SaveAccountData saves ACME account data to the account storage
+func SaveAccountData(ctx context.Context, store AccountStore, user *types.AcmeUser) error {
 	// Convert AcmeUser to Account for saving
 	account := &types.Account{
-		Email:        user.Email,
-		Registration: user.Registration,
+		Email:                user.Email,
+		Registration:         user.Registration,
+		ExternalAccountKeyID: user.ExternalAccountKeyID,
 	}
 	account.SetPrivateKey(user.GetPrivateKey())
 
-	return accountsStorage.Save(account)
+	return store.Save(ctx, account)
 }
 
-// RegisterAccount registers a new ACME account
-func RegisterAccount(user *types.AcmeUser, client *lego.Client) error {
-	log.Printf("Registering new ACME account...")
-	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+// MigrateAccount copies the account and its private key from one storage to another, unless the
+// target already holds the account or the source does not. It reports whether the account was copied.
+func MigrateAccount(ctx context.Context, from, to AccountStore) (bool, error) {
+	if exists, err := to.Exists(ctx); err != nil || exists {
+		return false, err
+	}
+	if exists, err := from.Exists(ctx); err != nil || !exists {
+		return false, err
+	}
+
+	privateKey, err := from.GetPrivateKey(ctx, DefaultAccountKeyType)
+	if err != nil {
+		return false, fmt.Errorf("failed to load private key: %v", err)
+	}
+
+	account, err := from.LoadAccount(ctx, privateKey)
 	if err != nil {
-		return fmt.Errorf("failed to register ACME account: %v", err)
+		return false, fmt.Errorf("failed to load account: %v", err)
 	}
+
+	if err := to.SavePrivateKey(ctx, privateKey); err != nil {
+		return false, fmt.Errorf("failed to save private key: %v", err)
+	}
+	if err := to.Save(ctx, account); err != nil {
+		return false, fmt.Errorf("failed to save account: %v", err)
+	}
+
+	log.Printf("ACME account migrated: email=%s, from=%s, to=%s", account.Email, from.Location(), to.Location())
+	return true, nil
+}
+
+// RegisterAccount registers a new ACME account, bound to an external account when eab is set.
+// Servers that require external account binding refuse the registration without eab.
+func RegisterAccount(user *types.AcmeUser, client *lego.Client, serverURL string, eab *ExternalAccountBinding) error {
+	if eab == nil {
+		if client.GetExternalAccountRequired() {
+			return fmt.Errorf("ACME server %s requires external account binding, set the EAB key ID and HMAC key", serverURL)
+		}
+
+		log.Printf("Registering new ACME account...")
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return fmt.Errorf("failed to register ACME account: %v", err)
+		}
+		user.SetRegistration(reg)
+		user.ExternalAccountKeyID = ""
+		return nil
+	}
+
+	log.Printf("Registering new ACME account with external account binding: kid=%s", eab.KeyID)
+	reg, err := client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+		TermsOfServiceAgreed: true,
+		Kid:                  eab.KeyID,
+		HmacEncoded:          eab.HMAC,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register ACME account with external account binding: %v", err)
+	}
+
+	// Most servers do not return the binding, so the key ID sent with it is recorded in account.json
 	user.SetRegistration(reg)
+	user.ExternalAccountKeyID = eab.KeyID
 	return nil
 }
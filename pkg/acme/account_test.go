@@ -0,0 +1,49 @@
+package acme
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+func TestAccountStorageLayout(t *testing.T) {
+	legoPath := filepath.Join(t.TempDir(), "lego")
+	storage, err := NewAccountStorage(legoPath, "admin@example.com", "https://ca.internal:9000/acme/acme/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := LoadOrCreateAccount(context.Background(), storage, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{URI: "https://ca.internal:9000/acme/acme/account/1"}
+	if err := SaveAccountData(context.Background(), storage, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// The layout of the lego command run with --path, with the port separated by an underscore
+	userPath := filepath.Join(legoPath, "accounts", "ca.internal_9000", "admin@example.com")
+	for path, perm := range map[string]os.FileMode{
+		userPath:                        dirPerm,
+		filepath.Join(userPath, "keys"): dirPerm,
+		filepath.Join(userPath, "keys", "admin@example.com.key"): filePerm,
+		filepath.Join(userPath, "account.json"):                  filePerm,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("%s has permissions %o, want %o", path, info.Mode().Perm(), perm)
+		}
+	}
+	if storage.Location() != filepath.Join(userPath, "account.json") {
+		t.Errorf("Location() = %s", storage.Location())
+	}
+}
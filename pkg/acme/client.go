@@ -0,0 +1,174 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+// Let's Encrypt ACME directory URLs
+const (
+	LetsEncryptStagingURL    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	LetsEncryptProductionURL = "https://acme-v02.api.letsencrypt.org/directory"
+)
+
+// serverPresets maps preset names accepted by the acme-server setting to ACME directory URLs
+var serverPresets = map[string]string{
+	"letsencrypt":         LetsEncryptProductionURL,
+	"letsencrypt-staging": LetsEncryptStagingURL,
+	"zerossl":             "https://acme.zerossl.com/v2/DV90",
+	"google":              "https://dv.acme-v02.api.pki.goog/directory",
+	"google-staging":      "https://dv.acme-v02.test-api.pki.goog/directory",
+	"buypass":             "https://api.buypass.com/acme/directory",
+	"buypass-staging":     "https://api.test4.buypass.no/acme/directory",
+}
+
+// caCertPool holds the trusted roots for ACME connections, nil to use the system roots
+var caCertPool *x509.CertPool
+
+// DirectoryURL returns the Let's Encrypt directory URL for the staging or production environment
+func DirectoryURL(staging bool) string {
+	if staging {
+		return LetsEncryptStagingURL
+	}
+	return LetsEncryptProductionURL
+}
+
+// ResolveServerURL returns the ACME directory URL for a server setting, which is either a preset name
+// (e.g. zerossl, google) or a directory URL. Without a server, the Let's Encrypt staging or production
+// directory is selected by the staging flag.
+func ResolveServerURL(server string, staging bool) (string, error) {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		return DirectoryURL(staging), nil
+	}
+
+	if preset, ok := serverPresets[strings.ToLower(server)]; ok {
+		return preset, nil
+	}
+
+	parsed, err := url.Parse(server)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return "", fmt.Errorf("unsupported ACME server %q (use a directory URL or one of: %s)", server, strings.Join(ServerPresets(), ", "))
+	}
+	return server, nil
+}
+
+// ServerPresets returns the sorted preset names accepted by ResolveServerURL
+func ServerPresets() []string {
+	names := make([]string, 0, len(serverPresets))
+	for name := range serverPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadCABundle trusts the certificates of the given PEM files, in addition to the system roots,
+// for all ACME connections. It is meant for private ACME servers such as step-ca.
+func LoadCABundle(paths []string) error {
+	pool, err := lego.CreateCertPool(paths, true)
+	if err != nil {
+		return fmt.Errorf("failed to load CA bundle: %v", err)
+	}
+	caCertPool = pool
+	return nil
+}
+
+// NewClient creates an ACME client for a user, trusting the CA bundle loaded with LoadCABundle
+func NewClient(user registration.User, serverURL string) (*lego.Client, error) {
+	config := lego.NewConfig(user)
+	config.CADirURL = serverURL
+	applyCABundle(config.HTTPClient)
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME client: %v", err)
+	}
+
+	return client, nil
+}
+
+// applyCABundle makes an HTTP client trust the CA bundle loaded with LoadCABundle
+func applyCABundle(httpClient *http.Client) {
+	if caCertPool == nil {
+		return
+	}
+	if transport, ok := httpClient.Transport.(*http.Transport); ok {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = caCertPool
+	}
+}
+
+// newHTTPClient creates an HTTP client with the settings lego uses for ACME requests
+func newHTTPClient() *http.Client {
+	httpClient := lego.NewConfig(nil).HTTPClient
+	applyCABundle(httpClient)
+	return httpClient
+}
+
+// fetchDirectory reads the directory of an ACME server
+func fetchDirectory(httpClient *http.Client, serverURL string) (*legoacme.Directory, error) {
+	resp, err := httpClient.Get(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ACME directory: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get ACME directory: status=%s", resp.Status)
+	}
+
+	var directory legoacme.Directory
+	if err := json.NewDecoder(resp.Body).Decode(&directory); err != nil {
+		return nil, fmt.Errorf("failed to parse ACME directory: %v", err)
+	}
+
+	return &directory, nil
+}
+
+// CAAIdentities returns the issuer domain names that the ACME server recognizes in CAA records
+// (caaIdentities of its directory), empty when the server does not advertise them
+func CAAIdentities(serverURL string) ([]string, error) {
+	directory, err := fetchDirectory(newHTTPClient(), serverURL)
+	if err != nil {
+		return nil, err
+	}
+	return directory.Meta.CaaIdentities, nil
+}
+
+// NewReadOnlyClient creates an ACME client with a throwaway, unregistered key.
+// It is meant for unauthenticated requests such as renewal info (ARI) lookups.
+func NewReadOnlyClient(serverURL string) (*lego.Client, error) {
+	privateKey, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+
+	return NewKeyClient(serverURL, privateKey)
+}
+
+// NewKeyClient creates an ACME client signing its requests with an unregistered key, embedding the
+// public key in each request. With the private key of a certificate, it can revoke that certificate
+// without the account that requested it.
+func NewKeyClient(serverURL string, privateKey crypto.PrivateKey) (*lego.Client, error) {
+	user := &types.AcmeUser{}
+	user.SetPrivateKey(privateKey)
+
+	return NewClient(user, serverURL)
+}
@@ -0,0 +1,92 @@
+package acme
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveServerURL(t *testing.T) {
+	resolved := []struct {
+		server  string
+		staging bool
+		want    string
+	}{
+		{"", true, LetsEncryptStagingURL},
+		{"", false, LetsEncryptProductionURL},
+		{"zerossl", true, "https://acme.zerossl.com/v2/DV90"},
+		{" Google-Staging ", false, "https://dv.acme-v02.test-api.pki.goog/directory"},
+		// A preset wins over the staging flag
+		{"letsencrypt", true, LetsEncryptProductionURL},
+		{"https://ca.internal:9000/acme/acme/directory", false, "https://ca.internal:9000/acme/acme/directory"},
+		{"http://localhost:14000/dir", false, "http://localhost:14000/dir"},
+	}
+	for _, tc := range resolved {
+		if got, err := ResolveServerURL(tc.server, tc.staging); err != nil || got != tc.want {
+			t.Errorf("ResolveServerURL(%q, %t) = %q, %v, want %q", tc.server, tc.staging, got, err, tc.want)
+		}
+	}
+
+	for _, server := range []string{"letsencrypt-prod", "ftp://ca.example.com/directory", "https:///directory"} {
+		if got, err := ResolveServerURL(server, false); err == nil {
+			t.Errorf("ResolveServerURL(%q) = %q, want an error", server, got)
+		}
+	}
+
+	if presets := ServerPresets(); !slices.IsSorted(presets) || !slices.Contains(presets, "buypass-staging") {
+		t.Errorf("ServerPresets() = %v, want all presets sorted", presets)
+	}
+}
+
+func TestLoadCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   "https://" + r.Host + "/new-nonce",
+			"newAccount": "https://" + r.Host + "/new-account",
+			"newOrder":   "https://" + r.Host + "/new-order",
+		})
+	}))
+	defer server.Close()
+	t.Cleanup(func() { caCertPool = nil })
+
+	if _, err := NewReadOnlyClient(server.URL + "/directory"); err == nil {
+		t.Fatal("NewReadOnlyClient() trusted the test server without its CA")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCABundle([]string{bundle}); err != nil {
+		t.Fatalf("LoadCABundle() error = %v", err)
+	}
+	if _, err := NewReadOnlyClient(server.URL + "/directory"); err != nil {
+		t.Errorf("NewReadOnlyClient() with the CA bundle error = %v", err)
+	}
+
+	if err := LoadCABundle([]string{filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("LoadCABundle() of a missing file did not fail")
+	}
+}
+
+func TestCAAIdentities(t *testing.T) {
+	server := newTestServer(t)
+	server.meta["caaIdentities"] = []string{"letsencrypt.org"}
+
+	identities, err := CAAIdentities(server.directoryURL())
+	if err != nil || !slices.Equal(identities, []string{"letsencrypt.org"}) {
+		t.Errorf("CAAIdentities() = %v, %v, want the identities of the directory", identities, err)
+	}
+
+	delete(server.meta, "caaIdentities")
+	if identities, err := CAAIdentities(server.directoryURL()); err != nil || len(identities) != 0 {
+		t.Errorf("CAAIdentities() without identities = %v, %v, want none", identities, err)
+	}
+}
@@ -0,0 +1,72 @@
+package acme
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+// ExternalAccountBinding holds the credentials binding a new ACME account to an account at the CA
+// (RFC 8555, section 7.3.4), as required by ZeroSSL, Google Public CA and Sectigo
+type ExternalAccountBinding struct {
+	KeyID string
+	HMAC  string
+}
+
+// NewExternalAccountBinding validates EAB credentials. It returns nil when neither the key ID
+// nor the HMAC key is set, and an error when only one of them is.
+func NewExternalAccountBinding(keyID, hmacEncoded string) (*ExternalAccountBinding, error) {
+	keyID = strings.TrimSpace(keyID)
+	hmacEncoded = strings.TrimSpace(hmacEncoded)
+
+	if keyID == "" && hmacEncoded == "" {
+		return nil, nil
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("EAB HMAC key set without a key ID")
+	}
+	if hmacEncoded == "" {
+		return nil, fmt.Errorf("EAB key ID %s set without an HMAC key", keyID)
+	}
+
+	if _, err := decodeHMAC(hmacEncoded); err != nil {
+		return nil, err
+	}
+
+	return &ExternalAccountBinding{KeyID: keyID, HMAC: hmacEncoded}, nil
+}
+
+// ExternalAccountKeyID returns the EAB key ID the account was registered with, or the key ID of the
+// binding returned by the server, or an empty string when the account is not bound to an external account
+func ExternalAccountKeyID(user *types.AcmeUser) string {
+	if user.ExternalAccountKeyID != "" {
+		return user.ExternalAccountKeyID
+	}
+	reg := user.Registration
+	if reg == nil || len(reg.Body.ExternalAccountBinding) == 0 {
+		return ""
+	}
+
+	binding, err := jose.ParseSigned(string(reg.Body.ExternalAccountBinding), []jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512})
+	if err != nil || len(binding.Signatures) == 0 {
+		return ""
+	}
+
+	return binding.Signatures[0].Protected.KeyID
+}
+
+// decodeHMAC decodes a base64url encoded EAB HMAC key, with or without padding
+func decodeHMAC(hmacEncoded string) ([]byte, error) {
+	if hmac, err := base64.RawURLEncoding.DecodeString(hmacEncoded); err == nil {
+		return hmac, nil
+	}
+	hmac, err := base64.URLEncoding.DecodeString(hmacEncoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EAB HMAC key, base64url encoding expected: %v", err)
+	}
+	return hmac, nil
+}
@@ -0,0 +1,118 @@
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+// testHMAC is a base64url-encoded 256-bit HMAC key
+const testHMAC = "c2VjcmV0LWhtYWMta2V5LW9mLTMyLWJ5dGVzLWxlbmd0aA"
+
+func TestNewExternalAccountBinding(t *testing.T) {
+	eab, err := NewExternalAccountBinding(" kid-1 ", testHMAC+" ")
+	if err != nil || eab == nil || eab.KeyID != "kid-1" || eab.HMAC != testHMAC {
+		t.Fatalf("NewExternalAccountBinding() = %+v, %v", eab, err)
+	}
+
+	if eab, err := NewExternalAccountBinding("", ""); eab != nil || err != nil {
+		t.Errorf("NewExternalAccountBinding() without credentials = %+v, %v, want nil", eab, err)
+	}
+
+	for _, tc := range []struct{ keyID, hmac string }{
+		{"kid-1", ""},
+		{"", testHMAC},
+		{"kid-1", "not base64!"},
+	} {
+		if _, err := NewExternalAccountBinding(tc.keyID, tc.hmac); err == nil {
+			t.Errorf("NewExternalAccountBinding(%q, %q) did not fail", tc.keyID, tc.hmac)
+		}
+	}
+}
+
+// newTestUser returns an unregistered user with a fresh account key
+func newTestUser(t *testing.T) *types.AcmeUser {
+	key, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &types.AcmeUser{Email: "admin@example.com"}
+	user.SetPrivateKey(key)
+	return user
+}
+
+func TestRegisterAccountWithExternalAccountBinding(t *testing.T) {
+	var sent json.RawMessage
+	server := newTestServer(t)
+	server.handlers["/new-account"] = func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ExternalAccountBinding json.RawMessage `json:"externalAccountBinding"`
+		}
+		jwsPayload(t, r, &payload)
+		sent = payload.ExternalAccountBinding
+
+		// Like most CAs, the server does not return the binding
+		accountHandler("valid")(w, r)
+	}
+	server.meta["externalAccountRequired"] = true
+
+	user := newTestUser(t)
+	client, err := NewClient(user, server.directoryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterAccount(user, client, server.directoryURL(), nil); err == nil {
+		t.Fatal("RegisterAccount() without EAB succeeded on a server requiring it")
+	}
+
+	eab, _ := NewExternalAccountBinding("kid-1", testHMAC)
+	if err := RegisterAccount(user, client, server.directoryURL(), eab); err != nil {
+		t.Fatalf("RegisterAccount() error = %v", err)
+	}
+	if len(sent) == 0 {
+		t.Fatal("newAccount request without external account binding")
+	}
+	if user.Registration == nil || user.Registration.URI != server.URL+"/account/1" {
+		t.Fatalf("registration = %+v", user.Registration)
+	}
+
+	if got := ExternalAccountKeyID(user); got != "kid-1" {
+		t.Errorf("ExternalAccountKeyID() = %q, want kid-1", got)
+	}
+
+	// The key ID is kept in account.json, next to the lego account data
+	storage, err := NewAccountStorage(t.TempDir(), user.Email, server.directoryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveAccountData(context.Background(), storage, user); err != nil {
+		t.Fatalf("SaveAccountData() error = %v", err)
+	}
+	saved, err := LoadExistingAccount(context.Background(), storage, user.Email)
+	if err != nil {
+		t.Fatalf("LoadExistingAccount() error = %v", err)
+	}
+	if got := ExternalAccountKeyID(saved); got != "kid-1" {
+		t.Errorf("ExternalAccountKeyID() of the saved account = %q, want kid-1", got)
+	}
+}
+
+func TestExternalAccountKeyIDWithoutBinding(t *testing.T) {
+	for _, reg := range []*registration.Resource{
+		nil,
+		{URI: "https://acme.example/account/1"},
+		{Body: acme.Account{ExternalAccountBinding: json.RawMessage(`{"protected":"e30"}`)}},
+	} {
+		if got := ExternalAccountKeyID(&types.AcmeUser{Registration: reg}); got != "" {
+			t.Errorf("ExternalAccountKeyID(%+v) = %q, want no key ID", reg, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+const (
+	accountSecretPrefix    = "acme-account-"
+	accountKeySecretSuffix = "-key"
+	accountContentType     = "application/json"
+	accountKeyContentType  = "application/x-pem-file"
+)
+
+// Account storage locations
+const (
+	AccountStorageFilesystem = "filesystem"
+	AccountStorageKeyVault   = "keyvault"
+)
+
+// ParseAccountStorage validates an account storage location name
+func ParseAccountStorage(name string) (string, error) {
+	switch name {
+	case "", AccountStorageFilesystem:
+		return AccountStorageFilesystem, nil
+	case AccountStorageKeyVault:
+		return AccountStorageKeyVault, nil
+	default:
+		return "", fmt.Errorf("unsupported account storage %q (use %s or %s)", name, AccountStorageFilesystem, AccountStorageKeyVault)
+	}
+}
+
+// KeyVaultAccountStorage keeps an ACME account in two Key Vault secrets: the lego-compatible
+// account.json content and the PEM encoded account key. It lets short-lived containers reuse
+// one account instead of registering a new one on every run.
+type KeyVaultAccountStorage struct {
+	client        *azsecrets.Client
+	email         string
+	serverHost    string
+	accountSecret string
+	keySecret     string
+
+	// previousKey is the key replaced by a pending key change, restored when the change fails
+	previousKey crypto.PrivateKey
+}
+
+// NewKeyVaultAccountStorage creates the Key Vault storage of the account of an email address at an ACME server.
+// Secret names are derived from a hash of the server host and the email address, both also recorded as tags.
+func NewKeyVaultAccountStorage(client *azsecrets.Client, email, serverURL string) (*KeyVaultAccountStorage, error) {
+	parsedURL, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(parsedURL.Host + "/" + email))
+	accountSecret := accountSecretPrefix + hex.EncodeToString(sum[:8])
+
+	return &KeyVaultAccountStorage{
+		client:        client,
+		email:         email,
+		serverHost:    parsedURL.Host,
+		accountSecret: accountSecret,
+		keySecret:     accountSecret + accountKeySecretSuffix,
+	}, nil
+}
+
+// Location returns the names of the account secrets
+func (s *KeyVaultAccountStorage) Location() string {
+	return fmt.Sprintf("Key Vault secrets %s and %s", s.accountSecret, s.keySecret)
+}
+
+// Exists checks if the account secret exists
+func (s *KeyVaultAccountStorage) Exists(ctx context.Context) (bool, error) {
+	value, err := s.getSecret(ctx, s.accountSecret)
+	return value != "", err
+}
+
+// LoadAccount loads the account data from its secret
+func (s *KeyVaultAccountStorage) LoadAccount(ctx context.Context, privateKey crypto.PrivateKey) (*types.Account, error) {
+	value, err := s.getSecret(ctx, s.accountSecret)
+	if err != nil {
+		return nil, fmt.Errorf("could not load account secret: %v", err)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("account secret %s not found", s.accountSecret)
+	}
+
+	var account types.Account
+	if err := json.Unmarshal([]byte(value), &account); err != nil {
+		return nil, fmt.Errorf("could not parse account secret: %v", err)
+	}
+
+	account.SetPrivateKey(privateKey)
+	return &account, nil
+}
+
+// GetPrivateKey loads the account key from its secret, or generates and saves a new one
+func (s *KeyVaultAccountStorage) GetPrivateKey(ctx context.Context, keyType certcrypto.KeyType) (crypto.PrivateKey, error) {
+	value, err := s.getSecret(ctx, s.keySecret)
+	if err != nil {
+		return nil, fmt.Errorf("could not load account key secret: %v", err)
+	}
+	if value != "" {
+		return parsePrivateKey([]byte(value))
+	}
+
+	log.Printf("Generating private key: email=%s, key_type=%s", s.email, keyType)
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate private key: %v", err)
+	}
+
+	if err := s.SavePrivateKey(ctx, privateKey); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Saved key to Key Vault secret %s", s.keySecret)
+	return privateKey, nil
+}
+
+// SavePrivateKey stores the account key as a new version of the key secret
+func (s *KeyVaultAccountStorage) SavePrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error {
+	keyPEM := pem.EncodeToMemory(certcrypto.PEMBlock(privateKey))
+	if err := s.setSecret(ctx, s.keySecret, string(keyPEM), accountKeyContentType); err != nil {
+		return fmt.Errorf("failed to save account key secret: %v", err)
+	}
+	return nil
+}
+
+// Save stores the account data as a new version of the account secret
+func (s *KeyVaultAccountStorage) Save(ctx context.Context, account *types.Account) error {
+	jsonBytes, err := json.MarshalIndent(account, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal account data: %v", err)
+	}
+
+	if err := s.setSecret(ctx, s.accountSecret, string(jsonBytes), accountContentType); err != nil {
+		return fmt.Errorf("failed to save account secret: %v", err)
+	}
+
+	log.Printf("ACME account data saved: secret=%s", s.accountSecret)
+	return nil
+}
+
+// savePendingPrivateKey stores the new key right away. Earlier versions of the key secret keep
+// the previous key, which is restored when the key change fails.
+func (s *KeyVaultAccountStorage) savePendingPrivateKey(ctx context.Context, privateKey crypto.PrivateKey) error {
+	value, err := s.getSecret(ctx, s.keySecret)
+	if err != nil {
+		return err
+	}
+	if value != "" {
+		if s.previousKey, err = parsePrivateKey([]byte(value)); err != nil {
+			return err
+		}
+	}
+	return s.SavePrivateKey(ctx, privateKey)
+}
+
+// commitPendingPrivateKey completes a key change, the new key is already stored
+func (s *KeyVaultAccountStorage) commitPendingPrivateKey(ctx context.Context) error {
+	s.previousKey = nil
+	return nil
+}
+
+// discardPendingPrivateKey restores the key replaced by a failed key change
+func (s *KeyVaultAccountStorage) discardPendingPrivateKey(ctx context.Context) {
+	if s.previousKey == nil {
+		return
+	}
+	if err := s.SavePrivateKey(ctx, s.previousKey); err != nil {
+		log.Printf("Previous account key restore failed, use the previous version of secret %s: error=%v", s.keySecret, err)
+	}
+	s.previousKey = nil
+}
+
+// getSecret returns the current value of a secret, or an empty string when it does not exist
+func (s *KeyVaultAccountStorage) getSecret(ctx context.Context, name string) (string, error) {
+	resp, err := s.client.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", nil
+	}
+	return *resp.Value, nil
+}
+
+// setSecret stores a new version of a secret, tagged with the account it belongs to
+func (s *KeyVaultAccountStorage) setSecret(ctx context.Context, name, value, contentType string) error {
+	_, err := s.client.SetSecret(ctx, name, azsecrets.SetSecretParameters{
+		Value:       to.Ptr(value),
+		ContentType: to.Ptr(contentType),
+		Tags: map[string]*string{
+			"email":       to.Ptr(s.email),
+			"acme-server": to.Ptr(s.serverHost),
+		},
+	}, nil)
+	return err
+}
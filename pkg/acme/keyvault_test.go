@@ -0,0 +1,218 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+)
+
+const testVaultURL = "https://test.vault.azure.net"
+
+type testCredential struct{}
+
+func (testCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeSecrets keeps the versions of Key Vault secrets in memory, newest last
+type fakeSecrets struct {
+	mu       sync.Mutex
+	versions map[string][]azsecrets.Secret
+}
+
+// newFakeSecrets returns the fake secrets and a client sending its requests to them
+func newFakeSecrets(t *testing.T) (*fakeSecrets, *azsecrets.Client) {
+	t.Helper()
+
+	secrets := &fakeSecrets{versions: make(map[string][]azsecrets.Secret)}
+	client, err := azsecrets.NewClient(testVaultURL, testCredential{}, &azsecrets.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: secrets},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secrets, client
+}
+
+// Do implements policy.Transporter for GET and PUT requests of secrets
+func (f *fakeSecrets) Do(req *http.Request) (*http.Response, error) {
+	respond := func(status int, body any) *http.Response {
+		data, _ := json.Marshal(body)
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Request:    req,
+		}
+	}
+
+	if req.Header.Get("Authorization") == "" {
+		resp := respond(http.StatusUnauthorized, nil)
+		resp.Header.Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+		return resp, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	name := path[1]
+	switch req.Method {
+	case http.MethodGet:
+		versions := f.versions[name]
+		if len(versions) == 0 {
+			return respond(http.StatusNotFound, map[string]any{"error": map[string]string{"code": "SecretNotFound", "message": "Secret not found: " + name}}), nil
+		}
+		return respond(http.StatusOK, versions[len(versions)-1]), nil
+	case http.MethodPut:
+		var params azsecrets.SetSecretParameters
+		data, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(data, &params); err != nil {
+			return nil, err
+		}
+		secret := azsecrets.Secret{
+			ID:          to.Ptr(azsecrets.ID(fmt.Sprintf("%s/secrets/%s/%d", testVaultURL, name, len(f.versions[name])+1))),
+			Value:       params.Value,
+			ContentType: params.ContentType,
+			Tags:        params.Tags,
+		}
+		f.versions[name] = append(f.versions[name], secret)
+		return respond(http.StatusOK, secret), nil
+	}
+	return respond(http.StatusMethodNotAllowed, nil), nil
+}
+
+// latest returns the newest version of a secret
+func (f *fakeSecrets) latest(name string) azsecrets.Secret {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	versions := f.versions[name]
+	return versions[len(versions)-1]
+}
+
+func TestParseAccountStorage(t *testing.T) {
+	for name, want := range map[string]string{"": AccountStorageFilesystem, "filesystem": AccountStorageFilesystem, "keyvault": AccountStorageKeyVault} {
+		if got, err := ParseAccountStorage(name); err != nil || got != want {
+			t.Errorf("ParseAccountStorage(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseAccountStorage("s3"); err == nil {
+		t.Error("ParseAccountStorage(s3) did not fail")
+	}
+}
+
+func TestKeyVaultAccountStorage(t *testing.T) {
+	secrets, client := newFakeSecrets(t)
+	store, err := NewKeyVaultAccountStorage(client, "admin@example.com", "https://acme.example/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := store.Exists(context.Background()); err != nil || exists {
+		t.Fatalf("Exists() of an empty vault = %t, %v", exists, err)
+	}
+
+	user, err := LoadOrCreateAccount(context.Background(), store, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatalf("LoadOrCreateAccount() error = %v", err)
+	}
+	keySecret := secrets.latest(store.keySecret)
+	if *keySecret.ContentType != accountKeyContentType || *keySecret.Tags["acme-server"] != "acme.example" || *keySecret.Tags["email"] != "admin@example.com" {
+		t.Errorf("key secret = %+v, want a tagged PEM secret", keySecret)
+	}
+
+	user.Registration = &registration.Resource{URI: "https://acme.example/account/1"}
+	if err := SaveAccountData(context.Background(), store, user); err != nil {
+		t.Fatalf("SaveAccountData() error = %v", err)
+	}
+
+	loaded, err := LoadExistingAccount(context.Background(), store, "admin@example.com")
+	if err != nil {
+		t.Fatalf("LoadExistingAccount() error = %v", err)
+	}
+	if loaded.Registration.URI != user.Registration.URI || !user.GetPrivateKey().(*ecdsa.PrivateKey).Equal(loaded.GetPrivateKey()) {
+		t.Errorf("LoadExistingAccount() = %+v, want the saved account and key", loaded)
+	}
+
+	// Accounts of other servers and addresses are kept in other secrets
+	other, _ := NewKeyVaultAccountStorage(client, "admin@example.com", "https://acme-staging.example/directory")
+	if other.accountSecret == store.accountSecret {
+		t.Errorf("secret name %s shared between servers", store.accountSecret)
+	}
+	if _, err := LoadExistingAccount(context.Background(), other, "admin@example.com"); err == nil {
+		t.Error("LoadExistingAccount() of a missing account did not fail")
+	}
+}
+
+func TestMigrateAccount(t *testing.T) {
+	_, client := newFakeSecrets(t)
+
+	local, _ := NewAccountStorage(t.TempDir(), "admin@example.com", "https://acme.example/directory")
+	vault, _ := NewKeyVaultAccountStorage(client, "admin@example.com", "https://acme.example/directory")
+
+	if migrated, err := MigrateAccount(context.Background(), local, vault); err != nil || migrated {
+		t.Fatalf("MigrateAccount() without local account = %t, %v", migrated, err)
+	}
+
+	user, err := LoadOrCreateAccount(context.Background(), local, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{URI: "https://acme.example/account/7"}
+	if err := SaveAccountData(context.Background(), local, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated, err := MigrateAccount(context.Background(), local, vault); err != nil || !migrated {
+		t.Fatalf("MigrateAccount() = %t, %v, want the account copied", migrated, err)
+	}
+	migrated, err := LoadExistingAccount(context.Background(), vault, "admin@example.com")
+	if err != nil || migrated.Registration.URI != user.Registration.URI {
+		t.Fatalf("migrated account = %+v, %v", migrated, err)
+	}
+
+	// An account already in Key Vault is never overwritten
+	if copied, err := MigrateAccount(context.Background(), local, vault); err != nil || copied {
+		t.Errorf("second MigrateAccount() = %t, %v, want nothing copied", copied, err)
+	}
+}
+
+func TestKeyVaultAccountStorageRestoresKeyOfFailedRollover(t *testing.T) {
+	server := newTestServer(t)
+	server.handlers["/key-change"] = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"type":"urn:ietf:params:acme:error:conflict","detail":"key in use"}`))
+	}
+
+	secrets, client := newFakeSecrets(t)
+	store, _ := NewKeyVaultAccountStorage(client, "admin@example.com", server.directoryURL())
+	user, err := LoadOrCreateAccount(context.Background(), store, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{URI: server.URL + "/account/1"}
+	oldKey := *secrets.latest(store.keySecret).Value
+
+	if err := RolloverAccountKey(context.Background(), store, user, server.directoryURL(), certcrypto.EC256); err == nil {
+		t.Fatal("RolloverAccountKey() refused by the server did not fail")
+	}
+	if versions := secrets.versions[store.keySecret]; len(versions) != 3 || *versions[2].Value != oldKey {
+		t.Errorf("key secret has %d versions, want the new key followed by the restored old key", len(versions))
+	}
+}
@@ -0,0 +1,278 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-jose/go-jose/v4"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+const (
+	mailTo       = "mailto:"
+	joseJSONType = "application/jose+json"
+)
+
+// accountRequester sends requests signed with an account key, for account operations
+// that lego does not implement (contact lists and key changes)
+type accountRequester struct {
+	httpClient *http.Client
+	directory  *legoacme.Directory
+	accountURL string
+	privateKey crypto.PrivateKey
+}
+
+// newAccountRequester creates a requester for a registered account
+func newAccountRequester(user *types.AcmeUser, serverURL string) (*accountRequester, error) {
+	if user.Registration == nil || user.Registration.URI == "" {
+		return nil, fmt.Errorf("ACME account for %s is not registered", user.Email)
+	}
+
+	httpClient := newHTTPClient()
+	directory, err := fetchDirectory(httpClient, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountRequester{
+		httpClient: httpClient,
+		directory:  directory,
+		accountURL: user.Registration.URI,
+		privateKey: user.GetPrivateKey(),
+	}, nil
+}
+
+// Nonce fetches a fresh anti-replay nonce (implements jose.NonceSource)
+func (r *accountRequester) Nonce() (string, error) {
+	resp, err := r.httpClient.Head(r.directory.NewNonceURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get nonce: %v", err)
+	}
+	defer resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("server did not return a nonce")
+	}
+	return nonce, nil
+}
+
+// post sends a payload signed with the account key to an ACME URL and decodes the response into result
+func (r *accountRequester) post(url string, payload []byte, result any) error {
+	alg, err := signatureAlgorithm(r.privateKey)
+	if err != nil {
+		return err
+	}
+
+	options := (&jose.SignerOptions{NonceSource: r}).WithHeader("kid", r.accountURL).WithHeader("url", url)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: r.privateKey}, options)
+	if err != nil {
+		return fmt.Errorf("failed to create request signer: %v", err)
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	resp, err := r.httpClient.Post(url, joseJSONType, bytes.NewBufferString(signed.FullSerialize()))
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from %s: %v", url, err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		problem := &legoacme.ProblemDetails{}
+		if err := json.Unmarshal(body, problem); err != nil || problem.Type == "" {
+			return fmt.Errorf("request to %s failed: status=%s", url, resp.Status)
+		}
+		problem.HTTPStatus = resp.StatusCode
+		return problem
+	}
+
+	if result != nil && len(body) > 0 {
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("failed to parse response from %s: %v", url, err)
+		}
+	}
+	return nil
+}
+
+// UpdateAccountContacts replaces the contact email addresses of a registered account
+// and records the updated registration in the user
+func UpdateAccountContacts(user *types.AcmeUser, serverURL string, emails []string) error {
+	requester, err := newAccountRequester(user, serverURL)
+	if err != nil {
+		return err
+	}
+
+	contacts := make([]string, 0, len(emails))
+	for _, email := range emails {
+		contacts = append(contacts, mailTo+strings.TrimPrefix(email, mailTo))
+	}
+
+	payload, err := json.Marshal(legoacme.Account{Contact: contacts})
+	if err != nil {
+		return fmt.Errorf("failed to encode account update: %v", err)
+	}
+
+	var account legoacme.Account
+	if err := requester.post(requester.accountURL, payload, &account); err != nil {
+		return fmt.Errorf("failed to update ACME account: %w", err)
+	}
+
+	// Keep fields the server does not echo back, such as the external account binding
+	if len(account.ExternalAccountBinding) == 0 {
+		account.ExternalAccountBinding = user.Registration.Body.ExternalAccountBinding
+	}
+	user.Registration.Body = account
+	return nil
+}
+
+// RolloverAccountKey replaces the key of a registered account with a new key of the given type
+// (RFC 8555, section 7.3.5). The new key replaces the stored key once the server has accepted it.
+func RolloverAccountKey(ctx context.Context, store AccountStore, user *types.AcmeUser, serverURL string, keyType certcrypto.KeyType) error {
+	requester, err := newAccountRequester(user, serverURL)
+	if err != nil {
+		return err
+	}
+	if requester.directory.KeyChangeURL == "" {
+		return errors.New("ACME server does not support account key changes")
+	}
+
+	newKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %v", err)
+	}
+
+	inner, err := signKeyChange(newKey, user.GetPrivateKey(), requester.accountURL, requester.directory.KeyChangeURL)
+	if err != nil {
+		return err
+	}
+
+	if err := store.savePendingPrivateKey(ctx, newKey); err != nil {
+		return fmt.Errorf("failed to save new private key: %v", err)
+	}
+
+	if err := requester.post(requester.directory.KeyChangeURL, inner, nil); err != nil {
+		store.discardPendingPrivateKey(ctx)
+		return fmt.Errorf("failed to change ACME account key: %w", err)
+	}
+
+	if err := store.commitPendingPrivateKey(ctx); err != nil {
+		return fmt.Errorf("account key changed, but the new key was not saved in %s: %v", store.Location(), err)
+	}
+
+	log.Printf("ACME account key replaced: email=%s, key_type=%s", user.Email, keyType)
+	user.SetPrivateKey(newKey)
+	return nil
+}
+
+// signKeyChange creates the inner JWS of a key change request, signed by the new key
+func signKeyChange(newKey, oldKey crypto.PrivateKey, accountURL, keyChangeURL string) ([]byte, error) {
+	oldJWK := jose.JSONWebKey{Key: oldKey}
+	payload, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{
+		Account: accountURL,
+		OldKey:  oldJWK.Public(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key change: %v", err)
+	}
+
+	alg, err := signatureAlgorithm(newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	options := (&jose.SignerOptions{EmbedJWK: true}).WithHeader("url", keyChangeURL)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: newKey}, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key change signer: %v", err)
+	}
+
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key change: %v", err)
+	}
+
+	return []byte(signed.FullSerialize()), nil
+}
+
+// signatureAlgorithm returns the JWS algorithm used with an account key
+func signatureAlgorithm(privateKey crypto.PrivateKey) (jose.SignatureAlgorithm, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported account key type %T", privateKey)
+}
+
+// PrivateKeyType returns the key type of an account key
+func PrivateKeyType(privateKey crypto.PrivateKey) (certcrypto.KeyType, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return certcrypto.RSA2048, nil
+		case 3072:
+			return certcrypto.RSA3072, nil
+		case 4096:
+			return certcrypto.RSA4096, nil
+		case 8192:
+			return certcrypto.RSA8192, nil
+		}
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return certcrypto.EC256, nil
+		case elliptic.P384():
+			return certcrypto.EC384, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported account key type %T", privateKey)
+}
+
+// DeactivateAccount deactivates a registered account on the ACME server and records
+// its new status in the user. Deactivation cannot be undone.
+func DeactivateAccount(user *types.AcmeUser, client *lego.Client) error {
+	if user.Registration == nil {
+		return fmt.Errorf("ACME account for %s is not registered", user.Email)
+	}
+
+	if err := client.Registration.DeleteRegistration(); err != nil {
+		return fmt.Errorf("failed to deactivate ACME account: %w", err)
+	}
+
+	user.Registration.Body.Status = legoacme.StatusDeactivated
+	return nil
+}
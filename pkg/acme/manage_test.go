@@ -0,0 +1,135 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/go-jose/go-jose/v4"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+func TestPrivateKeyType(t *testing.T) {
+	for _, keyType := range []certcrypto.KeyType{certcrypto.RSA2048, certcrypto.EC256, certcrypto.EC384} {
+		key, err := certcrypto.GeneratePrivateKey(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := PrivateKeyType(key); err != nil || got != keyType {
+			t.Errorf("PrivateKeyType() of a %s key = %q, %v", keyType, got, err)
+		}
+	}
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := PrivateKeyType(key); err == nil {
+		t.Error("PrivateKeyType() of an Ed25519 key did not fail")
+	}
+}
+
+// registeredUser returns a user of the account /account/1 of the server, with its key stored in a temporary lego path
+func registeredUser(t *testing.T, server *testServer) (*types.AcmeUser, *AccountStorage) {
+
+	storage, err := NewAccountStorage(t.TempDir(), "admin@example.com", server.directoryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := LoadOrCreateAccount(context.Background(), storage, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{
+		URI:  server.URL + "/account/1",
+		Body: legoacme.Account{Status: legoacme.StatusValid, ExternalAccountBinding: json.RawMessage(`{"protected":"e30"}`)},
+	}
+	return user, storage
+}
+
+func TestUpdateAccountContacts(t *testing.T) {
+	server := newTestServer(t)
+	server.handlers["/account/1"] = func(w http.ResponseWriter, r *http.Request) {
+		var account legoacme.Account
+		jwsPayload(t, r, &account)
+		account.Status = legoacme.StatusValid
+		json.NewEncoder(w).Encode(account)
+	}
+	user, _ := registeredUser(t, server)
+
+	if err := UpdateAccountContacts(user, server.directoryURL(), []string{"ops@example.com", "mailto:admin@example.com"}); err != nil {
+		t.Fatalf("UpdateAccountContacts() error = %v", err)
+	}
+
+	contacts := user.Registration.Body.Contact
+	if len(contacts) != 2 || contacts[0] != "mailto:ops@example.com" || contacts[1] != "mailto:admin@example.com" {
+		t.Errorf("contacts = %v, want both addresses as mailto URLs", contacts)
+	}
+	if len(user.Registration.Body.ExternalAccountBinding) == 0 {
+		t.Error("external account binding not kept")
+	}
+
+	unregistered := &types.AcmeUser{Email: "admin@example.com"}
+	if err := UpdateAccountContacts(unregistered, server.directoryURL(), nil); err == nil {
+		t.Error("UpdateAccountContacts() of an unregistered account did not fail")
+	}
+}
+
+func TestRolloverAccountKey(t *testing.T) {
+	server := newTestServer(t)
+	var status int
+	var innerKey *jose.JSONWebKey
+	server.handlers["/key-change"] = func(w http.ResponseWriter, r *http.Request) {
+		var inner json.RawMessage
+		jwsPayload(t, r, &inner)
+		signed, err := jose.ParseSigned(string(inner), []jose.SignatureAlgorithm{jose.RS256, jose.ES256, jose.ES384})
+		if err != nil {
+			t.Fatalf("invalid inner JWS: %v", err)
+		}
+		innerKey = signed.Signatures[0].Protected.JSONWebKey
+		if _, err := signed.Verify(innerKey); err != nil {
+			t.Errorf("inner JWS not signed by its embedded key: %v", err)
+		}
+
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"type":"urn:ietf:params:acme:error:conflict","detail":"key in use"}`))
+		}
+	}
+	user, storage := registeredUser(t, server)
+	oldKey := user.GetPrivateKey().(*ecdsa.PrivateKey)
+
+	// A refused key change keeps the old key
+	status = http.StatusConflict
+	if err := RolloverAccountKey(context.Background(), storage, user, server.directoryURL(), certcrypto.EC384); err == nil {
+		t.Fatal("RolloverAccountKey() refused by the server did not fail")
+	}
+	if stored, _ := storage.loadPrivateKey(storage.keyFilePath()); !oldKey.Equal(stored) || user.GetPrivateKey() != oldKey {
+		t.Error("refused key change replaced the account key")
+	}
+	if _, err := os.Stat(storage.keyFilePath() + pendingKeySuffix); !os.IsNotExist(err) {
+		t.Errorf("pending key left behind: %v", err)
+	}
+
+	status = http.StatusOK
+	if err := RolloverAccountKey(context.Background(), storage, user, server.directoryURL(), certcrypto.EC384); err != nil {
+		t.Fatalf("RolloverAccountKey() error = %v", err)
+	}
+	newKey, ok := user.GetPrivateKey().(*ecdsa.PrivateKey)
+	if !ok || newKey.Equal(oldKey) || !newKey.Public().(*ecdsa.PublicKey).Equal(innerKey.Key) {
+		t.Fatal("account key not replaced with the key sent to the server")
+	}
+	if keyType, _ := PrivateKeyType(newKey); keyType != certcrypto.EC384 {
+		t.Errorf("new key type = %s, want ec384", keyType)
+	}
+	if stored, err := storage.loadPrivateKey(storage.keyFilePath()); err != nil || !newKey.Equal(stored) {
+		t.Errorf("stored key is not the new key: %v", err)
+	}
+}
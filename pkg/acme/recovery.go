@@ -0,0 +1,133 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+const (
+	accountDoesNotExistErr = "urn:ietf:params:acme:error:accountDoesNotExist"
+	unauthorizedErr        = "urn:ietf:params:acme:error:unauthorized"
+)
+
+// ErrInvalidRegistration is returned when the CA no longer accepts the stored registration of an account,
+// e.g. after the account was deactivated or deleted by a reset of a staging environment
+var ErrInvalidRegistration = errors.New("ACME registration is no longer valid")
+
+// CheckRegistration queries the stored registration of an account from the ACME server. It returns an
+// error wrapping ErrInvalidRegistration when the account does not exist or is not valid anymore.
+func CheckRegistration(user *types.AcmeUser, client *lego.Client) error {
+	reg, err := client.Registration.QueryRegistration()
+	if err != nil {
+		if IsAccountProblem(err) {
+			return fmt.Errorf("%w: uri=%s, error=%v", ErrInvalidRegistration, user.Registration.URI, err)
+		}
+		return fmt.Errorf("failed to query ACME registration: %v", err)
+	}
+
+	if reg.Body.Status != "" && reg.Body.Status != legoacme.StatusValid {
+		return fmt.Errorf("%w: uri=%s, status=%s", ErrInvalidRegistration, reg.URI, reg.Body.Status)
+	}
+	return nil
+}
+
+// RecoverRegistration repairs an account whose stored registration is no longer valid, and saves it.
+//   - When the account key still belongs to a valid account, that account is used (ResolveAccountByKey).
+//   - When the CA does not know the key at all, the same key is registered again.
+//   - When the key belongs to a deactivated or revoked account, it cannot be used anymore. A new key is
+//     generated and registered when allowNewAccount is set, otherwise an error is returned.
+//
+// The returned client must be used instead of client, which is bound to the previous key.
+func RecoverRegistration(ctx context.Context, store AccountStore, user *types.AcmeUser, client *lego.Client, serverURL string, eab *ExternalAccountBinding, allowNewAccount bool) (*lego.Client, error) {
+	previousURI := user.Registration.URI
+
+	reg, err := client.Registration.ResolveAccountByKey()
+	switch {
+	case err == nil && (reg.Body.Status == "" || reg.Body.Status == legoacme.StatusValid):
+		log.Printf("ACME account resolved by key: email=%s, previous_uri=%s, uri=%s", user.Email, previousURI, reg.URI)
+		if len(reg.Body.ExternalAccountBinding) == 0 {
+			reg.Body.ExternalAccountBinding = user.Registration.Body.ExternalAccountBinding
+		}
+		user.SetRegistration(reg)
+
+	case err != nil && isProblem(err, accountDoesNotExistErr):
+		log.Printf("ACME account unknown to the CA, registering the account key again: email=%s, previous_uri=%s", user.Email, previousURI)
+		user.SetRegistration(nil)
+		if err := RegisterAccount(user, client, serverURL, eab); err != nil {
+			return nil, err
+		}
+		log.Printf("ACME account registered again: email=%s, uri=%s", user.Email, user.Registration.URI)
+
+	default:
+		reason := err
+		if err == nil {
+			reason = fmt.Errorf("status=%s", reg.Body.Status)
+		}
+		if !allowNewAccount {
+			return nil, fmt.Errorf("ACME account %s can no longer be used and a new account with a new key is required: %v", previousURI, reason)
+		}
+
+		log.Printf("ACME account can no longer be used, registering a new account with a new key: email=%s, previous_uri=%s, reason=%v", user.Email, previousURI, reason)
+		client, err = registerNewKey(ctx, store, user, serverURL, eab)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("ACME account replaced: email=%s, previous_uri=%s, uri=%s", user.Email, previousURI, user.Registration.URI)
+	}
+
+	if err := SaveAccountData(ctx, store, user); err != nil {
+		return nil, fmt.Errorf("failed to save recovered ACME account: %v", err)
+	}
+	return client, nil
+}
+
+// registerNewKey replaces the account key with a new key of the same type and registers it
+func registerNewKey(ctx context.Context, store AccountStore, user *types.AcmeUser, serverURL string, eab *ExternalAccountBinding) (*lego.Client, error) {
+	keyType, err := PrivateKeyType(user.GetPrivateKey())
+	if err != nil {
+		keyType = DefaultAccountKeyType
+	}
+
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+
+	user.SetPrivateKey(privateKey)
+	user.SetRegistration(nil)
+
+	client, err := NewClient(user, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RegisterAccount(user, client, serverURL, eab); err != nil {
+		return nil, err
+	}
+
+	// The previous key belongs to an unusable account, so it is replaced only once the new one is registered
+	if err := store.SavePrivateKey(ctx, privateKey); err != nil {
+		return nil, fmt.Errorf("failed to save private key: %v", err)
+	}
+	return client, nil
+}
+
+// IsAccountProblem reports whether an ACME error may mean that the account does not exist or is not valid.
+// Validation failures are also reported as unauthorized, so CheckRegistration confirms the account problem.
+func IsAccountProblem(err error) bool {
+	return isProblem(err, accountDoesNotExistErr) || isProblem(err, unauthorizedErr)
+}
+
+// isProblem reports whether err is an ACME problem of the given type
+func isProblem(err error, problemType string) bool {
+	var problem *legoacme.ProblemDetails
+	return errors.As(err, &problem) && problem.Type == problemType
+}
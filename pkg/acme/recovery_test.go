@@ -0,0 +1,177 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+// problem writes an ACME problem document
+func problem(w http.ResponseWriter, status int, problemType string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"type":%q,"detail":"test problem"}`, problemType)
+}
+
+// storedUser returns a user registered as /account/1 of the server, with its account saved in a temporary lego path
+func storedUser(t *testing.T, server *testServer) (*types.AcmeUser, *AccountStorage, *lego.Client) {
+	storage, err := NewAccountStorage(t.TempDir(), "admin@example.com", server.directoryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := LoadOrCreateAccount(context.Background(), storage, "admin@example.com", certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{URI: server.URL + "/account/1", Body: legoacme.Account{Status: legoacme.StatusValid}}
+	if err := SaveAccountData(context.Background(), storage, user); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(user, server.directoryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, storage, client
+}
+
+func TestCheckRegistration(t *testing.T) {
+	tests := []struct {
+		name        string
+		respond     http.HandlerFunc
+		wantErr     bool
+		wantInvalid bool
+	}{
+		{name: "valid", respond: func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, `{"status":"valid"}`) }},
+		{name: "deactivated", respond: func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, `{"status":"deactivated"}`) }, wantErr: true, wantInvalid: true},
+		{name: "does not exist", respond: func(w http.ResponseWriter, r *http.Request) {
+			problem(w, http.StatusBadRequest, accountDoesNotExistErr)
+		}, wantErr: true, wantInvalid: true},
+		{name: "unauthorized", respond: func(w http.ResponseWriter, r *http.Request) { problem(w, http.StatusForbidden, unauthorizedErr) }, wantErr: true, wantInvalid: true},
+		{name: "server error", respond: func(w http.ResponseWriter, r *http.Request) {
+			problem(w, http.StatusInternalServerError, "urn:ietf:params:acme:error:serverInternal")
+		}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.handlers["/account/1"] = tt.respond
+			user, _, client := storedUser(t, server)
+
+			err := CheckRegistration(user, client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckRegistration() error = %v, want error %t", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrInvalidRegistration) != tt.wantInvalid {
+				t.Errorf("CheckRegistration() error = %v, want ErrInvalidRegistration %t", err, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+// newAccountHandler answers key lookups (onlyReturnExisting) with lookup and registrations
+// with a new account, counting the registrations
+func newAccountHandler(t *testing.T, lookup http.HandlerFunc, registrations *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var account legoacme.Account
+		jwsPayload(t, r, &account)
+		if account.OnlyReturnExisting {
+			lookup(w, r)
+			return
+		}
+		*registrations++
+		w.Header().Set("Location", fmt.Sprintf("https://%s/account/%d", r.Host, 10+*registrations))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	}
+}
+
+func TestRecoverRegistrationResolvesAccountByKey(t *testing.T) {
+	server := newTestServer(t)
+	var registrations int
+	server.handlers["/new-account"] = newAccountHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/account/2")
+		fmt.Fprint(w, `{"status":"valid"}`)
+	}, &registrations)
+	user, storage, client := storedUser(t, server)
+	key := user.GetPrivateKey()
+
+	recovered, err := RecoverRegistration(context.Background(), storage, user, client, server.directoryURL(), nil, false)
+	if err != nil {
+		t.Fatalf("RecoverRegistration() error = %v", err)
+	}
+	if recovered != client || registrations != 0 || user.GetPrivateKey() != key {
+		t.Errorf("RecoverRegistration() registered a new account instead of using the one of the key")
+	}
+	if user.Registration.URI != server.URL+"/account/2" {
+		t.Errorf("registration URI = %s, want the account of the key", user.Registration.URI)
+	}
+	if saved, err := LoadExistingAccount(context.Background(), storage, user.Email); err != nil || saved.Registration.URI != user.Registration.URI {
+		t.Errorf("saved account = %+v, %v, want the resolved account", saved, err)
+	}
+}
+
+func TestRecoverRegistrationRegistersUnknownKey(t *testing.T) {
+	server := newTestServer(t)
+	var registrations int
+	server.handlers["/new-account"] = newAccountHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		problem(w, http.StatusBadRequest, accountDoesNotExistErr)
+	}, &registrations)
+	user, storage, client := storedUser(t, server)
+	key := user.GetPrivateKey()
+
+	if _, err := RecoverRegistration(context.Background(), storage, user, client, server.directoryURL(), nil, false); err != nil {
+		t.Fatalf("RecoverRegistration() error = %v", err)
+	}
+	if registrations != 1 || user.GetPrivateKey() != key || user.Registration.URI != server.URL+"/account/11" {
+		t.Errorf("registrations = %d, uri = %s, want the same key registered once", registrations, user.Registration.URI)
+	}
+}
+
+func TestRecoverRegistrationReplacesDeactivatedAccount(t *testing.T) {
+	server := newTestServer(t)
+	var registrations int
+	server.handlers["/new-account"] = newAccountHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/account/1")
+		fmt.Fprint(w, `{"status":"deactivated"}`)
+	}, &registrations)
+	user, storage, client := storedUser(t, server)
+	oldKey := user.GetPrivateKey()
+
+	if _, err := RecoverRegistration(context.Background(), storage, user, client, server.directoryURL(), nil, false); err == nil {
+		t.Fatal("RecoverRegistration() replaced a deactivated account without allowNewAccount")
+	}
+	if registrations != 0 {
+		t.Fatalf("%d accounts registered without allowNewAccount", registrations)
+	}
+
+	recovered, err := RecoverRegistration(context.Background(), storage, user, client, server.directoryURL(), nil, true)
+	if err != nil {
+		t.Fatalf("RecoverRegistration() error = %v", err)
+	}
+	if recovered == client || registrations != 1 || user.GetPrivateKey() == oldKey {
+		t.Fatal("deactivated account not replaced by a new account with a new key and client")
+	}
+	if keyType, _ := PrivateKeyType(user.GetPrivateKey()); keyType != certcrypto.EC256 {
+		t.Errorf("new key type = %s, want the type of the previous key", keyType)
+	}
+
+	saved, err := LoadExistingAccount(context.Background(), storage, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Registration.URI != user.Registration.URI || oldKey.(*ecdsa.PrivateKey).Equal(saved.GetPrivateKey()) {
+		t.Errorf("saved account = %s, want the new account and key", saved.Registration.URI)
+	}
+}
@@ -0,0 +1,93 @@
+package acme
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testServer is an ACME server answering the directory and nonce requests, with the other
+// endpoints served by the handlers registered for their path. Clients trust it through LoadCABundle.
+type testServer struct {
+	*httptest.Server
+	meta     map[string]any
+	handlers map[string]http.HandlerFunc
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{meta: map[string]any{}, handlers: map[string]http.HandlerFunc{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(bundle, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadCABundle([]string{bundle}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { caCertPool = nil })
+	return s
+}
+
+// directoryURL returns the URL of the server directory
+func (s *testServer) directoryURL() string {
+	return s.URL + "/directory"
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]any{
+			"newNonce":   s.URL + "/new-nonce",
+			"newAccount": s.URL + "/new-account",
+			"newOrder":   s.URL + "/new-order",
+			"keyChange":  s.URL + "/key-change",
+			"meta":       s.meta,
+		})
+	case "/new-nonce":
+	default:
+		handler, ok := s.handlers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// accountHandler answers newAccount requests with the account URL /account/1 and the given status
+func accountHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://"+r.Host+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status":%q}`, status)
+	}
+}
+
+// jwsPayload decodes the payload of a JWS request body
+func jwsPayload(t *testing.T, r *http.Request, v any) {
+	t.Helper()
+
+	var body struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid JWS body: %v", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(body.Payload)
+	if err != nil {
+		t.Fatalf("invalid JWS payload: %v", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid JWS payload: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// DefaultCAATTL is the TTL of CAA record sets created by AddCAARecords
+const DefaultCAATTL = 3600
+
+// CAARecord is a CAA property of an Azure DNS CAA record set
+type CAARecord struct {
+	Flags int32
+	Tag   string
+	Value string
+}
+
+// AddCAARecords adds CAA properties to the CAA record set of a name, in the Azure DNS zone containing
+// the name (see findZone), creating the record set when it does not exist. Properties already
+// present are kept, and the record set is written only if it was not modified since it was read.
+func (p *DNSProvider) AddCAARecords(ctx context.Context, name string, records []CAARecord) (DNSZone, error) {
+	fqdn := strings.ToLower(dns01.UnFqdn(name))
+	zone, err := p.findZone(ctx, fqdn)
+	if err != nil {
+		return DNSZone{}, err
+	}
+	relative, _ := relativeName(fqdn, zone.Name)
+
+	for attempt := 1; attempt <= dnsUpdateAttempts; attempt++ {
+		if err = p.tryAddCAARecords(ctx, zone, relative, records); !isPreconditionFailed(err) {
+			break
+		}
+		log.Printf("DNS record set modified concurrently, retrying: zone=%s, name=%s, attempt=%d", zone.Name, relative, attempt)
+	}
+	if err != nil {
+		return DNSZone{}, fmt.Errorf("failed to update CAA record set %s: %v", fqdn, err)
+	}
+	return zone, nil
+}
+
+// tryAddCAARecords reads a CAA record set, adds the missing properties and writes it back conditionally
+func (p *DNSProvider) tryAddCAARecords(ctx context.Context, zone DNSZone, name string, records []CAARecord) error {
+	recordSet := armdns.RecordSet{Properties: &armdns.RecordSetProperties{TTL: to.Ptr(int64(DefaultCAATTL))}}
+	options := &armdns.RecordSetsClientCreateOrUpdateOptions{IfNoneMatch: to.Ptr("*")}
+
+	client, err := p.recordSetsClient(zone)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get(ctx, zone.ResourceGroup, zone.Name, name, armdns.RecordTypeCAA, nil)
+	switch {
+	case err == nil:
+		if resp.Properties != nil {
+			recordSet.Properties = resp.Properties
+		}
+		options = &armdns.RecordSetsClientCreateOrUpdateOptions{IfMatch: resp.Etag}
+	case isNotFound(err):
+		// The record set is created below
+	default:
+		return err
+	}
+
+	added := false
+	for _, record := range records {
+		if !hasCAARecord(recordSet.Properties.CaaRecords, record) {
+			recordSet.Properties.CaaRecords = append(recordSet.Properties.CaaRecords, &armdns.CaaRecord{
+				Flags: to.Ptr(record.Flags),
+				Tag:   to.Ptr(record.Tag),
+				Value: to.Ptr(record.Value),
+			})
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+
+	// Read-only properties of the record set returned by Get are not accepted in updates
+	recordSet.Properties.Fqdn = nil
+	recordSet.Properties.ProvisioningState = nil
+
+	_, err = client.CreateOrUpdate(ctx, zone.ResourceGroup, zone.Name, name, armdns.RecordTypeCAA, recordSet, options)
+	return err
+}
+
+// hasCAARecord reports whether a CAA record set contains a property
+func hasCAARecord(records []*armdns.CaaRecord, record CAARecord) bool {
+	for _, existing := range records {
+		if existing != nil && existing.Tag != nil && existing.Value != nil &&
+			strings.EqualFold(*existing.Tag, record.Tag) && strings.EqualFold(*existing.Value, record.Value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+)
+
+// caaValues returns the properties of a CAA record set of the fake DNS service as "flags tag value"
+func caaValues(recordSet *armdns.RecordSet) []string {
+	var values []string
+	for _, record := range recordSet.Properties.CaaRecords {
+		values = append(values, fmt.Sprintf("%d %s %s", *record.Flags, *record.Tag, *record.Value))
+	}
+	return values
+}
+
+func TestAddCAARecords(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	dns.zones = []DNSZone{{Name: "example.com", ResourceGroup: "dns-rg"}}
+
+	// A new record set is created at the apex of the zone
+	zone, err := provider.AddCAARecords(context.Background(), "example.com.", []CAARecord{{Tag: "issue", Value: "letsencrypt.org"}})
+	if err != nil {
+		t.Fatalf("AddCAARecords() error = %v", err)
+	}
+	if zone.Name != "example.com" || zone.ResourceGroup != "dns-rg" {
+		t.Errorf("AddCAARecords() zone = %+v", zone)
+	}
+	apex := dns.caa["dns-rg/example.com/@"]
+	if apex == nil || *apex.Properties.TTL != DefaultCAATTL {
+		t.Fatalf("CAA record set of the apex = %+v, want it created with the default TTL", apex)
+	}
+
+	// Existing properties are kept, and properties already present are not added twice
+	dns.caa["dns-rg/example.com/shop"] = &armdns.RecordSet{
+		Etag: to.Ptr("7"),
+		Properties: &armdns.RecordSetProperties{
+			TTL:        to.Ptr(int64(300)),
+			Fqdn:       to.Ptr("shop.example.com."),
+			CaaRecords: []*armdns.CaaRecord{{Flags: to.Ptr(int32(0)), Tag: to.Ptr("issue"), Value: to.Ptr("pki.goog")}},
+		},
+	}
+	if _, err := provider.AddCAARecords(context.Background(), "shop.example.com", []CAARecord{{Tag: "issue", Value: "LetsEncrypt.org"}, {Tag: "issue", Value: "pki.goog"}}); err != nil {
+		t.Fatalf("AddCAARecords() error = %v", err)
+	}
+	shop := dns.caa["dns-rg/example.com/shop"]
+	if got := caaValues(shop); len(got) != 2 || got[0] != "0 issue pki.goog" || got[1] != "0 issue LetsEncrypt.org" {
+		t.Errorf("CAA record set of shop = %v, want the existing and the added property", got)
+	}
+	if *shop.Properties.TTL != 300 || shop.Properties.Fqdn != nil {
+		t.Errorf("CAA record set of shop = %+v, want its TTL kept and read-only properties dropped", shop.Properties)
+	}
+
+	writes := len(dns.writes)
+	if _, err := provider.AddCAARecords(context.Background(), "shop.example.com", []CAARecord{{Tag: "issue", Value: "letsencrypt.org"}}); err != nil {
+		t.Fatalf("AddCAARecords() error = %v", err)
+	}
+	if len(dns.writes) != writes {
+		t.Errorf("AddCAARecords() of present properties wrote %v", dns.writes[writes:])
+	}
+
+	if _, err := provider.AddCAARecords(context.Background(), "www.example.net", []CAARecord{{Tag: "issue", Value: "letsencrypt.org"}}); err == nil {
+		t.Error("AddCAARecords() succeeded for a name outside the zones of the subscription")
+	}
+}
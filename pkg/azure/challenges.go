@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+)
+
+// challengeRecordPrefix is the name prefix of DNS-01 challenge record sets
+const challengeRecordPrefix = "_acme-challenge"
+
+// ChallengeRecordSet describes a DNS-01 challenge TXT record set found in an Azure DNS zone
+type ChallengeRecordSet struct {
+	Zone   DNSZone
+	Name   string
+	FQDN   string
+	Values int
+
+	// Updated is the time the provider last wrote the record set, zero when the record set
+	// was not written by the provider (e.g. by lego's azuredns provider)
+	Updated time.Time
+
+	etag *string
+}
+
+// Age returns how long ago the record set was last written, or false when it is unknown
+func (r *ChallengeRecordSet) Age(now time.Time) (time.Duration, bool) {
+	if r.Updated.IsZero() {
+		return 0, false
+	}
+	return now.Sub(r.Updated), true
+}
+
+// ListChallengeRecordSets returns the _acme-challenge* TXT record sets of a zone
+func ListChallengeRecordSets(ctx context.Context, client *armdns.RecordSetsClient, zone DNSZone) ([]*ChallengeRecordSet, error) {
+	pager := client.NewListByTypePager(zone.ResourceGroup, zone.Name, armdns.RecordTypeTXT, nil)
+
+	var recordSets []*ChallengeRecordSet
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list TXT record sets of zone %s: %v", zone.Name, err)
+		}
+
+		for _, rs := range page.Value {
+			if rs == nil || rs.Name == nil || !strings.HasPrefix(strings.ToLower(*rs.Name), challengeRecordPrefix) {
+				continue
+			}
+
+			recordSet := &ChallengeRecordSet{
+				Zone: zone,
+				Name: *rs.Name,
+				FQDN: *rs.Name + "." + zone.Name,
+				etag: rs.Etag,
+			}
+			if rs.Properties != nil {
+				recordSet.Values = len(rs.Properties.TxtRecords)
+				if value := rs.Properties.Metadata[ChallengeUpdatedMetadata]; value != nil {
+					if updated, err := time.Parse(time.RFC3339, *value); err == nil {
+						recordSet.Updated = updated
+					}
+				}
+			}
+			recordSets = append(recordSets, recordSet)
+		}
+	}
+	return recordSets, nil
+}
+
+// DeleteChallengeRecordSet deletes a challenge record set, unless it was modified since it was listed
+// (e.g. by a run presenting a new challenge on the same name)
+func DeleteChallengeRecordSet(ctx context.Context, client *armdns.RecordSetsClient, recordSet *ChallengeRecordSet) error {
+	_, err := client.Delete(ctx, recordSet.Zone.ResourceGroup, recordSet.Zone.Name, recordSet.Name, armdns.RecordTypeTXT, &armdns.RecordSetsClientDeleteOptions{IfMatch: recordSet.etag})
+	switch {
+	case err == nil, isNotFound(err):
+		return nil
+	case isPreconditionFailed(err):
+		return fmt.Errorf("challenge record %s was modified concurrently, not deleted", recordSet.FQDN)
+	default:
+		return fmt.Errorf("failed to delete challenge record %s: %v", recordSet.FQDN, err)
+	}
+}
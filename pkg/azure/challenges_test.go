@@ -0,0 +1,91 @@
+package azure
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+)
+
+func TestListAndDeleteChallengeRecordSets(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	zone := DNSZone{Name: "example.com", ResourceGroup: "dns-rg"}
+	provider.AddDomain("www.example.com", zone)
+	ctx := context.Background()
+
+	if err := provider.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	// Record sets of other writers have no update time, and other TXT record sets are not challenges
+	dns.recordSets["dns-rg/example.com/_acme-challenge.shop"] = &fakeTXTRecordSet{name: "_acme-challenge.shop", values: []string{"a", "b"}}
+	dns.recordSets["dns-rg/example.com/@"] = &fakeTXTRecordSet{name: "@", values: []string{"v=spf1 -all"}}
+	dns.recordSets["dns-rg/example.net/_acme-challenge"] = &fakeTXTRecordSet{name: "_acme-challenge"}
+
+	recordSets, err := ListChallengeRecordSets(ctx, provider.client, zone)
+	if err != nil {
+		t.Fatalf("ListChallengeRecordSets() error = %v", err)
+	}
+	var names []string
+	for _, recordSet := range recordSets {
+		names = append(names, recordSet.FQDN)
+		age, known := recordSet.Age(time.Now())
+		switch recordSet.Name {
+		case "_acme-challenge.www":
+			if !known || age < 0 || age > time.Minute || recordSet.Values != 1 {
+				t.Errorf("record set written by the provider = %+v, age %s (known %t)", recordSet, age, known)
+			}
+		case "_acme-challenge.shop":
+			if known || recordSet.Values != 2 {
+				t.Errorf("record set of another writer = %+v, want an unknown age", recordSet)
+			}
+		}
+	}
+	slices.Sort(names)
+	if want := []string{"_acme-challenge.shop.example.com", "_acme-challenge.www.example.com"}; !slices.Equal(names, want) {
+		t.Fatalf("ListChallengeRecordSets() = %v, want %v", names, want)
+	}
+
+	// A record set written again after it was listed is kept
+	www := recordSets[slices.IndexFunc(recordSets, func(r *ChallengeRecordSet) bool { return r.Name == "_acme-challenge.www" })]
+	if err := provider.Present("www.example.com", "token-2", "key-auth-2"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := DeleteChallengeRecordSet(ctx, provider.client, www); err == nil {
+		t.Error("DeleteChallengeRecordSet() of a modified record set succeeded")
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge.www"); len(got) != 2 {
+		t.Errorf("TXT values = %v, want the modified record set kept", got)
+	}
+
+	shop := recordSets[slices.IndexFunc(recordSets, func(r *ChallengeRecordSet) bool { return r.Name == "_acme-challenge.shop" })]
+	if err := DeleteChallengeRecordSet(ctx, provider.client, shop); err != nil {
+		t.Fatalf("DeleteChallengeRecordSet() error = %v", err)
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge.shop"); got != nil {
+		t.Errorf("TXT values after DeleteChallengeRecordSet() = %v, want the record set deleted", got)
+	}
+}
+
+func TestDNSProviderKeepsRecordSetMetadata(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	provider.AddDomain("www.example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+	dns.recordSets["dns-rg/example.com/_acme-challenge.www"] = &fakeTXTRecordSet{
+		name:     "_acme-challenge.www",
+		metadata: map[string]*string{"owner": to.Ptr("ops")},
+	}
+
+	if err := provider.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	metadata := dns.recordSets["dns-rg/example.com/_acme-challenge.www"].metadata
+	if value := metadata["owner"]; value == nil || *value != "ops" {
+		t.Errorf("metadata = %v, want the existing metadata kept", metadata)
+	}
+	if value := metadata[ChallengeUpdatedMetadata]; value == nil {
+		t.Errorf("metadata = %v, want the update time", metadata)
+	} else if _, err := time.Parse(time.RFC3339, *value); err != nil {
+		t.Errorf("update time %q is not RFC 3339: %v", *value, err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
@@ -20,6 +21,8 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 	"github.com/google/uuid"
 	msgraph "github.com/microsoftgraph/msgraph-sdk-go"
 	"github.com/microsoftgraph/msgraph-sdk-go/applications"
@@ -34,8 +37,19 @@ type Clients struct {
 	DNS        *armdns.RecordSetsClient
 	DNSZones   *armdns.ZonesClient
 	KVCert     *azcertificates.Client
+	KVSecret   *azsecrets.Client
 	Credential *azidentity.DefaultAzureCredential
 	Graph      *msgraph.GraphServiceClient
+
+	// ResourceGraph queries resources across subscriptions and management groups
+	ResourceGraph *armresourcegraph.Client
+
+	subscriptionID string
+	vaultURL       string
+	mu             sync.Mutex
+	recordSets     map[string]*armdns.RecordSetsClient
+	certificates   map[string]*azcertificates.Client
+	secrets        map[string]*azsecrets.Client
 }
 
 // NewClients creates new Azure service clients
@@ -60,6 +74,16 @@ func NewClients(subscriptionID, vaultURL string) (*Clients, error) {
 		return nil, fmt.Errorf("failed to create Key Vault client: %v", err)
 	}
 
+	kvSecretClient, err := azsecrets.NewClient(vaultURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault secrets client: %v", err)
+	}
+
+	resourceGraphClient, err := armresourcegraph.NewClient(cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Resource Graph client: %v", err)
+	}
+
 	// Request specific Graph API scopes for application management
 	graphClient, err := msgraph.NewGraphServiceClientWithCredentials(cred, []string{
 		"https://graph.microsoft.com/.default",
@@ -72,11 +96,82 @@ func NewClients(subscriptionID, vaultURL string) (*Clients, error) {
 		DNS:        dnsClient,
 		DNSZones:   dnsZonesClient,
 		KVCert:     kvCertClient,
+		KVSecret:   kvSecretClient,
 		Credential: cred,
 		Graph:      graphClient,
+
+		ResourceGraph:  resourceGraphClient,
+		subscriptionID: subscriptionID,
+		vaultURL:       vaultURL,
+		recordSets:     map[string]*armdns.RecordSetsClient{subscriptionID: dnsClient},
+		certificates:   map[string]*azcertificates.Client{},
+		secrets:        map[string]*azsecrets.Client{},
 	}, nil
 }
 
+// RecordSets returns the DNS record sets client of a subscription, sharing the credential of the
+// other clients. An empty subscription ID selects the subscription the clients were created for.
+func (c *Clients) RecordSets(subscriptionID string) (*armdns.RecordSetsClient, error) {
+	if subscriptionID == "" {
+		subscriptionID = c.subscriptionID
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.recordSets[subscriptionID]; ok {
+		return client, nil
+	}
+	client, err := armdns.NewRecordSetsClient(subscriptionID, c.Credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS client for subscription %s: %v", subscriptionID, err)
+	}
+	c.recordSets[subscriptionID] = client
+	return client, nil
+}
+
+// Certificates returns the Key Vault certificates client of a vault, sharing the credential of the
+// other clients. An empty vault URL selects the vault the clients were created for.
+func (c *Clients) Certificates(vaultURL string) (*azcertificates.Client, error) {
+	if vaultURL == "" || strings.EqualFold(strings.TrimSuffix(vaultURL, "/"), strings.TrimSuffix(c.vaultURL, "/")) {
+		return c.KVCert, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.certificates[vaultURL]; ok {
+		return client, nil
+	}
+	client, err := azcertificates.NewClient(vaultURL, c.Credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault client for %s: %v", vaultURL, err)
+	}
+	c.certificates[vaultURL] = client
+	return client, nil
+}
+
+// Secrets returns the Key Vault secrets client of a vault, sharing the credential of the other clients.
+// An empty vault URL selects the vault the clients were created for.
+func (c *Clients) Secrets(vaultURL string) (*azsecrets.Client, error) {
+	if vaultURL == "" || strings.EqualFold(strings.TrimSuffix(vaultURL, "/"), strings.TrimSuffix(c.vaultURL, "/")) {
+		return c.KVSecret, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.secrets[vaultURL]; ok {
+		return client, nil
+	}
+	client, err := azsecrets.NewClient(vaultURL, c.Credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault secrets client for %s: %v", vaultURL, err)
+	}
+	c.secrets[vaultURL] = client
+	return client, nil
+}
+
 // CreateServicePrincipal creates a new Azure AD application and service principal
 func (c *Clients) CreateServicePrincipal(displayName, tenantID, subscriptionID string, assignDNSRole bool, resourceGroupName, keyVaultName, keyVaultResourceGroup string, noRoles bool, useCertAuth bool) (*types.ServicePrincipalInfo, error) {
 	// Validate provided tenant and subscription IDs
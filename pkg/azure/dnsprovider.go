@@ -0,0 +1,396 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// DNS-01 provider defaults, the same as lego's azuredns provider
+const (
+	DefaultDNSTTL                = 60
+	DefaultDNSPropagationTimeout = 2 * time.Minute
+	DefaultDNSPollingInterval    = 2 * time.Second
+
+	// ChallengeUpdatedMetadata is the metadata key of the time a challenge TXT record set was last written
+	// by the provider (RFC 3339), used to find challenge records left behind by interrupted runs
+	ChallengeUpdatedMetadata = "acme-challenge-updated"
+
+	// dnsUpdateAttempts limits the retries of a TXT record set update changed concurrently by another writer
+	dnsUpdateAttempts = 5
+
+	// challengeRequestTimeout bounds the Azure requests of a challenge Present or CleanUp, whose lego
+	// interface has no context
+	challengeRequestTimeout = 2 * time.Minute
+)
+
+// DNSZone identifies an Azure DNS zone. An empty subscription is the subscription of the DNS clients.
+type DNSZone struct {
+	Name          string
+	ResourceGroup string
+	Subscription  string
+}
+
+// DNSProvider solves DNS-01 challenges with the Azure DNS record sets client, using the credential of
+// the other Azure clients. Challenge values are added to and removed from the TXT record set one by
+// one with ETag checks, so challenges presented concurrently for the same name do not overwrite each other.
+//
+// Challenges of domains delegated with a CNAME record to a name in another Azure DNS zone (challenge
+// aliases) are written to that zone instead. Such zones are searched in the subscription, or in the
+// zones of the lister set with SetZoneLister.
+type DNSProvider struct {
+	client             *armdns.RecordSetsClient
+	zonesClient        *armdns.ZonesClient
+	ttl                int64
+	propagationTimeout time.Duration
+	pollingInterval    time.Duration
+
+	// subscriptionClients returns the record sets client of another subscription
+	subscriptionClients func(subscriptionID string) (*armdns.RecordSetsClient, error)
+
+	mu      sync.Mutex
+	domains map[string]DNSZone
+	aliases map[string]string
+
+	// listZones lists the zones searched for names without a known zone, described by zoneScope in errors
+	listZones func(ctx context.Context) ([]DNSZone, error)
+	zoneScope string
+
+	// searchZones caches the listed zones, listed when the first alias is resolved
+	searchZones []DNSZone
+}
+
+// NewDNSProvider creates a DNS-01 provider on the Azure DNS record sets and zones clients
+func NewDNSProvider(client *armdns.RecordSetsClient, zonesClient *armdns.ZonesClient, ttl int, propagationTimeout, pollingInterval time.Duration) *DNSProvider {
+	return &DNSProvider{
+		client:             client,
+		zonesClient:        zonesClient,
+		ttl:                int64(ttl),
+		propagationTimeout: propagationTimeout,
+		pollingInterval:    pollingInterval,
+		domains:            make(map[string]DNSZone),
+		aliases:            make(map[string]string),
+		zoneScope:          "the subscription",
+	}
+}
+
+// SetZoneLister makes the provider search the zones returned by the function (e.g. the zones of the
+// Resource Graph discovery scope) instead of the zones of its subscription. A nil function keeps the zones
+// of the subscription. scope describes the zones in error messages, e.g. "subscription <id>".
+func (p *DNSProvider) SetZoneLister(scope string, list func(ctx context.Context) ([]DNSZone, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.zoneScope = scope
+	p.listZones = list
+	p.searchZones = nil
+}
+
+// SetSubscriptionClients makes the provider write to zones of other subscriptions with the record
+// sets clients returned by the function (e.g. Clients.RecordSets)
+func (p *DNSProvider) SetSubscriptionClients(clients func(subscriptionID string) (*armdns.RecordSetsClient, error)) {
+	p.subscriptionClients = clients
+}
+
+// recordSetsClient returns the record sets client of the subscription of a zone
+func (p *DNSProvider) recordSetsClient(zone DNSZone) (*armdns.RecordSetsClient, error) {
+	if zone.Subscription == "" || p.subscriptionClients == nil {
+		return p.client, nil
+	}
+	return p.subscriptionClients(zone.Subscription)
+}
+
+// AddDomain records the zone a domain was found in, so its challenges are written to that zone
+func (p *DNSProvider) AddDomain(domain string, zone DNSZone) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.domains[normalizeDomain(domain)] = zone
+}
+
+// AddChallengeAlias records that the challenge record of a domain is delegated to the alias name,
+// so its challenges are written to the alias in the Azure DNS zone containing it
+func (p *DNSProvider) AddChallengeAlias(domain, alias string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.aliases[normalizeDomain(domain)] = strings.ToLower(dns01.UnFqdn(alias))
+}
+
+// Timeout returns the propagation timeout and polling interval (implements challenge.ProviderTimeout)
+func (p *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return p.propagationTimeout, p.pollingInterval
+}
+
+// Present adds the challenge value to the TXT record set of the domain
+func (p *DNSProvider) Present(domain, token, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), challengeRequestTimeout)
+	defer cancel()
+
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	zone, name, err := p.recordSet(ctx, domain, info.EffectiveFQDN)
+	if err != nil {
+		return err
+	}
+
+	err = p.updateTXT(ctx, zone, name, func(values []string) []string {
+		for _, value := range values {
+			if value == info.Value {
+				return values
+			}
+		}
+		return append(values, info.Value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add challenge record %s: %v", info.EffectiveFQDN, err)
+	}
+
+	log.Printf("DNS challenge record added: fqdn=%s, zone=%s, resource_group=%s%s", info.EffectiveFQDN, zone.Name, zone.ResourceGroup, subscriptionSuffix(zone))
+	return nil
+}
+
+// CleanUp removes the challenge value from the TXT record set of the domain, and the record set once it is empty
+func (p *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), challengeRequestTimeout)
+	defer cancel()
+
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	zone, name, err := p.recordSet(ctx, domain, info.EffectiveFQDN)
+	if err != nil {
+		return err
+	}
+
+	err = p.updateTXT(ctx, zone, name, func(values []string) []string {
+		var kept []string
+		for _, value := range values {
+			if value != info.Value {
+				kept = append(kept, value)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove challenge record %s: %v", info.EffectiveFQDN, err)
+	}
+
+	log.Printf("DNS challenge record removed: fqdn=%s, zone=%s", info.EffectiveFQDN, zone.Name)
+	return nil
+}
+
+// recordSet returns the zone and the relative record set name of a challenge FQDN, or of the
+// alias of the domain when its challenge record is delegated
+func (p *DNSProvider) recordSet(ctx context.Context, domain, fqdn string) (DNSZone, string, error) {
+	p.mu.Lock()
+	zone, ok := p.domains[normalizeDomain(domain)]
+	alias := p.aliases[normalizeDomain(domain)]
+	p.mu.Unlock()
+
+	if alias != "" {
+		aliasZone, err := p.findZone(ctx, alias)
+		if err != nil {
+			return DNSZone{}, "", err
+		}
+		zone, ok, fqdn = aliasZone, true, alias
+	}
+	if !ok {
+		// Names without a record of their own, e.g. additional names of a certificate
+		var err error
+		if zone, err = p.findZone(ctx, domain); err != nil {
+			return DNSZone{}, "", fmt.Errorf("no DNS zone known for domain %s: %v", domain, err)
+		}
+	}
+
+	name, inZone := relativeName(strings.ToLower(dns01.UnFqdn(fqdn)), zone.Name)
+	if !inZone {
+		return DNSZone{}, "", fmt.Errorf("challenge record %s is not in zone %s", fqdn, zone.Name)
+	}
+	return zone, name, nil
+}
+
+// findZone returns the Azure DNS zone with the longest name containing the FQDN, among the zones of the
+// subscription or of the zone lister
+func (p *DNSProvider) findZone(ctx context.Context, fqdn string) (DNSZone, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.searchZones == nil {
+		list := p.listZones
+		if list == nil {
+			list = p.listSubscriptionZones
+		}
+		zones, err := list(ctx)
+		if err != nil {
+			return DNSZone{}, fmt.Errorf("failed to list DNS zones of %s: %v", p.zoneScope, err)
+		}
+		p.searchZones = zones
+	}
+
+	var found DNSZone
+	for _, zone := range p.searchZones {
+		if _, ok := relativeName(fqdn, zone.Name); ok && len(zone.Name) > len(found.Name) {
+			found = zone
+		}
+	}
+	if found.Name == "" {
+		return DNSZone{}, fmt.Errorf("no Azure DNS zone in %s contains %s", p.zoneScope, fqdn)
+	}
+	return found, nil
+}
+
+// listSubscriptionZones lists the DNS zones of the subscription of the zones client
+func (p *DNSProvider) listSubscriptionZones(ctx context.Context) ([]DNSZone, error) {
+	pager := p.zonesClient.NewListPager(nil)
+	zones := []DNSZone{}
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, zone := range page.Value {
+			if zone == nil || zone.Name == nil || zone.ID == nil {
+				continue
+			}
+			id, err := arm.ParseResourceID(*zone.ID)
+			if err != nil {
+				continue
+			}
+			zones = append(zones, DNSZone{Name: *zone.Name, ResourceGroup: id.ResourceGroupName})
+		}
+	}
+	return zones, nil
+}
+
+// relativeName returns the record set name of an FQDN relative to a zone, and whether the FQDN is in the zone
+func relativeName(fqdn, zone string) (string, bool) {
+	zone = strings.ToLower(zone)
+	if fqdn == zone {
+		return "@", true
+	}
+	if !strings.HasSuffix(fqdn, "."+zone) {
+		return "", false
+	}
+	return strings.TrimSuffix(fqdn, "."+zone), true
+}
+
+// updateTXT applies a change to the values of a TXT record set. The record set is written only if it
+// was not modified since it was read, and the change is retried on concurrent modifications.
+func (p *DNSProvider) updateTXT(ctx context.Context, zone DNSZone, name string, change func(values []string) []string) error {
+	var err error
+	for attempt := 1; attempt <= dnsUpdateAttempts; attempt++ {
+		if err = p.tryUpdateTXT(ctx, zone, name, change); !isPreconditionFailed(err) {
+			return err
+		}
+		log.Printf("DNS record set modified concurrently, retrying: zone=%s, name=%s, attempt=%d", zone.Name, name, attempt)
+	}
+	return err
+}
+
+// tryUpdateTXT reads a TXT record set, applies a change to its values and writes it back conditionally
+func (p *DNSProvider) tryUpdateTXT(ctx context.Context, zone DNSZone, name string, change func(values []string) []string) error {
+	var values []string
+	var etag *string
+	metadata := make(map[string]*string)
+
+	client, err := p.recordSetsClient(zone)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get(ctx, zone.ResourceGroup, zone.Name, name, armdns.RecordTypeTXT, nil)
+	switch {
+	case err == nil:
+		etag = resp.Etag
+		if resp.Properties != nil {
+			for key, value := range resp.Properties.Metadata {
+				metadata[key] = value
+			}
+			for _, record := range resp.Properties.TxtRecords {
+				if record == nil {
+					continue
+				}
+				var parts []string
+				for _, part := range record.Value {
+					if part != nil {
+						parts = append(parts, *part)
+					}
+				}
+				values = append(values, strings.Join(parts, ""))
+			}
+		}
+	case isNotFound(err):
+		// The record set is created below
+	default:
+		return err
+	}
+
+	updated := change(values)
+	if len(updated) == len(values) && etag != nil {
+		// Nothing to change: the value is already present or already removed
+		return nil
+	}
+
+	if len(updated) == 0 {
+		if etag == nil {
+			return nil
+		}
+		_, err := client.Delete(ctx, zone.ResourceGroup, zone.Name, name, armdns.RecordTypeTXT, &armdns.RecordSetsClientDeleteOptions{IfMatch: etag})
+		return err
+	}
+
+	records := make([]*armdns.TxtRecord, 0, len(updated))
+	for _, value := range updated {
+		records = append(records, &armdns.TxtRecord{Value: []*string{to.Ptr(value)}})
+	}
+
+	options := &armdns.RecordSetsClientCreateOrUpdateOptions{IfMatch: etag}
+	if etag == nil {
+		options = &armdns.RecordSetsClientCreateOrUpdateOptions{IfNoneMatch: to.Ptr("*")}
+	}
+
+	// Keep the metadata of the record set and record when it was written, for cleanup-challenges
+	metadata[ChallengeUpdatedMetadata] = to.Ptr(time.Now().UTC().Format(time.RFC3339))
+
+	_, err = client.CreateOrUpdate(ctx, zone.ResourceGroup, zone.Name, name, armdns.RecordTypeTXT, armdns.RecordSet{
+		Properties: &armdns.RecordSetProperties{
+			TTL:        to.Ptr(p.ttl),
+			Metadata:   metadata,
+			TxtRecords: records,
+		},
+	}, options)
+	return err
+}
+
+// subscriptionSuffix returns the subscription of a zone of another subscription for log messages
+func subscriptionSuffix(zone DNSZone) string {
+	if zone.Subscription == "" {
+		return ""
+	}
+	return ", subscription=" + zone.Subscription
+}
+
+// normalizeDomain returns the name a challenge domain is recorded under, without a wildcard label
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimPrefix(dns01.UnFqdn(domain), "*."))
+}
+
+// isNotFound reports whether an Azure request failed because the resource does not exist
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// isPreconditionFailed reports whether a conditional Azure request failed because the resource was modified
+func isPreconditionFailed(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusPreconditionFailed
+}
@@ -0,0 +1,408 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+type testCredential struct{}
+
+func (testCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeTXTRecordSet is a TXT record set of the fake DNS service
+type fakeTXTRecordSet struct {
+	name     string
+	values   []string
+	metadata map[string]*string
+	etag     int
+}
+
+// fakeDNS serves the TXT record sets of Azure DNS zones from memory, honouring If-Match and If-None-Match
+type fakeDNS struct {
+	mu         sync.Mutex
+	recordSets map[string]*fakeTXTRecordSet // resource group/zone/name
+	caa        map[string]*armdns.RecordSet // resource group/zone/name
+	zones      []DNSZone
+	writes     []string
+	// conflicts is the number of writes still to be refused as modified concurrently
+	conflicts int
+}
+
+// newFakeDNS returns a fake DNS service and a provider writing to it
+func newFakeDNS(t *testing.T) (*fakeDNS, *DNSProvider) {
+	t.Helper()
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+
+	dns := &fakeDNS{recordSets: make(map[string]*fakeTXTRecordSet), caa: make(map[string]*armdns.RecordSet)}
+	options := &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: dns}}
+	client, err := armdns.NewRecordSetsClient("subscription", testCredential{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zonesClient, err := armdns.NewZonesClient("subscription", testCredential{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dns, NewDNSProvider(client, zonesClient, 60, time.Minute, time.Second)
+}
+
+// Do implements policy.Transporter for /subscriptions/{id}/resourceGroups/{rg}/providers/Microsoft.Network/dnsZones/{zone}/TXT/{name}
+// with the TXT record set list of a zone, and the zone list /subscriptions/{id}/providers/Microsoft.Network/dnszones
+func (d *fakeDNS) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(path) == 5 && strings.EqualFold(path[4], "dnszones") && req.Method == http.MethodGet {
+		return d.respond(req, http.StatusOK, d.zoneList()), nil
+	}
+	if len(path) == 9 && path[8] == "TXT" && req.Method == http.MethodGet {
+		return d.respond(req, http.StatusOK, d.recordSetList(path[3], path[7])), nil
+	}
+	if len(path) == 10 && path[8] == "CAA" {
+		return d.doCAA(req, path[3]+"/"+path[7]+"/"+path[9])
+	}
+	if len(path) != 10 || path[8] != "TXT" {
+		return d.respond(req, http.StatusNotImplemented, nil), nil
+	}
+	key := path[3] + "/" + path[7] + "/" + path[9]
+	recordSet := d.recordSets[key]
+
+	if req.Method != http.MethodGet {
+		ifMatch, ifNoneMatch := req.Header.Get("If-Match"), req.Header.Get("If-None-Match")
+		modified := (recordSet == nil && ifMatch != "") ||
+			(recordSet != nil && ifMatch != "" && ifMatch != fmt.Sprint(recordSet.etag)) ||
+			(recordSet != nil && ifNoneMatch == "*")
+		if modified || d.conflicts > 0 {
+			if d.conflicts > 0 {
+				d.conflicts--
+			}
+			return d.error(req, http.StatusPreconditionFailed, "PreconditionFailed"), nil
+		}
+		d.writes = append(d.writes, req.Method+" "+path[9])
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if recordSet == nil {
+			return d.error(req, http.StatusNotFound, "NotFound"), nil
+		}
+		return d.respond(req, http.StatusOK, d.recordSet(recordSet)), nil
+	case http.MethodPut:
+		var body armdns.RecordSet
+		data, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			return nil, err
+		}
+		if recordSet == nil {
+			recordSet = &fakeTXTRecordSet{name: path[9]}
+			d.recordSets[key] = recordSet
+		}
+		recordSet.values = nil
+		recordSet.metadata = body.Properties.Metadata
+		for _, record := range body.Properties.TxtRecords {
+			recordSet.values = append(recordSet.values, *record.Value[0])
+		}
+		recordSet.etag++
+		return d.respond(req, http.StatusOK, d.recordSet(recordSet)), nil
+	case http.MethodDelete:
+		delete(d.recordSets, key)
+		return d.respond(req, http.StatusOK, nil), nil
+	}
+	return d.respond(req, http.StatusMethodNotAllowed, nil), nil
+}
+
+// doCAA serves a CAA record set, keeping the record set as written with an ETag counter
+func (d *fakeDNS) doCAA(req *http.Request, key string) (*http.Response, error) {
+	recordSet := d.caa[key]
+	switch req.Method {
+	case http.MethodGet:
+		if recordSet == nil {
+			return d.error(req, http.StatusNotFound, "NotFound"), nil
+		}
+		return d.respond(req, http.StatusOK, recordSet), nil
+	case http.MethodPut:
+		ifMatch, ifNoneMatch := req.Header.Get("If-Match"), req.Header.Get("If-None-Match")
+		if (recordSet == nil && ifMatch != "") || (recordSet != nil && ifNoneMatch == "*") ||
+			(recordSet != nil && ifMatch != "" && ifMatch != *recordSet.Etag) {
+			return d.error(req, http.StatusPreconditionFailed, "PreconditionFailed"), nil
+		}
+		var body armdns.RecordSet
+		data, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			return nil, err
+		}
+		etag := 1
+		if recordSet != nil {
+			fmt.Sscan(*recordSet.Etag, &etag)
+			etag++
+		}
+		body.Etag = to.Ptr(fmt.Sprint(etag))
+		d.caa[key] = &body
+		d.writes = append(d.writes, req.Method+" CAA "+key)
+		return d.respond(req, http.StatusOK, body), nil
+	}
+	return d.respond(req, http.StatusMethodNotAllowed, nil), nil
+}
+
+// values returns the TXT values of a record set, nil when it does not exist
+func (d *fakeDNS) values(resourceGroup, zone, name string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if recordSet := d.recordSets[resourceGroup+"/"+zone+"/"+name]; recordSet != nil {
+		return recordSet.values
+	}
+	return nil
+}
+
+func (d *fakeDNS) zoneList() armdns.ZoneListResult {
+	var list armdns.ZoneListResult
+	for _, zone := range d.zones {
+		id := "/subscriptions/subscription/resourceGroups/" + zone.ResourceGroup + "/providers/Microsoft.Network/dnszones/" + zone.Name
+		list.Value = append(list.Value, &armdns.Zone{ID: to.Ptr(id), Name: to.Ptr(zone.Name), Location: to.Ptr("global")})
+	}
+	return list
+}
+
+func (d *fakeDNS) recordSetList(resourceGroup, zone string) armdns.RecordSetListResult {
+	var list armdns.RecordSetListResult
+	for key, recordSet := range d.recordSets {
+		if strings.HasPrefix(key, resourceGroup+"/"+zone+"/") {
+			rs := d.recordSet(recordSet)
+			list.Value = append(list.Value, &rs)
+		}
+	}
+	return list
+}
+
+func (d *fakeDNS) recordSet(recordSet *fakeTXTRecordSet) armdns.RecordSet {
+	records := make([]*armdns.TxtRecord, 0, len(recordSet.values))
+	for _, value := range recordSet.values {
+		records = append(records, &armdns.TxtRecord{Value: []*string{to.Ptr(value)}})
+	}
+	return armdns.RecordSet{
+		Name:       to.Ptr(recordSet.name),
+		Etag:       to.Ptr(fmt.Sprint(recordSet.etag)),
+		Properties: &armdns.RecordSetProperties{TxtRecords: records, Metadata: recordSet.metadata},
+	}
+}
+
+func (d *fakeDNS) error(req *http.Request, status int, code string) *http.Response {
+	return d.respond(req, status, map[string]any{"error": map[string]string{"code": code, "message": code}})
+}
+
+func (d *fakeDNS) respond(req *http.Request, status int, body any) *http.Response {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+}
+
+func TestDNSProviderPresentAndCleanUp(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	provider.AddDomain("example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+
+	// The challenges of example.com and *.example.com are both presented for example.com and share one record set
+	apex := dns01.GetChallengeInfo("example.com", "key-auth-1")
+	wildcard := dns01.GetChallengeInfo("example.com", "key-auth-2")
+
+	if err := provider.Present("example.com", "token-1", "key-auth-1"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := provider.Present("example.com", "token-2", "key-auth-2"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if err := provider.Present("example.com", "token-1", "key-auth-1"); err != nil {
+		t.Fatalf("repeated Present() error = %v", err)
+	}
+
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge"); !slices.Equal(got, []string{apex.Value, wildcard.Value}) {
+		t.Fatalf("TXT values = %v, want both challenge values", got)
+	}
+	if len(dns.writes) != 2 {
+		t.Errorf("writes = %v, want one write per new value", dns.writes)
+	}
+
+	if err := provider.CleanUp("example.com", "token-1", "key-auth-1"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge"); !slices.Equal(got, []string{wildcard.Value}) {
+		t.Errorf("TXT values after the first CleanUp() = %v, want the other challenge value", got)
+	}
+
+	if err := provider.CleanUp("example.com", "token-2", "key-auth-2"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge"); got != nil {
+		t.Errorf("TXT values after the last CleanUp() = %v, want the record set deleted", got)
+	}
+	if err := provider.CleanUp("example.com", "token-2", "key-auth-2"); err != nil {
+		t.Errorf("CleanUp() of a deleted record set error = %v", err)
+	}
+}
+
+func TestDNSProviderRetriesConcurrentModification(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	provider.AddDomain("www.example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+
+	dns.conflicts = 2
+	if err := provider.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge.www"); len(got) != 1 {
+		t.Errorf("TXT values = %v, want the challenge value", got)
+	}
+
+	dns.conflicts = dnsUpdateAttempts
+	if err := provider.CleanUp("www.example.com", "token", "key-auth"); err == nil {
+		t.Error("CleanUp() succeeded although every attempt was refused")
+	}
+}
+
+func TestDNSProviderRecordSet(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	dns.zones = []DNSZone{{Name: "example.org", ResourceGroup: "other-rg"}}
+	provider.AddDomain("Shop.Example.com.", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+	provider.AddDomain("example.net", DNSZone{Name: "example.net", ResourceGroup: "dns-rg"})
+
+	tests := []struct {
+		domain, fqdn string
+		want         string
+		wantErr      bool
+	}{
+		{domain: "shop.example.com", fqdn: "_acme-challenge.shop.example.com.", want: "_acme-challenge.shop"},
+		{domain: "*.shop.example.com", fqdn: "_acme-challenge.shop.example.com.", want: "_acme-challenge.shop"},
+		{domain: "example.net", fqdn: "example.net.", want: "@"},
+		// A CNAME pointing into another zone cannot be written through the zone of the domain
+		{domain: "shop.example.com", fqdn: "_acme-challenge.shop.example.org.", wantErr: true},
+		// Names without a record are written to the zone of the subscription containing them
+		{domain: "www.example.org", fqdn: "_acme-challenge.www.example.org.", want: "_acme-challenge.www"},
+		{domain: "www.example.com", fqdn: "_acme-challenge.www.example.com.", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, got, err := provider.recordSet(context.Background(), tt.domain, tt.fqdn)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("recordSet(%q, %q) = %q, %v, want %q (error %t)", tt.domain, tt.fqdn, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDNSProviderChallengeAlias(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	dns.zones = []DNSZone{
+		{Name: "example.net", ResourceGroup: "validation-rg"},
+		{Name: "acme.example.net", ResourceGroup: "acme-rg"},
+		{Name: "example.com", ResourceGroup: "dns-rg"},
+	}
+	provider.AddDomain("www.example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+	provider.AddChallengeAlias("www.example.com", "WWW.acme.example.net.")
+	provider.AddDomain("shop.example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+	provider.AddChallengeAlias("shop.example.com", "shop.example.org")
+
+	if err := provider.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	// The alias is written to the most specific zone containing it, not to the zone of the domain
+	if got := dns.values("acme-rg", "acme.example.net", "www"); len(got) != 1 {
+		t.Errorf("TXT values of the alias = %v, want the challenge value", got)
+	}
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge.www"); got != nil {
+		t.Errorf("TXT values in the zone of the domain = %v, want none", got)
+	}
+
+	if err := provider.CleanUp("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if got := dns.values("acme-rg", "acme.example.net", "www"); got != nil {
+		t.Errorf("TXT values of the alias after CleanUp() = %v, want the record set deleted", got)
+	}
+
+	if err := provider.Present("shop.example.com", "token", "key-auth"); err == nil {
+		t.Error("Present() succeeded for an alias outside the zones of the subscription")
+	}
+}
+
+func TestDNSProviderWritesToZonesOfOtherSubscriptions(t *testing.T) {
+	dns, provider := newFakeDNS(t)
+	other, otherProvider := newFakeDNS(t)
+
+	var requested []string
+	provider.SetSubscriptionClients(func(subscriptionID string) (*armdns.RecordSetsClient, error) {
+		requested = append(requested, subscriptionID)
+		return otherProvider.client, nil
+	})
+	provider.AddDomain("www.example.com", DNSZone{Name: "example.com", ResourceGroup: "dns-rg"})
+	provider.AddDomain("www.example.net", DNSZone{Name: "example.net", ResourceGroup: "other-rg", Subscription: "sub-2"})
+
+	for _, domain := range []string{"www.example.com", "www.example.net"} {
+		if err := provider.Present(domain, "token", "key-auth"); err != nil {
+			t.Fatalf("Present(%s) error = %v", domain, err)
+		}
+	}
+
+	if got := dns.values("dns-rg", "example.com", "_acme-challenge.www"); len(got) != 1 {
+		t.Errorf("TXT values in the subscription of the provider = %v, want the challenge value", got)
+	}
+	if got := other.values("other-rg", "example.net", "_acme-challenge.www"); len(got) != 1 {
+		t.Errorf("TXT values in the other subscription = %v, want the challenge value", got)
+	}
+	if len(requested) == 0 || slices.ContainsFunc(requested, func(id string) bool { return id != "sub-2" }) {
+		t.Errorf("requested clients = %v, want only the other subscription", requested)
+	}
+}
+
+func TestDNSProviderSearchesZonesOfLister(t *testing.T) {
+	_, provider := newFakeDNS(t)
+	other, otherProvider := newFakeDNS(t)
+	provider.SetSubscriptionClients(func(subscriptionID string) (*armdns.RecordSetsClient, error) {
+		return otherProvider.client, nil
+	})
+
+	listed := 0
+	provider.SetZoneLister("the discovery scope", func(ctx context.Context) ([]DNSZone, error) {
+		listed++
+		return []DNSZone{{Name: "example.net", ResourceGroup: "other-rg", Subscription: "sub-2"}}, nil
+	})
+
+	// Names without a known zone are looked up in the listed zones, which are listed once
+	for _, domain := range []string{"www.example.net", "api.example.net"} {
+		if err := provider.Present(domain, "token", "key-auth"); err != nil {
+			t.Fatalf("Present(%s) error = %v", domain, err)
+		}
+	}
+	if got := other.values("other-rg", "example.net", "_acme-challenge.www"); len(got) != 1 {
+		t.Errorf("TXT values in the listed zone = %v, want the challenge value", got)
+	}
+	if listed != 1 {
+		t.Errorf("zones listed %d times, want once", listed)
+	}
+
+	err := provider.Present("www.example.org", "token", "key-auth")
+	if err == nil || !strings.Contains(err.Error(), "the discovery scope") {
+		t.Errorf("Present() of a name outside the listed zones error = %v, want one naming the scope", err)
+	}
+}
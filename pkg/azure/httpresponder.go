@@ -0,0 +1,75 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/go-acme/lego/v4/challenge/http01"
+)
+
+// StaticWebsiteContainer is the container served by the static website of a storage account
+const StaticWebsiteContainer = "$web"
+
+// BlobHTTPProvider solves HTTP-01 challenges by writing the challenge files to a blob container,
+// usually the $web container of a storage account static website serving the domains
+type BlobHTTPProvider struct {
+	client    *azblob.Client
+	container string
+}
+
+// NewBlobHTTPProvider creates an HTTP-01 provider writing to a container of a storage account
+// (the static website container when container is empty)
+func NewBlobHTTPProvider(credential azcore.TokenCredential, accountURL, container string) (*BlobHTTPProvider, error) {
+	client, err := azblob.NewClient(accountURL, credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %v", err)
+	}
+
+	if container == "" {
+		container = StaticWebsiteContainer
+	}
+
+	return &BlobHTTPProvider{
+		client:    client,
+		container: container,
+	}, nil
+}
+
+// Present uploads the challenge file to the container
+func (p *BlobHTTPProvider) Present(domain, token, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), challengeRequestTimeout)
+	defer cancel()
+
+	name := blobName(token)
+	_, err := p.client.UploadBuffer(ctx, p.container, name, []byte(keyAuth), &azblob.UploadBufferOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr("text/plain")},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload challenge file %s: %v", name, err)
+	}
+
+	log.Printf("HTTP challenge file uploaded: domain=%s, container=%s, blob=%s", domain, p.container, name)
+	return nil
+}
+
+// CleanUp deletes the challenge file from the container
+func (p *BlobHTTPProvider) CleanUp(domain, token, keyAuth string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), challengeRequestTimeout)
+	defer cancel()
+
+	name := blobName(token)
+	if _, err := p.client.DeleteBlob(ctx, p.container, name, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete challenge file %s: %v", name, err)
+	}
+	return nil
+}
+
+// blobName returns the name of the challenge file of a token, relative to the site root
+func blobName(token string) string {
+	return http01.ChallengePath(token)[1:]
+}
@@ -0,0 +1,10 @@
+package azure
+
+import "testing"
+
+func TestBlobName(t *testing.T) {
+	// Blob names are relative to the container, which is the root of the static website
+	if got := blobName("token"); got != ".well-known/acme-challenge/token" {
+		t.Errorf("blobName() = %q, want .well-known/acme-challenge/token", got)
+	}
+}
@@ -0,0 +1,174 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+)
+
+// Resource Graph queries of the DNS zones and the record sets marked for ACME processing
+const (
+	acmeRecordSetsQuery = `dnsresources
+| where type in~ ('microsoft.network/dnszones/a', 'microsoft.network/dnszones/cname')
+| where tostring(properties.metadata.acme) =~ 'true'
+| project id, name, type, etag, properties`
+
+	dnsZonesQuery = `resources
+| where type =~ 'microsoft.network/dnszones'
+| project id, name, properties`
+
+	// resourceGraphPageSize is the maximum number of rows of a Resource Graph response
+	resourceGraphPageSize = 1000
+)
+
+// GraphScope selects the subscriptions and management groups searched with Azure Resource Graph
+type GraphScope struct {
+	Subscriptions    []string
+	ManagementGroups []string
+}
+
+// String describes the scope for log messages
+func (s GraphScope) String() string {
+	var parts []string
+	if len(s.Subscriptions) > 0 {
+		parts = append(parts, "subscriptions="+strings.Join(s.Subscriptions, ","))
+	}
+	if len(s.ManagementGroups) > 0 {
+		parts = append(parts, "management_groups="+strings.Join(s.ManagementGroups, ","))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GraphRecordSet is a DNS record set found with Azure Resource Graph
+type GraphRecordSet struct {
+	Zone      DNSZone
+	RecordSet *armdns.RecordSet
+}
+
+// GraphZone is a DNS zone found with Azure Resource Graph
+type GraphZone struct {
+	Zone        DNSZone
+	NameServers []string
+}
+
+// QueryACMERecordSets finds the A and CNAME record sets with acme=true metadata in all DNS zones of
+// the scope with a single Resource Graph query. Record set types are returned in the casing of the
+// DNS API (e.g. Microsoft.Network/dnszones/A).
+func QueryACMERecordSets(ctx context.Context, client *armresourcegraph.Client, scope GraphScope) ([]GraphRecordSet, error) {
+	rows, err := queryResourceGraph(ctx, client, acmeRecordSetsQuery, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	recordSets := make([]GraphRecordSet, 0, len(rows))
+	for _, row := range rows {
+		var rs armdns.RecordSet
+		if err := json.Unmarshal(row, &rs); err != nil {
+			return nil, fmt.Errorf("failed to parse Resource Graph record set: %v", err)
+		}
+		if rs.ID == nil || rs.Type == nil {
+			continue
+		}
+
+		id, err := arm.ParseResourceID(*rs.ID)
+		if err != nil || id.Parent == nil {
+			return nil, fmt.Errorf("failed to parse record set ID %s: %v", *rs.ID, err)
+		}
+
+		recordType := (*rs.Type)[strings.LastIndex(*rs.Type, "/")+1:]
+		rs.Type = to.Ptr("Microsoft.Network/dnszones/" + strings.ToUpper(recordType))
+
+		recordSets = append(recordSets, GraphRecordSet{
+			Zone:      DNSZone{Name: strings.ToLower(id.Parent.Name), ResourceGroup: id.ResourceGroupName, Subscription: id.SubscriptionID},
+			RecordSet: &rs,
+		})
+	}
+	return recordSets, nil
+}
+
+// QueryDNSZones finds all DNS zones of the scope with a single Resource Graph query
+func QueryDNSZones(ctx context.Context, client *armresourcegraph.Client, scope GraphScope) ([]GraphZone, error) {
+	rows, err := queryResourceGraph(ctx, client, dnsZonesQuery, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := make([]GraphZone, 0, len(rows))
+	for _, row := range rows {
+		var zone armdns.Zone
+		if err := json.Unmarshal(row, &zone); err != nil {
+			return nil, fmt.Errorf("failed to parse Resource Graph DNS zone: %v", err)
+		}
+		if zone.ID == nil {
+			continue
+		}
+
+		id, err := arm.ParseResourceID(*zone.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DNS zone ID %s: %v", *zone.ID, err)
+		}
+
+		graphZone := GraphZone{Zone: DNSZone{Name: strings.ToLower(id.Name), ResourceGroup: id.ResourceGroupName, Subscription: id.SubscriptionID}}
+		if zone.Properties != nil {
+			for _, ns := range zone.Properties.NameServers {
+				if ns != nil {
+					graphZone.NameServers = append(graphZone.NameServers, *ns)
+				}
+			}
+		}
+		zones = append(zones, graphZone)
+	}
+	return zones, nil
+}
+
+// queryResourceGraph runs a Resource Graph query over a scope and returns all rows, following the
+// continuation tokens of paged responses
+func queryResourceGraph(ctx context.Context, client *armresourcegraph.Client, query string, scope GraphScope) ([]json.RawMessage, error) {
+	request := armresourcegraph.QueryRequest{
+		Query:            to.Ptr(query),
+		Subscriptions:    stringPtrs(scope.Subscriptions),
+		ManagementGroups: stringPtrs(scope.ManagementGroups),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: to.Ptr(armresourcegraph.ResultFormatObjectArray),
+			Top:          to.Ptr(int32(resourceGraphPageSize)),
+		},
+	}
+
+	var rows []json.RawMessage
+	for {
+		resp, err := client.Resources(ctx, request, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Resource Graph query failed: %v", err)
+		}
+
+		// The rows of the object array format are decoded as generic JSON values
+		data, err := json.Marshal(resp.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Resource Graph response: %v", err)
+		}
+		var page []json.RawMessage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to read Resource Graph response: %v", err)
+		}
+		rows = append(rows, page...)
+
+		if resp.SkipToken == nil || *resp.SkipToken == "" {
+			return rows, nil
+		}
+		request.Options.SkipToken = resp.SkipToken
+	}
+}
+
+// stringPtrs converts a list of strings for a request, omitting empty lists
+func stringPtrs(values []string) []*string {
+	if len(values) == 0 {
+		return nil
+	}
+	return to.SliceOfPtrs(values...)
+}
@@ -0,0 +1,129 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+)
+
+// fakeResourceGraph answers Resource Graph queries with pages of rows, one page per request
+type fakeResourceGraph struct {
+	pages    [][]map[string]any
+	requests []armresourcegraph.QueryRequest
+}
+
+func (g *fakeResourceGraph) Do(req *http.Request) (*http.Response, error) {
+	var request armresourcegraph.QueryRequest
+	data, _ := io.ReadAll(req.Body)
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	g.requests = append(g.requests, request)
+
+	page := len(g.requests) - 1
+	body := map[string]any{"data": g.pages[page], "count": len(g.pages[page]), "totalRecords": 0, "resultTruncated": "false"}
+	if page < len(g.pages)-1 {
+		body["$skipToken"] = fmt.Sprintf("page-%d", page+1)
+	}
+	data, _ = json.Marshal(body)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}, nil
+}
+
+func newFakeResourceGraph(t *testing.T, pages ...[]map[string]any) (*fakeResourceGraph, *armresourcegraph.Client) {
+	t.Helper()
+	graph := &fakeResourceGraph{pages: pages}
+	client, err := armresourcegraph.NewClient(testCredential{}, &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: graph}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph, client
+}
+
+func TestQueryACMERecordSets(t *testing.T) {
+	recordSet := func(subscription, resourceGroup, zone, recordType, name string) map[string]any {
+		return map[string]any{
+			"id":         "/subscriptions/" + subscription + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.Network/dnszones/" + zone + "/" + recordType + "/" + name,
+			"name":       name,
+			"type":       "microsoft.network/dnszones/" + recordType,
+			"properties": map[string]any{"metadata": map[string]any{"acme": "true"}},
+		}
+	}
+	graph, client := newFakeResourceGraph(t,
+		[]map[string]any{recordSet("sub-1", "dns-rg", "Example.com", "a", "www")},
+		[]map[string]any{recordSet("sub-2", "other-rg", "example.net", "cname", "shop"), {"name": "without id"}},
+	)
+
+	scope := GraphScope{Subscriptions: []string{"sub-1", "sub-2"}}
+	recordSets, err := QueryACMERecordSets(context.Background(), client, scope)
+	if err != nil {
+		t.Fatalf("QueryACMERecordSets() error = %v", err)
+	}
+
+	want := []GraphRecordSet{
+		{Zone: DNSZone{Name: "example.com", ResourceGroup: "dns-rg", Subscription: "sub-1"}},
+		{Zone: DNSZone{Name: "example.net", ResourceGroup: "other-rg", Subscription: "sub-2"}},
+	}
+	if len(recordSets) != len(want) {
+		t.Fatalf("QueryACMERecordSets() = %d record sets, want %d", len(recordSets), len(want))
+	}
+	for i, got := range recordSets {
+		if got.Zone != want[i].Zone {
+			t.Errorf("record set %d zone = %+v, want %+v", i, got.Zone, want[i].Zone)
+		}
+	}
+	if got := *recordSets[0].RecordSet.Type; got != "Microsoft.Network/dnszones/A" {
+		t.Errorf("record set type = %q, want the casing of the DNS API", got)
+	}
+	if got := *recordSets[1].RecordSet.Properties.Metadata["acme"]; got != "true" {
+		t.Errorf("record set metadata acme = %q", got)
+	}
+
+	// The second page is requested with the skip token of the first
+	if len(graph.requests) != 2 || graph.requests[1].Options.SkipToken == nil || *graph.requests[1].Options.SkipToken != "page-1" {
+		t.Errorf("requests = %+v, want the second page requested with the skip token", graph.requests)
+	}
+	var subscriptions []string
+	for _, subscription := range graph.requests[0].Subscriptions {
+		subscriptions = append(subscriptions, *subscription)
+	}
+	if !slices.Equal(subscriptions, scope.Subscriptions) || graph.requests[0].ManagementGroups != nil {
+		t.Errorf("request scope = %v, %v, want the subscriptions only", subscriptions, graph.requests[0].ManagementGroups)
+	}
+}
+
+func TestQueryDNSZones(t *testing.T) {
+	_, client := newFakeResourceGraph(t, []map[string]any{{
+		"id":         "/subscriptions/sub-1/resourceGroups/dns-rg/providers/Microsoft.Network/dnszones/Example.com",
+		"name":       "Example.com",
+		"properties": map[string]any{"nameServers": []string{"ns1-01.azure-dns.com.", "ns2-01.azure-dns.net."}},
+	}})
+
+	zones, err := QueryDNSZones(context.Background(), client, GraphScope{ManagementGroups: []string{"platform"}})
+	if err != nil {
+		t.Fatalf("QueryDNSZones() error = %v", err)
+	}
+	if len(zones) != 1 || zones[0].Zone != (DNSZone{Name: "example.com", ResourceGroup: "dns-rg", Subscription: "sub-1"}) || len(zones[0].NameServers) != 2 {
+		t.Errorf("QueryDNSZones() = %+v", zones)
+	}
+}
+
+func TestGraphScopeString(t *testing.T) {
+	scope := GraphScope{Subscriptions: []string{"sub-1", "sub-2"}, ManagementGroups: []string{"platform"}}
+	if got := scope.String(); got != "subscriptions=sub-1,sub-2, management_groups=platform" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package certificate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+)
+
+// testACMEServer is a minimal ACME server answering the directory and nonce requests of lego.
+// Other endpoints are served by the handlers registered for their path prefix.
+type testACMEServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+}
+
+func newTestACMEServer(t *testing.T) *testACMEServer {
+	s := &testACMEServer{handlers: make(map[string]http.HandlerFunc)}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle registers the handler of an endpoint. Endpoints named in the directory must be registered
+// before the first client is created.
+func (s *testACMEServer) handle(prefix string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[prefix] = handler
+}
+
+func (s *testACMEServer) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", "nonce")
+
+	switch r.URL.Path {
+	case "/directory":
+		directory := map[string]string{
+			"newNonce":   s.URL + "/new-nonce",
+			"newAccount": s.URL + "/new-account",
+			"newOrder":   s.URL + "/new-order",
+			"revokeCert": s.URL + "/revoke-cert",
+			"keyChange":  s.URL + "/key-change",
+		}
+		s.mu.Lock()
+		if _, ok := s.handlers["/renewal-info/"]; ok {
+			directory["renewalInfo"] = s.URL + "/renewal-info"
+		}
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(directory)
+		return
+	case "/new-nonce":
+		return
+	}
+
+	s.mu.Lock()
+	var handler http.HandlerFunc
+	for prefix, h := range s.handlers {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			handler = h
+		}
+	}
+	s.mu.Unlock()
+
+	if handler == nil {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"type":"urn:ietf:params:acme:error:malformed","detail":"no handler"}`))
+		return
+	}
+	handler(w, r)
+}
+
+// client returns a lego client of a registered account on the server
+func (s *testACMEServer) client(t *testing.T) *lego.Client {
+	t.Helper()
+
+	key, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &types.AcmeUser{Email: "admin@example.com", Registration: &registration.Resource{URI: s.URL + "/account/1"}}
+	user.SetPrivateKey(key)
+
+	config := lego.NewConfig(user)
+	config.CADirURL = s.URL + "/directory"
+	config.HTTPClient = s.Client()
+
+	client, err := lego.NewClient(config)
+	if err != nil {
+		t.Fatalf("failed to create ACME client: %v", err)
+	}
+	return client
+}
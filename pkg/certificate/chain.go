@@ -0,0 +1,52 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// parseCertificateChain parses every certificate in the PEM bundle and issuer data returned by lego.
+// The first certificate of the result is the leaf, the rest are the distinct issuer certificates in order.
+func parseCertificateChain(bundle []byte, issuer []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+
+	for _, data := range [][]byte{bundle, issuer} {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate: %v", err)
+			}
+
+			if !containsCertificate(chain, cert) {
+				chain = append(chain, cert)
+			}
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+
+	return chain, nil
+}
+
+// containsCertificate reports whether the certificate is already part of the chain
+func containsCertificate(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if bytes.Equal(c.Raw, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testChain is a root, an intermediate and a leaf certificate issued below them
+type testChain struct {
+	root, intermediate, leaf *x509.Certificate
+	intermediateKey, leafKey *ecdsa.PrivateKey
+}
+
+func newTestChain(t *testing.T, dnsNames ...string) *testChain {
+	t.Helper()
+
+	issue := func(serial int64, subject string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(90 * 24 * time.Hour),
+			IsCA:                  ca,
+			BasicConstraintsValid: true,
+		}
+		if !ca {
+			template.DNSNames = dnsNames
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	root, rootKey := issue(1, "Test Root", nil, nil, true)
+	intermediate, intermediateKey := issue(2, "Test Intermediate", root, rootKey, true)
+	leaf, leafKey := issue(3, dnsNames[0], intermediate, intermediateKey, false)
+	return &testChain{root: root, intermediate: intermediate, leaf: leaf, intermediateKey: intermediateKey, leafKey: leafKey}
+}
+
+// signCSR issues a leaf certificate for a certificate signing request below the intermediate
+func (c *testChain) signCSR(t *testing.T, csr *x509.CertificateRequest) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.intermediate, csr.PublicKey, c.intermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func encodePEM(blockType string, certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: blockType, Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+func TestParseCertificateChain(t *testing.T) {
+	chain := newTestChain(t, "www.example.com")
+
+	// lego returns the leaf followed by the issuers, and the issuers again as issuer certificate
+	bundle := encodePEM("CERTIFICATE", chain.leaf, chain.intermediate)
+	issuer := append(encodePEM("CERTIFICATE", chain.intermediate), encodePEM("X509 CRL", chain.root)...)
+
+	got, err := parseCertificateChain(bundle, issuer)
+	if err != nil {
+		t.Fatalf("parseCertificateChain() error = %v", err)
+	}
+	if len(got) != 2 || !got[0].Equal(chain.leaf) || !got[1].Equal(chain.intermediate) {
+		t.Fatalf("parseCertificateChain() returned %d certificates, want the leaf and the intermediate", len(got))
+	}
+
+	pfx, err := pkcs12.Modern.Encode(chain.leafKey, got[0], got[1:], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leaf, caCerts, err := pkcs12.DecodeChain(pfx, "")
+	if err != nil {
+		t.Fatalf("failed to decode PFX: %v", err)
+	}
+	if !leaf.Equal(chain.leaf) || len(caCerts) != 1 || !caCerts[0].Equal(chain.intermediate) {
+		t.Errorf("PFX does not hold the leaf and its intermediate")
+	}
+}
+
+func TestParseCertificateChainErrors(t *testing.T) {
+	if _, err := parseCertificateChain(nil, []byte("no PEM data")); err == nil {
+		t.Error("parseCertificateChain() without certificates did not fail")
+	}
+
+	invalid := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")})
+	if _, err := parseCertificateChain(invalid, nil); err == nil {
+		t.Error("parseCertificateChain() of an invalid certificate did not fail")
+	}
+}
@@ -0,0 +1,133 @@
+package certificate
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// Challenge types selectable per certificate
+const (
+	ChallengeDNS01  = "dns-01"
+	ChallengeHTTP01 = "http-01"
+)
+
+// ParseChallengeType validates a challenge type name
+func ParseChallengeType(name string) (string, error) {
+	switch value := strings.ToLower(strings.TrimSpace(name)); value {
+	case ChallengeDNS01, ChallengeHTTP01:
+		return value, nil
+	case "":
+		return ChallengeDNS01, nil
+	default:
+		return "", fmt.Errorf("unsupported challenge type %q (supported: %s, %s)", name, ChallengeDNS01, ChallengeHTTP01)
+	}
+}
+
+// ResolveChallengeType returns the challenge type for a target, honouring the acme-challenge metadata override.
+// lego solves all authorizations of an order with one challenge type, so all records of a group that set the
+// override must agree on its value. Wildcard names can only be validated with DNS-01.
+func ResolveChallengeType(target *zones.Target, defaultType string) (string, error) {
+	override := ""
+	for _, record := range target.Records {
+		value := strings.ToLower(strings.TrimSpace(record.Metadata[zones.MetadataChallenge]))
+		if value == "" {
+			continue
+		}
+		if override != "" && override != value {
+			return "", fmt.Errorf("conflicting challenge types %q and %q in group %s", override, value, target.Group)
+		}
+		override = value
+	}
+
+	challengeType := defaultType
+	if override != "" {
+		var err error
+		if challengeType, err = ParseChallengeType(override); err != nil {
+			return "", err
+		}
+	}
+
+	if challengeType == ChallengeHTTP01 {
+		for _, record := range target.Records {
+			if record.Wildcard {
+				return "", fmt.Errorf("wildcard name *.%s requires the %s challenge", record.FQDN, ChallengeDNS01)
+			}
+		}
+	}
+	return challengeType, nil
+}
+
+// ChallengeSolvers switches the challenge provider of an ACME client between orders, so that each
+// certificate is validated with its own challenge type
+type ChallengeSolvers struct {
+	acmeClient   *lego.Client
+	defaultType  string
+	dnsProvider  challenge.Provider
+	dnsOptions   []dns01.ChallengeOption
+	httpProvider challenge.Provider
+	current      string
+}
+
+// NewChallengeSolvers creates the challenge solvers of an ACME client. httpProvider may be nil
+// when HTTP-01 challenges are not used.
+func NewChallengeSolvers(acmeClient *lego.Client, defaultType string, dnsProvider challenge.Provider, dnsOptions []dns01.ChallengeOption, httpProvider challenge.Provider) *ChallengeSolvers {
+	return &ChallengeSolvers{
+		acmeClient:   acmeClient,
+		defaultType:  defaultType,
+		dnsProvider:  dnsProvider,
+		dnsOptions:   dnsOptions,
+		httpProvider: httpProvider,
+	}
+}
+
+// SetClient moves the solvers to another ACME client, e.g. after its account was recovered, and
+// selects the challenge type in use on the new client
+func (s *ChallengeSolvers) SetClient(acmeClient *lego.Client) error {
+	current := s.current
+	s.acmeClient, s.current = acmeClient, ""
+	if current == "" {
+		return nil
+	}
+	return s.Use(current)
+}
+
+// DefaultType returns the challenge type of certificates without an override
+func (s *ChallengeSolvers) DefaultType() string {
+	return s.defaultType
+}
+
+// Use makes the ACME client solve the following orders with the given challenge type only
+func (s *ChallengeSolvers) Use(challengeType string) error {
+	if challengeType == s.current {
+		return nil
+	}
+
+	switch challengeType {
+	case ChallengeDNS01:
+		s.acmeClient.Challenge.Remove(challenge.HTTP01)
+		if err := s.acmeClient.Challenge.SetDNS01Provider(s.dnsProvider, s.dnsOptions...); err != nil {
+			return fmt.Errorf("failed to set DNS challenge provider: %v", err)
+		}
+	case ChallengeHTTP01:
+		if s.httpProvider == nil {
+			return fmt.Errorf("no HTTP-01 responder configured")
+		}
+		s.acmeClient.Challenge.Remove(challenge.DNS01)
+		if err := s.acmeClient.Challenge.SetHTTP01Provider(s.httpProvider); err != nil {
+			return fmt.Errorf("failed to set HTTP challenge provider: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported challenge type %q", challengeType)
+	}
+
+	log.Printf("Challenge type selected: %s", challengeType)
+	s.current = challengeType
+	return nil
+}
@@ -0,0 +1,77 @@
+package certificate
+
+import (
+	"testing"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// nopProvider is a challenge provider that does nothing
+type nopProvider struct{}
+
+func (nopProvider) Present(domain, token, keyAuth string) error { return nil }
+func (nopProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func TestParseChallengeType(t *testing.T) {
+	for name, want := range map[string]string{"": ChallengeDNS01, "dns-01": ChallengeDNS01, " HTTP-01 ": ChallengeHTTP01} {
+		if got, err := ParseChallengeType(name); err != nil || got != want {
+			t.Errorf("ParseChallengeType(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseChallengeType("tls-alpn-01"); err == nil {
+		t.Error("ParseChallengeType() accepted tls-alpn-01")
+	}
+}
+
+func TestResolveChallengeType(t *testing.T) {
+	record := func(challenge string, wildcard bool) *zones.Record {
+		return &zones.Record{FQDN: "example.com", Wildcard: wildcard, Metadata: map[string]string{zones.MetadataChallenge: challenge}}
+	}
+
+	tests := []struct {
+		name        string
+		records     []*zones.Record
+		defaultType string
+		want        string
+		wantErr     bool
+	}{
+		{name: "default", records: []*zones.Record{record("", false)}, defaultType: ChallengeHTTP01, want: ChallengeHTTP01},
+		{name: "override", records: []*zones.Record{record("http-01", false)}, defaultType: ChallengeDNS01, want: ChallengeHTTP01},
+		{name: "override of one group member", records: []*zones.Record{record("", false), record("HTTP-01", false)}, defaultType: ChallengeDNS01, want: ChallengeHTTP01},
+		{name: "conflicting overrides", records: []*zones.Record{record("http-01", false), record("dns-01", false)}, defaultType: ChallengeDNS01, wantErr: true},
+		{name: "unsupported override", records: []*zones.Record{record("tls-alpn-01", false)}, defaultType: ChallengeDNS01, wantErr: true},
+		{name: "wildcard with HTTP-01", records: []*zones.Record{record("", false), record("", true)}, defaultType: ChallengeHTTP01, wantErr: true},
+		{name: "wildcard with DNS-01", records: []*zones.Record{record("dns-01", true)}, defaultType: ChallengeHTTP01, want: ChallengeDNS01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveChallengeType(&zones.Target{Group: "web", Records: tt.records}, tt.defaultType)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ResolveChallengeType() = %q, %v, want %q (error %t)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChallengeSolversUse(t *testing.T) {
+	client := newTestACMEServer(t).client(t)
+
+	solvers := NewChallengeSolvers(client, ChallengeDNS01, nopProvider{}, nil, nil)
+	if err := solvers.Use(ChallengeDNS01); err != nil {
+		t.Fatalf("Use(%s) error = %v", ChallengeDNS01, err)
+	}
+	if err := solvers.Use(ChallengeHTTP01); err == nil {
+		t.Errorf("Use(%s) succeeded without an HTTP-01 responder", ChallengeHTTP01)
+	}
+
+	solvers = NewChallengeSolvers(client, ChallengeDNS01, nopProvider{}, nil, nopProvider{})
+	for _, challengeType := range []string{ChallengeHTTP01, ChallengeDNS01, ChallengeDNS01} {
+		if err := solvers.Use(challengeType); err != nil {
+			t.Errorf("Use(%s) error = %v", challengeType, err)
+		}
+	}
+	if err := solvers.Use("tls-alpn-01"); err == nil {
+		t.Error("Use() accepted an unsupported challenge type")
+	}
+}
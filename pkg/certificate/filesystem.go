@@ -0,0 +1,137 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+const (
+	dirPerm        = 0700
+	keyFilePerm    = 0600
+	publicFilePerm = 0644
+)
+
+// FilesystemStore writes certificates to a local directory, one sub-directory per certificate:
+//
+//	<root>/<certificate name>/cert.pem       leaf certificate
+//	<root>/<certificate name>/chain.pem      issuer certificates
+//	<root>/<certificate name>/fullchain.pem  leaf followed by the issuer certificates
+//	<root>/<certificate name>/privkey.pem    private key
+//	<root>/<certificate name>/cert.pfx       PKCS#12 archive (no password)
+type FilesystemStore struct {
+	rootPath string
+}
+
+// NewFilesystemStore creates a certificate store rooted at the given directory
+func NewFilesystemStore(rootPath string) *FilesystemStore {
+	return &FilesystemStore{rootPath: rootPath}
+}
+
+// Name identifies the store in log messages
+func (s *FilesystemStore) Name() string {
+	return StoreFilesystem
+}
+
+// Get reads the leaf certificate of the target from disk
+func (s *FilesystemStore) Get(ctx context.Context, target *zones.Target) (*StoredCertificate, error) {
+	return readStoredCertificate(filepath.Join(s.rootPath, CertificateName(target), "cert.pem"))
+}
+
+// Put writes the certificate files of the target. Without a private key (generated in Key Vault), the
+// key and PKCS#12 files of an earlier certificate are removed, as they no longer match the certificate.
+func (s *FilesystemStore) Put(ctx context.Context, target *zones.Target, cert *IssuedCertificate) error {
+	certPath := filepath.Join(s.rootPath, CertificateName(target))
+	if err := os.MkdirAll(certPath, dirPerm); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	leaf := encodeCertificates(cert.Chain[:1])
+	chain := encodeCertificates(cert.Chain[1:])
+
+	files := []storeFile{
+		{"cert.pem", leaf, publicFilePerm},
+		{"chain.pem", chain, publicFilePerm},
+		{"fullchain.pem", bytes.Join([][]byte{leaf, chain}, nil), publicFilePerm},
+	}
+
+	if cert.PrivateKey == nil {
+		if err := removeStoreFiles(certPath, "privkey.pem", "cert.pfx"); err != nil {
+			return err
+		}
+	} else {
+		pfxData, err := cert.EncodePFX()
+		if err != nil {
+			return fmt.Errorf("PKCS12 encoding failed: %v", err)
+		}
+		files = append(files,
+			storeFile{"privkey.pem", certcrypto.PEMEncode(cert.PrivateKey), keyFilePerm},
+			storeFile{"cert.pfx", pfxData, keyFilePerm},
+		)
+	}
+
+	return writeStoreFiles(certPath, files)
+}
+
+// storeFile is a single file written by a file-based store
+type storeFile struct {
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+// writeStoreFiles writes a set of files into a directory
+func writeStoreFiles(dir string, files []storeFile) error {
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file.name), file.data, file.perm); err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.name, err)
+		}
+	}
+
+	return nil
+}
+
+// removeStoreFiles removes files of a directory that are not written for a certificate, ignoring missing files
+func removeStoreFiles(dir string, names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// encodeCertificates PEM-encodes a list of certificates
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+// readStoredCertificate reads the first certificate of a PEM file, returning nil when the file does not exist
+func readStoredCertificate(path string) (*StoredCertificate, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	chain, err := parseCertificateChain(data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredCertificate{Leaf: chain[0], Expires: chain[0].NotAfter}, nil
+}
@@ -0,0 +1,139 @@
+package certificate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+func TestFilesystemStore(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewFilesystemStore(root)
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+
+	if stored, err := store.Get(ctx, target); err != nil || stored != nil {
+		t.Fatalf("Get() before Put() = %v, %v, want nil", stored, err)
+	}
+
+	issued := issuedCertificate(t, "www.example.com")
+	if err := store.Put(ctx, target, issued); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	dir := filepath.Join(root, "cert-www-example-com")
+	for name, perm := range map[string]os.FileMode{
+		"cert.pem":      0644,
+		"chain.pem":     0644,
+		"fullchain.pem": 0644,
+		"privkey.pem":   0600,
+		"cert.pfx":      0600,
+	} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s not written: %v", name, err)
+			continue
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("%s mode = %v, want %v", name, info.Mode().Perm(), perm)
+		}
+	}
+
+	fullchain, err := os.ReadFile(filepath.Join(dir, "fullchain.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain, err := parseCertificateChain(fullchain, nil); err != nil || len(chain) != 2 {
+		t.Errorf("fullchain.pem holds %d certificates (%v), want the leaf and the intermediate", len(chain), err)
+	}
+
+	pfx, err := os.ReadFile(filepath.Join(dir, "cert.pfx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, leaf, _, err := pkcs12.DecodeChain(pfx, ""); err != nil || !leaf.Equal(issued.Leaf()) {
+		t.Errorf("cert.pfx does not hold the leaf: %v", err)
+	}
+
+	stored, err := store.Get(ctx, target)
+	if err != nil || stored == nil || !stored.Leaf.Equal(issued.Leaf()) {
+		t.Fatalf("Get() after Put() = %v, %v, want the stored leaf", stored, err)
+	}
+}
+
+func TestLegoStore(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewLegoStore(root)
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "example.com", Wildcard: true}}}
+
+	issued := issuedCertificate(t, "*.example.com", "example.com")
+	if err := store.Put(ctx, target, issued); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Without a certificate resource the files are named after the first domain of the target
+	for _, name := range []string{"example.com.crt", "example.com.issuer.crt", "example.com.json", "example.com.key", "example.com.pfx"} {
+		if _, err := os.Stat(filepath.Join(root, "certificates", name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+
+	stored, err := store.Get(ctx, target)
+	if err != nil || stored == nil || !stored.Leaf.Equal(issued.Leaf()) {
+		t.Fatalf("Get() = %v, %v, want the stored leaf", stored, err)
+	}
+}
+
+func TestStoresRemoveKeyFilesOfCertificatesWithoutKey(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+
+	tests := []struct {
+		store    Store
+		keyFiles []string
+	}{
+		{store: NewFilesystemStore(root), keyFiles: []string{"cert-www-example-com/privkey.pem", "cert-www-example-com/cert.pfx"}},
+		{store: NewLegoStore(root), keyFiles: []string{"certificates/www.example.com.key", "certificates/www.example.com.pfx"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.store.Name(), func(t *testing.T) {
+			if err := tt.store.Put(ctx, target, issuedCertificate(t, "www.example.com")); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			// The renewed certificate has its key generated in Key Vault, the earlier key does not match it
+			renewed := issuedCertificate(t, "www.example.com")
+			renewed.PrivateKey = nil
+			if err := tt.store.Put(ctx, target, renewed); err != nil {
+				t.Fatalf("Put() without private key error = %v", err)
+			}
+			for _, name := range tt.keyFiles {
+				if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+					t.Errorf("%s of the earlier certificate kept: %v", name, err)
+				}
+			}
+
+			stored, err := tt.store.Get(ctx, target)
+			if err != nil || stored == nil || !stored.Leaf.Equal(renewed.Leaf()) {
+				t.Errorf("Get() = %v, %v, want the renewed leaf", stored, err)
+			}
+		})
+	}
+}
+
+func TestSanitizedDomain(t *testing.T) {
+	if got := sanitizedDomain("*.example.com"); got != "_.example.com" {
+		t.Errorf("sanitizedDomain(*.example.com) = %q, want _.example.com", got)
+	}
+	if got := sanitizedDomain("example.com:8443"); got != "example.com-8443" {
+		t.Errorf("sanitizedDomain(example.com:8443) = %q, want example.com-8443", got)
+	}
+}
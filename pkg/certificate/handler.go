@@ -2,104 +2,340 @@ package certificate
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/pem"
+	"crypto"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/go-acme/lego/v4/acme/api"
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/go-acme/lego/v4/lego"
-	"software.sslmate.com/src/go-pkcs12"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
 )
 
+// PreflightCheck verifies a certificate target before a certificate is ordered for it
+type PreflightCheck func(ctx context.Context, target *zones.Target) error
+
+// AccountRecovery repairs the ACME account after an order failed with an account error. It returns the
+// client of the recovered account, or nil when the account turns out to be valid.
+type AccountRecovery func(ctx context.Context) (*lego.Client, error)
+
 // Handler handles certificate operations
 type Handler struct {
-	acmeClient   *lego.Client
-	kvCertClient *azcertificates.Client
+	acmeClient     *lego.Client
+	stores         []Store
+	csrIssuer      CSRIssuer
+	keyType        certcrypto.KeyType
+	preferredChain string
+	forced         map[string]bool
+	preflight      []PreflightCheck
+	solvers        *ChallengeSolvers
+
+	accountRecovery  AccountRecovery
+	accountRecovered bool
+	accountErr       error
+}
+
+// NewHandler creates a new certificate handler writing to one or more certificate stores.
+// When one of the stores is a CSRIssuer, private keys are generated by that store instead of locally.
+// preferredChain selects an alternate chain offered by the CA by its root common name (empty for the default chain).
+func NewHandler(acmeClient *lego.Client, stores []Store, keyType certcrypto.KeyType, preferredChain string) *Handler {
+	h := &Handler{
+		acmeClient:     acmeClient,
+		stores:         stores,
+		keyType:        keyType,
+		preferredChain: preferredChain,
+	}
+
+	for _, store := range stores {
+		if issuer, ok := store.(CSRIssuer); ok {
+			h.csrIssuer = issuer
+			break
+		}
+	}
+
+	return h
 }
 
-// NewHandler creates a new certificate handler
-func NewHandler(acmeClient *lego.Client, kvCertClient *azcertificates.Client) *Handler {
-	return &Handler{
-		acmeClient:   acmeClient,
-		kvCertClient: kvCertClient,
+// ForceRenewal makes the handler reissue the certificates with the given names regardless of their expiration date
+func (h *Handler) ForceRenewal(certNames ...string) {
+	if h.forced == nil {
+		h.forced = make(map[string]bool)
 	}
+	for _, certName := range certNames {
+		h.forced[certName] = true
+	}
+}
+
+// SetChallengeSolvers makes the handler select the challenge type of every certificate before ordering it.
+// Without solvers, the challenge providers configured on the ACME client are used for all certificates.
+func (h *Handler) SetChallengeSolvers(solvers *ChallengeSolvers) {
+	h.solvers = solvers
+}
+
+// SetAccountRecovery makes the handler recover the ACME account when an order fails because the CA no
+// longer accepts the account, and retry the order with the recovered account. The account is recovered
+// at most once; when recovery fails, the remaining orders of the handler are skipped.
+func (h *Handler) SetAccountRecovery(recovery AccountRecovery) {
+	h.accountRecovery = recovery
 }
 
-// ProcessFQDN handles certificate provisioning for a given FQDN
-func (h *Handler) ProcessFQDN(ctx context.Context, fqdn string, expireThreshold int) {
-	certName := "cert-" + strings.ReplaceAll(fqdn, ".", "-")
-	log.Printf("Certificate check started: %s", fqdn)
+// AddPreflightCheck adds a check run before a certificate is ordered. Targets failing a check are
+// skipped, so that misconfigurations are reported without placing orders that cannot be validated.
+func (h *Handler) AddPreflightCheck(check PreflightCheck) {
+	h.preflight = append(h.preflight, check)
+}
 
-	resp, err := h.kvCertClient.GetCertificate(ctx, certName, "", nil)
+// currentCertificate returns the stored certificate expiring first across all stores.
+// It returns nil when any of the stores does not hold a certificate for the target, so that
+// the certificate is issued again and written to every store.
+func (h *Handler) currentCertificate(ctx context.Context, target *zones.Target) *StoredCertificate {
+	var current *StoredCertificate
+	for _, store := range h.stores {
+		stored, err := store.Get(ctx, target)
+		if err != nil {
+			log.Printf("Certificate lookup failed: name=%s, store=%s, error=%v", target.Name(), store.Name(), err)
+			return nil
+		}
+		if stored == nil {
+			log.Printf("Certificate not found: name=%s, store=%s", target.Name(), store.Name())
+			return nil
+		}
+		if current == nil || stored.Expires.Before(current.Expires) {
+			current = stored
+		}
+	}
+	return current
+}
+
+// ProcessTarget handles certificate provisioning for a single or grouped certificate target
+func (h *Handler) ProcessTarget(ctx context.Context, target *zones.Target, expireThreshold int) {
+	name := target.Name()
+	domains := target.Domains()
+	log.Printf("Certificate check started: %s (domains=%s)", name, strings.Join(domains, ","))
+
+	// acme-threshold metadata overrides the threshold of the configuration
+	expireThreshold = target.Threshold(expireThreshold)
+
+	keyType, err := ResolveKeyType(target, h.keyType)
+	if err != nil {
+		log.Printf("Invalid key type metadata: name=%s, error=%v", name, err)
+		return
+	}
+
+	stored := h.currentCertificate(ctx, target)
 	daysLeft := 0
-	if err == nil && resp.Attributes != nil && resp.Attributes.Expires != nil {
-		expiry := *resp.Attributes.Expires
-		daysLeft = int(time.Until(expiry).Hours() / 24)
-		log.Printf("Certificate exists: fqdn=%s, expires=%s, days_left=%d", fqdn, expiry.Format(time.RFC3339), daysLeft)
-	} else {
-		log.Printf("Certificate not found: fqdn=%s", fqdn)
+	renewalDue := true
+	replacesCertID := ""
+	keyTypeMatches := false
+	sansMatch := false
+	if stored != nil {
+		der := stored.Leaf.Raw
+		daysLeft = int(time.Until(stored.Expires).Hours() / 24)
+		log.Printf("Certificate exists: name=%s, expires=%s, days_left=%d", name, stored.Expires.Format(time.RFC3339), daysLeft)
+		renewalDue = daysLeft <= expireThreshold
+
+		// Ask the CA for its suggested renewal window (ARI), keeping the threshold as a safety net
+		info, err := FetchRenewalInfo(h.acmeClient, der)
+		switch {
+		case errors.Is(err, api.ErrNoARI):
+			log.Printf("Renewal info not supported by CA, using threshold: name=%s", name)
+		case err != nil:
+			log.Printf("Renewal info lookup failed, using threshold: name=%s, error=%v", name, err)
+		default:
+			replacesCertID = info.CertID
+			now := time.Now()
+			log.Printf("Renewal window: name=%s, start=%s, end=%s", name, info.WindowStart.Format(time.RFC3339), info.WindowEnd.Format(time.RFC3339))
+			if info.WindowPassed(now) {
+				log.Printf("Renewal window has passed, CA requests immediate replacement: name=%s, explanation=%s", name, info.ExplanationURL)
+				renewalDue = true
+			} else if info.ShouldRenew(now) {
+				log.Printf("Renewal window reached: name=%s", name)
+				renewalDue = true
+			}
+		}
+
+		currentKeyType, err := CertificateKeyType(der)
+		if err != nil {
+			log.Printf("Certificate key type unknown: name=%s, error=%v", name, err)
+		} else if currentKeyType != keyType {
+			log.Printf("Certificate key type mismatch: name=%s, current=%s, desired=%s", name, KeyTypeName(currentKeyType), KeyTypeName(keyType))
+		} else {
+			keyTypeMatches = true
+		}
+
+		missing, extra, err := DiffSANs(der, domains)
+		if err != nil {
+			log.Printf("Certificate SAN check failed: name=%s, error=%v", name, err)
+		} else if len(missing) > 0 || len(extra) > 0 {
+			log.Printf("Certificate SAN mismatch: name=%s, missing=%s, extra=%s", name, strings.Join(missing, ","), strings.Join(extra, ","))
+		} else {
+			sansMatch = true
+		}
+
+		// Only announce the replaced certificate when the identifiers are unchanged
+		if !sansMatch {
+			replacesCertID = ""
+		}
+
+		// A forced renewal usually follows a revocation, which the CA may refuse to see replaced
+		if h.forced[CertificateName(target)] {
+			log.Printf("Certificate renewal forced: name=%s", name)
+			renewalDue = true
+			replacesCertID = ""
+		}
+	}
+
+	if !renewalDue && keyTypeMatches && sansMatch {
+		log.Printf("Certificate renewal skipped: name=%s, days_left=%d, threshold=%d", name, daysLeft, expireThreshold)
+		return
+	}
+
+	for _, check := range h.preflight {
+		if err := check(ctx, target); err != nil {
+			log.Printf("Certificate preflight check failed: name=%s, error=%v", name, err)
+			return
+		}
+	}
+
+	if h.solvers != nil {
+		challengeType, err := ResolveChallengeType(target, h.solvers.DefaultType())
+		if err != nil {
+			log.Printf("Invalid challenge type: name=%s, error=%v", name, err)
+			return
+		}
+		if err := h.solvers.Use(challengeType); err != nil {
+			log.Printf("Challenge setup failed: name=%s, error=%v", name, err)
+			return
+		}
+	}
+
+	if h.accountErr != nil {
+		log.Printf("Certificate order skipped, the ACME account could not be recovered: name=%s, error=%v", name, h.accountErr)
+		return
 	}
 
-	if daysLeft > expireThreshold {
-		log.Printf("Certificate renewal skipped: fqdn=%s, days_left=%d, threshold=%d", fqdn, daysLeft, expireThreshold)
+	certPrivateKey, legoCert, err := h.order(ctx, target, keyType, replacesCertID)
+	if err != nil && h.recoverAccount(ctx, name, err) {
+		certPrivateKey, legoCert, err = h.order(ctx, target, keyType, replacesCertID)
+	}
+	if err != nil {
+		log.Printf("Certificate obtain failed: name=%s, error=%v", name, err)
 		return
 	}
 
-	// Generate a new private key for this certificate request
-	certPrivateKey, err := certcrypto.GeneratePrivateKey(certcrypto.RSA2048)
+	// Parse the leaf and the issuer chain from the bundle
+	chain, err := parseCertificateChain(legoCert.Certificate, legoCert.IssuerCertificate)
 	if err != nil {
-		log.Printf("Private key generation failed: fqdn=%s, error=%v", fqdn, err)
+		log.Printf("Certificate parse failed: name=%s, error=%v", name, err)
 		return
 	}
 
-	legoReq := certificate.ObtainRequest{
-		Domains:    []string{fqdn},
-		Bundle:     true,
+	cert := chain[0]
+	log.Printf("Certificate obtained: name=%s, expires=%s, issuer=%s, chain_length=%d", name, cert.NotAfter.Format(time.RFC3339), cert.Issuer.CommonName, len(chain))
+
+	issued := &IssuedCertificate{
+		Resource:   legoCert,
 		PrivateKey: certPrivateKey,
+		KeyType:    keyType,
+		Chain:      chain,
+		IssuedAt:   time.Now(),
 	}
 
-	legoCert, err := h.acmeClient.Certificate.Obtain(legoReq)
+	for _, store := range h.stores {
+		if err := store.Put(ctx, target, issued); err != nil {
+			log.Printf("Certificate store failed: name=%s, store=%s, error=%v", name, store.Name(), err)
+			continue
+		}
+		log.Printf("Certificate stored: name=%s, store=%s, cert_name=%s, key_type=%s", name, store.Name(), CertificateName(target), KeyTypeName(keyType))
+	}
+}
+
+// order obtains a certificate for the target, with a key generated by the CSR issuer store or locally
+func (h *Handler) order(ctx context.Context, target *zones.Target, keyType certcrypto.KeyType, replacesCertID string) (crypto.PrivateKey, *certificate.Resource, error) {
+	if h.csrIssuer != nil {
+		legoCert, err := h.obtainForCSR(ctx, target, keyType, replacesCertID)
+		return nil, legoCert, err
+	}
+	return h.obtain(target.Domains(), keyType, replacesCertID)
+}
+
+// recoverAccount recovers the ACME account after an order failed with an account error, and reports
+// whether the order is to be retried with the recovered account
+func (h *Handler) recoverAccount(ctx context.Context, name string, orderErr error) bool {
+	if h.accountRecovery == nil || h.accountRecovered || !acme.IsAccountProblem(orderErr) {
+		return false
+	}
+
+	client, err := h.accountRecovery(ctx)
 	if err != nil {
-		log.Printf("Certificate obtain failed: fqdn=%s, error=%v", fqdn, err)
-		return
+		h.accountRecovered, h.accountErr = true, err
+		log.Printf("ACME account recovery failed: name=%s, error=%v", name, err)
+		return false
+	}
+	if client == nil {
+		return false
 	}
+	h.accountRecovered = true
 
-	// Parse the certificate from the bundle to get expiration info
-	block, _ := pem.Decode(legoCert.Certificate)
-	if block == nil {
-		log.Printf("Certificate PEM parse failed: fqdn=%s", fqdn)
-		return
+	h.acmeClient = client
+	if h.solvers != nil {
+		if err := h.solvers.SetClient(client); err != nil {
+			log.Printf("Challenge setup failed: name=%s, error=%v", name, err)
+			return false
+		}
 	}
+	log.Printf("Certificate order retried with the recovered ACME account: name=%s", name)
+	return true
+}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+// obtain generates a new private key locally and obtains a certificate for it
+func (h *Handler) obtain(domains []string, keyType certcrypto.KeyType, replacesCertID string) (crypto.PrivateKey, *certificate.Resource, error) {
+	privateKey, err := certcrypto.GeneratePrivateKey(keyType)
 	if err != nil {
-		log.Printf("Certificate parse failed: fqdn=%s, error=%v", fqdn, err)
-		return
+		return nil, nil, fmt.Errorf("private key generation failed: %v", err)
 	}
 
-	log.Printf("Certificate obtained: fqdn=%s, expires=%s", fqdn, cert.NotAfter.Format(time.RFC3339))
+	legoCert, err := h.acmeClient.Certificate.Obtain(certificate.ObtainRequest{
+		Domains:        domains,
+		Bundle:         true,
+		PrivateKey:     privateKey,
+		PreferredChain: h.preferredChain,
+		ReplacesCertID: replacesCertID,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Use modern PKCS12 encoding with the original private key (no PEM decoding needed)
-	pfxData, err := pkcs12.Modern.Encode(certPrivateKey, cert, nil, "")
+	return privateKey, legoCert, nil
+}
+
+// obtainForCSR lets the CSR issuer store generate the private key and obtains a certificate for its CSR.
+// The pending request is cancelled when the certificate cannot be obtained.
+func (h *Handler) obtainForCSR(ctx context.Context, target *zones.Target, keyType certcrypto.KeyType, replacesCertID string) (*certificate.Resource, error) {
+	csr, err := h.csrIssuer.CreateCSR(ctx, target, keyType)
 	if err != nil {
-		log.Printf("PKCS12 encoding failed: fqdn=%s, error=%v", fqdn, err)
-		return
+		return nil, err
 	}
+	log.Printf("Certificate signing request created: name=%s, store=%s", target.Name(), h.csrIssuer.Name())
 
-	// Azure Key Vault expects base64-encoded certificate data
-	base64Cert := base64.StdEncoding.EncodeToString(pfxData)
-	_, err = h.kvCertClient.ImportCertificate(ctx, certName, azcertificates.ImportCertificateParameters{
-		Base64EncodedCertificate: &base64Cert,
-	}, nil)
+	legoCert, err := h.acmeClient.Certificate.ObtainForCSR(certificate.ObtainForCSRRequest{
+		CSR:            csr,
+		Bundle:         true,
+		PreferredChain: h.preferredChain,
+		ReplacesCertID: replacesCertID,
+	})
 	if err != nil {
-		log.Printf("Certificate import failed: fqdn=%s, cert_name=%s, error=%v", fqdn, certName, err)
-		return
+		if cancelErr := h.csrIssuer.CancelCSR(ctx, target); cancelErr != nil {
+			log.Printf("Pending certificate cancel failed: name=%s, error=%v", target.Name(), cancelErr)
+		}
+		return nil, err
 	}
 
-	log.Printf("Certificate imported: fqdn=%s, cert_name=%s", fqdn, certName)
+	return legoCert, nil
 }
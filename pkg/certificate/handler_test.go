@@ -0,0 +1,49 @@
+package certificate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	legoACME "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+func TestHandlerRecoverAccount(t *testing.T) {
+	server := newTestACMEServer(t)
+	unauthorized := fmt.Errorf("order failed: %w", &legoACME.ProblemDetails{Type: "urn:ietf:params:acme:error:unauthorized", HTTPStatus: 403})
+
+	recovered := server.client(t)
+	calls := 0
+	h := &Handler{}
+	h.SetAccountRecovery(func(context.Context) (*lego.Client, error) {
+		calls++
+		return recovered, nil
+	})
+
+	if h.recoverAccount(context.Background(), "www.example.com", errors.New("connection reset")) {
+		t.Error("recoverAccount() retried an order that failed without an account error")
+	}
+	if !h.recoverAccount(context.Background(), "www.example.com", unauthorized) || h.acmeClient != recovered {
+		t.Fatal("recoverAccount() did not retry with the recovered client")
+	}
+	// The account is recovered once, later account errors are not caused by the old registration
+	if h.recoverAccount(context.Background(), "api.example.com", unauthorized) || calls != 1 {
+		t.Errorf("recoverAccount() recovered %d times, want once", calls)
+	}
+
+	// When the account turns out to be valid, the order error stands and recovery may be tried again
+	h = &Handler{}
+	h.SetAccountRecovery(func(context.Context) (*lego.Client, error) { return nil, nil })
+	if h.recoverAccount(context.Background(), "www.example.com", unauthorized) || h.accountRecovered {
+		t.Error("recoverAccount() of a valid account retried the order")
+	}
+
+	// A failed recovery skips the remaining orders of the handler
+	h = &Handler{}
+	h.SetAccountRecovery(func(context.Context) (*lego.Client, error) { return nil, errors.New("registration failed") })
+	if h.recoverAccount(context.Background(), "www.example.com", unauthorized) || h.accountErr == nil {
+		t.Errorf("recoverAccount() after a failed recovery: error = %v, want it kept", h.accountErr)
+	}
+}
@@ -0,0 +1,118 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/keytypes"
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// DefaultKeyType is the key type used when none is configured
+const DefaultKeyType = "rsa2048"
+
+// ParseKeyType converts a key type name (e.g. rsa2048, ec256) to a lego key type
+func ParseKeyType(name string) (certcrypto.KeyType, error) {
+	return keytypes.Parse(name)
+}
+
+// KeyTypeName returns the lego-compatible name of a key type
+func KeyTypeName(keyType certcrypto.KeyType) string {
+	return keytypes.Name(keyType)
+}
+
+// ResolveKeyType returns the key type for a target, honouring the acme-key-type metadata override
+// (see zones.Target.KeyType)
+func ResolveKeyType(target *zones.Target, defaultKeyType certcrypto.KeyType) (certcrypto.KeyType, error) {
+	name, err := target.KeyType()
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return defaultKeyType, nil
+	}
+	return ParseKeyType(name)
+}
+
+// CertificateKeyType determines the lego key type of a DER-encoded certificate
+func CertificateKeyType(der []byte) (certcrypto.KeyType, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch pub.N.BitLen() {
+		case 2048:
+			return certcrypto.RSA2048, nil
+		case 3072:
+			return certcrypto.RSA3072, nil
+		case 4096:
+			return certcrypto.RSA4096, nil
+		}
+		return "", fmt.Errorf("unsupported RSA key size: %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return certcrypto.EC256, nil
+		case elliptic.P384():
+			return certcrypto.EC384, nil
+		}
+		return "", fmt.Errorf("unsupported elliptic curve: %s", pub.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported public key type: %T", pub)
+	}
+}
+
+// keyProperties returns the Key Vault key properties matching a lego key type
+func keyProperties(keyType certcrypto.KeyType) *azcertificates.KeyProperties {
+	props := &azcertificates.KeyProperties{
+		Exportable: to.Ptr(true),
+		ReuseKey:   to.Ptr(false),
+	}
+
+	switch keyType {
+	case certcrypto.EC256:
+		props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeEC)
+		props.Curve = to.Ptr(azcertificates.JSONWebKeyCurveNameP256)
+	case certcrypto.EC384:
+		props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeEC)
+		props.Curve = to.Ptr(azcertificates.JSONWebKeyCurveNameP384)
+	case certcrypto.RSA3072:
+		props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeRSA)
+		props.KeySize = to.Ptr[int32](3072)
+	case certcrypto.RSA4096:
+		props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeRSA)
+		props.KeySize = to.Ptr[int32](4096)
+	default:
+		props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeRSA)
+		props.KeySize = to.Ptr[int32](2048)
+	}
+
+	return props
+}
+
+// generatedKeyProperties returns the Key Vault key properties for a key generated inside Key Vault
+func generatedKeyProperties(keyType certcrypto.KeyType, hsm, exportable bool) *azcertificates.KeyProperties {
+	props := keyProperties(keyType)
+	props.Exportable = to.Ptr(exportable)
+
+	if hsm {
+		switch *props.KeyType {
+		case azcertificates.JSONWebKeyTypeEC:
+			props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeECHSM)
+		case azcertificates.JSONWebKeyTypeRSA:
+			props.KeyType = to.Ptr(azcertificates.JSONWebKeyTypeRSAHSM)
+		}
+	}
+
+	return props
+}
@@ -0,0 +1,120 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// selfSignedDER returns a self-signed certificate for the key, naming the first DNS name as common name
+func selfSignedDER(t *testing.T, key crypto.Signer, notAfter time.Time, dnsNames ...string) []byte {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return der
+}
+
+func TestParseKeyType(t *testing.T) {
+	for name, want := range map[string]certcrypto.KeyType{
+		"rsa2048": certcrypto.RSA2048,
+		"RSA3072": certcrypto.RSA3072,
+		"rsa4096": certcrypto.RSA4096,
+		" ec256 ": certcrypto.EC256,
+		"Ec384":   certcrypto.EC384,
+	} {
+		got, err := ParseKeyType(name)
+		if err != nil || got != want {
+			t.Errorf("ParseKeyType(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"", "rsa8192", "ec521", "ed25519"} {
+		if got, err := ParseKeyType(name); err == nil {
+			t.Errorf("ParseKeyType(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestKeyTypeNameRoundTrip(t *testing.T) {
+	for _, keyType := range []certcrypto.KeyType{certcrypto.RSA2048, certcrypto.RSA3072, certcrypto.RSA4096, certcrypto.EC256, certcrypto.EC384} {
+		got, err := ParseKeyType(KeyTypeName(keyType))
+		if err != nil || got != keyType {
+			t.Errorf("ParseKeyType(KeyTypeName(%q)) = %q, %v", keyType, got, err)
+		}
+	}
+}
+
+func TestResolveKeyType(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyTypes []string
+		want     certcrypto.KeyType
+		wantErr  bool
+	}{
+		{name: "no override", keyTypes: []string{""}, want: certcrypto.RSA2048},
+		{name: "override", keyTypes: []string{"ec384"}, want: certcrypto.EC384},
+		{name: "override of one group member", keyTypes: []string{"", "ec256", ""}, want: certcrypto.EC256},
+		{name: "same override of all group members", keyTypes: []string{"rsa4096", "rsa4096"}, want: certcrypto.RSA4096},
+		{name: "conflicting overrides", keyTypes: []string{"ec256", "", "rsa2048"}, wantErr: true},
+		{name: "unsupported override", keyTypes: []string{"dsa1024"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &zones.Target{Group: "web"}
+			for _, keyType := range tt.keyTypes {
+				target.Records = append(target.Records, &zones.Record{FQDN: "www.example.com", Settings: zones.Settings{KeyType: keyType}})
+			}
+
+			got, err := ResolveKeyType(target, certcrypto.RSA2048)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveKeyType() = %q, %v, want error %t", got, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveKeyType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateKeyType(t *testing.T) {
+	for _, keyType := range []certcrypto.KeyType{certcrypto.RSA2048, certcrypto.EC256, certcrypto.EC384} {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := certcrypto.GeneratePrivateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			der := selfSignedDER(t, key.(crypto.Signer), time.Now().Add(24*time.Hour), "www.example.com")
+
+			got, err := CertificateKeyType(der)
+			if err != nil {
+				t.Fatalf("CertificateKeyType() error = %v", err)
+			}
+			if got != keyType {
+				t.Errorf("CertificateKeyType() = %q, want %q", got, keyType)
+			}
+		})
+	}
+
+	if _, err := CertificateKeyType([]byte("not a certificate")); err == nil {
+		t.Error("CertificateKeyType() of invalid DER did not fail")
+	}
+}
@@ -0,0 +1,269 @@
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// KeyVaultStore stores certificates as imports in Azure Key Vault, tagged with their origin
+type KeyVaultStore struct {
+	client       *azcertificates.Client
+	policy       *ImportPolicy
+	directoryURL string
+}
+
+// NewKeyVaultStore creates a Key Vault backed certificate store.
+// directoryURL is the ACME directory recorded in the certificate tags.
+func NewKeyVaultStore(client *azcertificates.Client, policy *ImportPolicy, directoryURL string) *KeyVaultStore {
+	return &KeyVaultStore{
+		client:       client,
+		policy:       policy,
+		directoryURL: directoryURL,
+	}
+}
+
+// Name identifies the store in log messages
+func (s *KeyVaultStore) Name() string {
+	return StoreKeyVault
+}
+
+// Get returns the current Key Vault certificate version for the target
+func (s *KeyVaultStore) Get(ctx context.Context, target *zones.Target) (*StoredCertificate, error) {
+	certName := CertificateName(target)
+	resp, err := s.client.GetCertificate(ctx, certName, "", nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	bundle := &resp.CertificateBundle
+
+	// A pending certificate created for a CSR has no certificate until it is merged,
+	// the certificate in use is then the newest issued version
+	if len(bundle.CER) == 0 {
+		bundle, err = s.latestIssuedVersion(ctx, certName)
+		if err != nil || bundle == nil {
+			return nil, err
+		}
+	}
+
+	leaf, err := x509.ParseCertificate(bundle.CER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	expires := leaf.NotAfter
+	if bundle.Attributes != nil && bundle.Attributes.Expires != nil {
+		expires = *bundle.Attributes.Expires
+	}
+
+	return &StoredCertificate{Leaf: leaf, Expires: expires}, nil
+}
+
+// latestIssuedVersion returns the newest enabled version of a certificate that has an issued certificate,
+// or nil when there is none. Versions without a thumbprint are pending or failed operations.
+func (s *KeyVaultStore) latestIssuedVersion(ctx context.Context, certName string) (*azcertificates.CertificateBundle, error) {
+	var latest *azcertificates.CertificateItem
+	pager := s.client.NewListCertificateVersionsPager(certName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list certificate versions: %v", err)
+		}
+		for _, item := range page.Value {
+			if item.ID == nil || len(item.X509Thumbprint) == 0 || item.Attributes == nil || item.Attributes.Created == nil {
+				continue
+			}
+			if item.Attributes.Enabled != nil && !*item.Attributes.Enabled {
+				continue
+			}
+			if latest == nil || item.Attributes.Created.After(*latest.Attributes.Created) {
+				latest = item
+			}
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+
+	resp, err := s.client.GetCertificate(ctx, certName, latest.ID.Version(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate version %s: %v", latest.ID.Version(), err)
+	}
+	if len(resp.CER) == 0 {
+		return nil, nil
+	}
+	return &resp.CertificateBundle, nil
+}
+
+// Put imports the certificate, its chain and private key as a new Key Vault certificate version
+func (s *KeyVaultStore) Put(ctx context.Context, target *zones.Target, cert *IssuedCertificate) error {
+	var value string
+	if s.policy.ContentType == ContentTypePEM {
+		// PEM imports are sent as plain text with the private key followed by the chain.
+		// Key Vault only accepts PKCS#8 keys, not the PKCS#1 or SEC 1 keys of certcrypto.PEMEncode.
+		der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("PKCS8 encoding failed: %v", err)
+		}
+		value = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})) + string(encodeCertificates(cert.Chain))
+	} else {
+		pfxData, err := cert.EncodePFX()
+		if err != nil {
+			return fmt.Errorf("PKCS12 encoding failed: %v", err)
+		}
+
+		// Azure Key Vault expects base64-encoded certificate data
+		value = base64.StdEncoding.EncodeToString(pfxData)
+	}
+
+	_, err := s.client.ImportCertificate(ctx, CertificateName(target), azcertificates.ImportCertificateParameters{
+		Base64EncodedCertificate: &value,
+		CertificatePolicy:        s.policy.certificatePolicy(cert.KeyType),
+		Tags:                     certificateTags(target, cert, s.directoryURL),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("certificate import failed: %v", err)
+	}
+
+	return nil
+}
+
+// KeyVaultCSRStore stores certificates whose private key is generated by Key Vault and never leaves it.
+// Key Vault creates a pending certificate with the "Unknown" issuer, the CSR of that pending
+// certificate is signed by the ACME CA, and the issued chain is merged back into Key Vault.
+type KeyVaultCSRStore struct {
+	*KeyVaultStore
+	hsm bool
+}
+
+// NewKeyVaultCSRStore creates a Key Vault backed certificate store generating keys inside Key Vault.
+// hsm requests HSM-backed keys (Premium vaults only).
+func NewKeyVaultCSRStore(client *azcertificates.Client, policy *ImportPolicy, directoryURL string, hsm bool) *KeyVaultCSRStore {
+	return &KeyVaultCSRStore{
+		KeyVaultStore: NewKeyVaultStore(client, policy, directoryURL),
+		hsm:           hsm,
+	}
+}
+
+// CreateCSR cancels any pending operation left behind by a failed run, creates a new pending
+// certificate with a Key Vault generated key and returns its certificate signing request
+func (s *KeyVaultCSRStore) CreateCSR(ctx context.Context, target *zones.Target, keyType certcrypto.KeyType) (*x509.CertificateRequest, error) {
+	certName := CertificateName(target)
+	domains := target.Domains()
+
+	if err := s.CancelCSR(ctx, target); err != nil {
+		return nil, err
+	}
+
+	sans := make([]*string, 0, len(domains))
+	for _, domain := range domains {
+		sans = append(sans, to.Ptr(domain))
+	}
+
+	resp, err := s.client.CreateCertificate(ctx, certName, azcertificates.CreateCertificateParameters{
+		CertificatePolicy: &azcertificates.CertificatePolicy{
+			IssuerParameters: &azcertificates.IssuerParameters{
+				Name: to.Ptr("Unknown"),
+			},
+			KeyProperties: generatedKeyProperties(keyType, s.hsm, s.policy.Exportable),
+			SecretProperties: &azcertificates.SecretProperties{
+				ContentType: to.Ptr(s.policy.ContentType),
+			},
+			LifetimeActions: s.policy.LifetimeActions,
+			X509CertificateProperties: &azcertificates.X509CertificateProperties{
+				Subject: to.Ptr("CN=" + domains[0]),
+				SubjectAlternativeNames: &azcertificates.SubjectAlternativeNames{
+					DNSNames: sans,
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pending certificate: %v", err)
+	}
+
+	if len(resp.CSR) == 0 {
+		return nil, fmt.Errorf("pending certificate has no CSR")
+	}
+
+	csr, err := x509.ParseCertificateRequest(resp.CSR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSR: %v", err)
+	}
+
+	return csr, nil
+}
+
+// CancelCSR deletes a pending certificate operation of the target, if there is one
+func (s *KeyVaultCSRStore) CancelCSR(ctx context.Context, target *zones.Target) error {
+	certName := CertificateName(target)
+
+	resp, err := s.client.GetCertificateOperation(ctx, certName, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get certificate operation: %v", err)
+	}
+
+	if resp.Status == nil || *resp.Status != operationInProgress {
+		return nil
+	}
+
+	log.Printf("Pending certificate operation cancelled: cert_name=%s, request_id=%s", certName, stringValue(resp.RequestID))
+	if _, err := s.client.DeleteCertificateOperation(ctx, certName, nil); err != nil {
+		return fmt.Errorf("failed to delete pending certificate operation: %v", err)
+	}
+
+	return nil
+}
+
+// Put merges the issued chain into the pending Key Vault certificate
+func (s *KeyVaultCSRStore) Put(ctx context.Context, target *zones.Target, cert *IssuedCertificate) error {
+	if cert.PrivateKey != nil {
+		return s.KeyVaultStore.Put(ctx, target, cert)
+	}
+
+	x5c := make([][]byte, 0, len(cert.Chain))
+	for _, c := range cert.Chain {
+		x5c = append(x5c, c.Raw)
+	}
+
+	_, err := s.client.MergeCertificate(ctx, CertificateName(target), azcertificates.MergeCertificateParameters{
+		X509Certificates: x5c,
+		Tags:             certificateTags(target, cert, s.directoryURL),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("certificate merge failed: %v", err)
+	}
+
+	return nil
+}
+
+// operationInProgress is the status of a pending Key Vault certificate operation
+const operationInProgress = "inProgress"
+
+// stringValue dereferences an optional string
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
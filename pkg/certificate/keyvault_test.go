@@ -0,0 +1,550 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+const testVaultURL = "https://test.vault.azure.net"
+
+// testCredential issues a fixed token for the Key Vault challenge authentication
+type testCredential struct{}
+
+func (testCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeKeyVault serves the Key Vault certificate operations used by the stores from memory
+type fakeKeyVault struct {
+	mu         sync.Mutex
+	versions   map[string][]*azcertificates.CertificateBundle
+	operations map[string]*pendingOperation
+	imports    []azcertificates.ImportCertificateParameters
+	creates    []azcertificates.CreateCertificateParameters
+	cancelled  []string
+	// secrets holds the secret backing each version of an exportable certificate (name/version)
+	secrets map[string]azsecrets.Secret
+}
+
+// pendingOperation is a certificate created with the Unknown issuer, waiting for its certificate to be merged
+type pendingOperation struct {
+	key       *ecdsa.PrivateKey
+	csr       []byte
+	requestID string
+}
+
+// newFakeKeyVault returns the fake vault and a certificates client sending its requests to it
+func newFakeKeyVault(t *testing.T) (*fakeKeyVault, *azcertificates.Client) {
+	t.Helper()
+
+	vault := &fakeKeyVault{
+		versions:   make(map[string][]*azcertificates.CertificateBundle),
+		operations: make(map[string]*pendingOperation),
+		secrets:    make(map[string]azsecrets.Secret),
+	}
+	client, err := azcertificates.NewClient(testVaultURL, testCredential{}, &azcertificates.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: vault},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vault, client
+}
+
+// secretsClient returns a secrets client sending its requests to the fake vault
+func (v *fakeKeyVault) secretsClient(t *testing.T) *azsecrets.Client {
+	t.Helper()
+
+	client, err := azsecrets.NewClient(testVaultURL, testCredential{}, &azsecrets.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: v},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Do implements policy.Transporter
+func (v *fakeKeyVault) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		resp := v.respond(req, http.StatusUnauthorized, nil)
+		resp.Header.Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+		return resp, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "certificates" && req.Method == http.MethodGet:
+		return v.respond(req, http.StatusOK, v.list()), nil
+	case len(path) == 3 && path[0] == "secrets" && req.Method == http.MethodGet:
+		if secret, ok := v.secrets[path[1]+"/"+path[2]]; ok {
+			return v.respond(req, http.StatusOK, secret), nil
+		}
+		return v.respond(req, http.StatusNotFound, map[string]any{"error": map[string]string{"code": "SecretNotFound", "message": "secret not found"}}), nil
+	case len(path) < 2 || path[0] != "certificates":
+		return v.respond(req, http.StatusNotImplemented, nil), nil
+	}
+	name := path[1]
+
+	switch {
+	case len(path) == 3 && req.Method == http.MethodPatch && path[2] != "pending":
+		var params azcertificates.UpdateCertificateParameters
+		if err := decodeBody(req, &params); err != nil {
+			return nil, err
+		}
+		bundle := v.version(name, path[2])
+		if bundle == nil {
+			return v.notFound(req, name), nil
+		}
+		if params.CertificateAttributes != nil && params.CertificateAttributes.Enabled != nil {
+			bundle.Attributes.Enabled = params.CertificateAttributes.Enabled
+		}
+		return v.respond(req, http.StatusOK, bundle), nil
+
+	case len(path) == 3 && path[2] == "pending" && req.Method == http.MethodGet:
+		if op := v.operations[name]; op != nil {
+			return v.respond(req, http.StatusOK, v.operation(name, op)), nil
+		}
+		return v.notFound(req, name), nil
+
+	case len(path) == 3 && path[2] == "pending" && req.Method == http.MethodDelete:
+		op := v.operations[name]
+		if op == nil {
+			return v.notFound(req, name), nil
+		}
+		delete(v.operations, name)
+		versions := v.versions[name]
+		v.versions[name] = versions[:len(versions)-1]
+		v.cancelled = append(v.cancelled, name)
+		return v.respond(req, http.StatusOK, v.operation(name, op)), nil
+
+	case len(path) == 3 && path[2] == "create" && req.Method == http.MethodPost:
+		var params azcertificates.CreateCertificateParameters
+		if err := decodeBody(req, &params); err != nil {
+			return nil, err
+		}
+		op, err := v.createCertificate(name, params)
+		if err != nil {
+			return nil, err
+		}
+		return v.respond(req, http.StatusAccepted, v.operation(name, op)), nil
+
+	case len(path) == 4 && path[2] == "pending" && path[3] == "merge" && req.Method == http.MethodPost:
+		var params azcertificates.MergeCertificateParameters
+		if err := decodeBody(req, &params); err != nil {
+			return nil, err
+		}
+		bundle, err := v.mergeCertificate(name, params)
+		if err != nil {
+			return v.respond(req, http.StatusBadRequest, map[string]any{"error": map[string]string{"code": "BadParameter", "message": err.Error()}}), nil
+		}
+		return v.respond(req, http.StatusCreated, bundle), nil
+
+	case req.Method == http.MethodGet && len(path) == 3 && path[2] == "versions":
+		if len(v.versions[name]) == 0 {
+			return v.notFound(req, name), nil
+		}
+		return v.respond(req, http.StatusOK, v.listVersions(name)), nil
+
+	case req.Method == http.MethodGet && len(path) <= 3:
+		version := ""
+		if len(path) == 3 {
+			version = path[2]
+		}
+		if bundle := v.version(name, version); bundle != nil {
+			return v.respond(req, http.StatusOK, bundle), nil
+		}
+		return v.notFound(req, name), nil
+
+	case req.Method == http.MethodPost && len(path) == 3 && path[2] == "import":
+		var params azcertificates.ImportCertificateParameters
+		if err := decodeBody(req, &params); err != nil {
+			return nil, err
+		}
+		bundle, err := v.importCertificate(name, params)
+		if err != nil {
+			return v.respond(req, http.StatusBadRequest, map[string]any{"error": map[string]string{"code": "BadParameter", "message": err.Error()}}), nil
+		}
+		return v.respond(req, http.StatusOK, bundle), nil
+	}
+
+	return v.respond(req, http.StatusNotImplemented, nil), nil
+}
+
+// importCertificate stores a new version holding the leaf of the imported PFX or PEM data
+func (v *fakeKeyVault) importCertificate(name string, params azcertificates.ImportCertificateParameters) (*azcertificates.CertificateBundle, error) {
+	var leaf *x509.Certificate
+	if *params.CertificatePolicy.SecretProperties.ContentType == ContentTypePEM {
+		// Like Key Vault, only accept PKCS#8 private keys
+		if key, _ := pem.Decode([]byte(*params.Base64EncodedCertificate)); key == nil || key.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("invalid PEM: the private key must be PKCS#8")
+		}
+		chain, err := parseCertificateChain([]byte(*params.Base64EncodedCertificate), nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM: %v", err)
+		}
+		leaf = chain[0]
+	} else {
+		data, err := base64.StdEncoding.DecodeString(*params.Base64EncodedCertificate)
+		if err != nil {
+			return nil, err
+		}
+		if _, leaf, _, err = pkcs12.DecodeChain(data, ""); err != nil {
+			return nil, fmt.Errorf("invalid PFX: %v", err)
+		}
+	}
+
+	v.imports = append(v.imports, params)
+	bundle := v.addVersion(name, leaf.Raw, leaf.NotAfter, params.Tags)
+	if *params.CertificatePolicy.KeyProperties.Exportable {
+		v.secrets[name+"/"+bundle.ID.Version()] = azsecrets.Secret{
+			Value:       params.Base64EncodedCertificate,
+			ContentType: params.CertificatePolicy.SecretProperties.ContentType,
+		}
+	}
+	return bundle, nil
+}
+
+// createCertificate generates a key and starts a pending certificate version for its CSR
+func (v *fakeKeyVault) createCertificate(name string, params azcertificates.CreateCertificateParameters) (*pendingOperation, error) {
+	if v.operations[name] != nil {
+		return nil, fmt.Errorf("certificate %s has a pending operation", name)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var dnsNames []string
+	for _, name := range params.CertificatePolicy.X509CertificateProperties.SubjectAlternativeNames.DNSNames {
+		dnsNames = append(dnsNames, *name)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(*params.CertificatePolicy.X509CertificateProperties.Subject, "CN=")},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	v.creates = append(v.creates, params)
+	op := &pendingOperation{key: key, csr: csr, requestID: fmt.Sprintf("request-%d", len(v.creates))}
+	v.operations[name] = op
+	v.addVersion(name, nil, time.Time{}, params.Tags)
+	return op, nil
+}
+
+// mergeCertificate completes the pending version with a certificate issued for its key
+func (v *fakeKeyVault) mergeCertificate(name string, params azcertificates.MergeCertificateParameters) (*azcertificates.CertificateBundle, error) {
+	op := v.operations[name]
+	if op == nil {
+		return nil, fmt.Errorf("certificate %s has no pending operation", name)
+	}
+	leaf, err := x509.ParseCertificate(params.X509Certificates[0])
+	if err != nil {
+		return nil, err
+	}
+	if !op.key.PublicKey.Equal(leaf.PublicKey) {
+		return nil, fmt.Errorf("public key of the merged certificate does not match the pending key")
+	}
+
+	delete(v.operations, name)
+	bundle := v.version(name, "")
+	thumbprint := sha1.Sum(leaf.Raw)
+	bundle.CER = leaf.Raw
+	bundle.X509Thumbprint = thumbprint[:]
+	bundle.Attributes.Expires = to.Ptr(leaf.NotAfter)
+	if params.Tags != nil {
+		bundle.Tags = params.Tags
+	}
+	return bundle, nil
+}
+
+// list returns the newest version of every certificate as a single page
+func (v *fakeKeyVault) list() azcertificates.CertificateListResult {
+	var result azcertificates.CertificateListResult
+	for name := range v.versions {
+		bundle := v.version(name, "")
+		result.Value = append(result.Value, &azcertificates.CertificateItem{
+			ID:   to.Ptr(azcertificates.ID(testVaultURL + "/certificates/" + name)),
+			Tags: bundle.Tags,
+		})
+	}
+	return result
+}
+
+// listVersions returns the versions of a certificate as a single page, oldest first
+func (v *fakeKeyVault) listVersions(name string) azcertificates.CertificateListResult {
+	var result azcertificates.CertificateListResult
+	for _, bundle := range v.versions[name] {
+		result.Value = append(result.Value, &azcertificates.CertificateItem{
+			ID:             bundle.ID,
+			Attributes:     bundle.Attributes,
+			X509Thumbprint: bundle.X509Thumbprint,
+			Tags:           bundle.Tags,
+		})
+	}
+	return result
+}
+
+func (v *fakeKeyVault) operation(name string, op *pendingOperation) *azcertificates.CertificateOperation {
+	return &azcertificates.CertificateOperation{
+		ID:        to.Ptr(testVaultURL + "/certificates/" + name + "/pending"),
+		CSR:       op.csr,
+		Status:    to.Ptr("inProgress"),
+		RequestID: to.Ptr(op.requestID),
+	}
+}
+
+// addVersion stores a new, newest version of a certificate
+func (v *fakeKeyVault) addVersion(name string, cer []byte, expires time.Time, tags map[string]*string) *azcertificates.CertificateBundle {
+	version := fmt.Sprintf("%032x", len(v.versions[name])+1)
+	bundle := &azcertificates.CertificateBundle{
+		ID:   to.Ptr(azcertificates.ID(testVaultURL + "/certificates/" + name + "/" + version)),
+		Tags: tags,
+		Attributes: &azcertificates.CertificateAttributes{
+			Enabled: to.Ptr(true),
+			Created: to.Ptr(time.Now().Add(time.Duration(len(v.versions[name])) * time.Second)),
+		},
+	}
+	if cer != nil {
+		thumbprint := sha1.Sum(cer)
+		bundle.CER = cer
+		bundle.X509Thumbprint = thumbprint[:]
+		bundle.Attributes.Expires = to.Ptr(expires)
+	}
+	v.versions[name] = append(v.versions[name], bundle)
+	return bundle
+}
+
+// version returns a version of a certificate, the newest for an empty version
+func (v *fakeKeyVault) version(name, version string) *azcertificates.CertificateBundle {
+	versions := v.versions[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	if version == "" {
+		return versions[len(versions)-1]
+	}
+	for _, bundle := range versions {
+		if bundle.ID.Version() == version {
+			return bundle
+		}
+	}
+	return nil
+}
+
+func (v *fakeKeyVault) notFound(req *http.Request, name string) *http.Response {
+	return v.respond(req, http.StatusNotFound, map[string]any{"error": map[string]string{"code": "CertificateNotFound", "message": "A certificate with (name/id) " + name + " was not found in this key vault."}})
+}
+
+func (v *fakeKeyVault) respond(req *http.Request, status int, body any) *http.Response {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+}
+
+func decodeBody(req *http.Request, v any) error {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// issuedCertificate returns a certificate issued below a test chain, as obtained from the CA
+func issuedCertificate(t *testing.T, domains ...string) *IssuedCertificate {
+	t.Helper()
+
+	chain := newTestChain(t, domains...)
+	return &IssuedCertificate{
+		PrivateKey: chain.leafKey,
+		KeyType:    certcrypto.EC256,
+		Chain:      []*x509.Certificate{chain.leaf, chain.intermediate},
+		IssuedAt:   time.Now(),
+	}
+}
+
+func TestKeyVaultStore(t *testing.T) {
+	ctx := context.Background()
+	vault, client := newFakeKeyVault(t)
+	store := NewKeyVaultStore(client, DefaultImportPolicy(), "https://acme.example/directory")
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+
+	stored, err := store.Get(ctx, target)
+	if err != nil || stored != nil {
+		t.Fatalf("Get() of a missing certificate = %v, %v, want nil", stored, err)
+	}
+
+	issued := issuedCertificate(t, "www.example.com")
+	if err := store.Put(ctx, target, issued); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if len(vault.imports) != 1 {
+		t.Fatalf("Put() imported %d certificates, want 1", len(vault.imports))
+	}
+	policy := vault.imports[0].CertificatePolicy
+	if *policy.KeyProperties.KeyType != azcertificates.JSONWebKeyTypeEC || *policy.KeyProperties.Curve != azcertificates.JSONWebKeyCurveNameP256 {
+		t.Errorf("import policy key properties = %+v, want an EC P-256 key", policy.KeyProperties)
+	}
+	pfx, _ := base64.StdEncoding.DecodeString(*vault.imports[0].Base64EncodedCertificate)
+	if _, _, caCerts, err := pkcs12.DecodeChain(pfx, ""); err != nil || len(caCerts) != 1 {
+		t.Errorf("imported PFX has %d CA certificates (%v), want the intermediate", len(caCerts), err)
+	}
+
+	stored, err = store.Get(ctx, target)
+	if err != nil || stored == nil {
+		t.Fatalf("Get() after Put() = %v, %v", stored, err)
+	}
+	if !stored.Leaf.Equal(issued.Leaf()) || !stored.Expires.Equal(issued.Leaf().NotAfter.Truncate(time.Second)) {
+		t.Errorf("Get() = %+v, want the imported certificate", stored)
+	}
+}
+
+func TestKeyVaultStorePEMImport(t *testing.T) {
+	vault, client := newFakeKeyVault(t)
+	actions, err := ParseLifetimeActions([]string{"EmailContacts:30d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewKeyVaultStore(client, &ImportPolicy{ContentType: ContentTypePEM, LifetimeActions: actions}, "https://acme.example/directory")
+	target := &zones.Target{Group: "web", Records: []*zones.Record{{FQDN: "www.example.com", Zone: "example.com", Name: "www", Type: "A"}}}
+
+	if err := store.Put(context.Background(), target, issuedCertificate(t, "www.example.com")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	params := vault.imports[0]
+	value := *params.Base64EncodedCertificate
+	if !strings.HasPrefix(value, "-----BEGIN") || strings.Count(value, "BEGIN CERTIFICATE") != 2 || !strings.Contains(value, "PRIVATE KEY") {
+		t.Errorf("imported PEM = %q, want the private key followed by the chain", value)
+	}
+	policy := params.CertificatePolicy
+	if *policy.SecretProperties.ContentType != ContentTypePEM || *policy.KeyProperties.Exportable {
+		t.Errorf("policy secret content type = %s, exportable = %t, want non-exportable PEM", *policy.SecretProperties.ContentType, *policy.KeyProperties.Exportable)
+	}
+	if len(policy.LifetimeActions) != 1 {
+		t.Errorf("policy lifetime actions = %v, want the configured action", policy.LifetimeActions)
+	}
+	if group := params.Tags[TagGroup]; group == nil || *group != "web" {
+		t.Errorf("tag %s = %v, want web", TagGroup, group)
+	}
+}
+
+func TestKeyVaultCSRStore(t *testing.T) {
+	ctx := context.Background()
+	vault, client := newFakeKeyVault(t)
+	store := NewKeyVaultCSRStore(client, &ImportPolicy{ContentType: ContentTypePKCS12}, "https://acme.example/directory", true)
+	target := &zones.Target{Group: "web", Records: []*zones.Record{{FQDN: "www.example.com"}, {FQDN: "example.com"}}}
+
+	csr, err := store.CreateCSR(ctx, target, certcrypto.EC256)
+	if err != nil {
+		t.Fatalf("CreateCSR() error = %v", err)
+	}
+	if csr.Subject.CommonName != "www.example.com" || len(csr.DNSNames) != 2 {
+		t.Errorf("CSR subject = %s, names = %v, want both domains", csr.Subject, csr.DNSNames)
+	}
+
+	policy := vault.creates[0].CertificatePolicy
+	if *policy.IssuerParameters.Name != "Unknown" {
+		t.Errorf("issuer = %s, want Unknown", *policy.IssuerParameters.Name)
+	}
+	if *policy.KeyProperties.KeyType != azcertificates.JSONWebKeyTypeECHSM || *policy.KeyProperties.Exportable {
+		t.Errorf("key properties = %+v, want a non-exportable EC-HSM key", policy.KeyProperties)
+	}
+
+	// The pending version has no certificate yet
+	if stored, err := store.Get(ctx, target); err != nil || stored != nil {
+		t.Fatalf("Get() of a pending certificate = %v, %v, want nil", stored, err)
+	}
+
+	chain := newTestChain(t, "www.example.com")
+	leaf := chain.signCSR(t, csr)
+	if err := store.Put(ctx, target, &IssuedCertificate{KeyType: certcrypto.EC256, Chain: []*x509.Certificate{leaf, chain.intermediate}}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if len(vault.imports) != 0 {
+		t.Error("Put() without private key imported the certificate instead of merging it")
+	}
+
+	stored, err := store.Get(ctx, target)
+	if err != nil || stored == nil || !stored.Leaf.Equal(leaf) {
+		t.Fatalf("Get() after merge = %v, %v, want the merged leaf", stored, err)
+	}
+	if directory := vault.version(CertificateName(target), "").Tags[TagDirectoryURL]; directory == nil || *directory != "https://acme.example/directory" {
+		t.Errorf("merged tag %s = %v, want the ACME directory", TagDirectoryURL, directory)
+	}
+
+	// While the renewal is pending, the merged certificate is still the one in use
+	if _, err := store.CreateCSR(ctx, target, certcrypto.EC256); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = store.Get(ctx, target)
+	if err != nil || stored == nil || !stored.Leaf.Equal(leaf) {
+		t.Errorf("Get() during a pending renewal = %v, %v, want the merged leaf", stored, err)
+	}
+}
+
+func TestKeyVaultCSRStoreCancelsPendingOperation(t *testing.T) {
+	ctx := context.Background()
+	vault, client := newFakeKeyVault(t)
+	store := NewKeyVaultCSRStore(client, &ImportPolicy{Exportable: true, ContentType: ContentTypePKCS12}, "https://acme.example/directory", false)
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+
+	if err := store.CancelCSR(ctx, target); err != nil {
+		t.Fatalf("CancelCSR() without pending operation error = %v", err)
+	}
+
+	// A run that failed after creating the CSR leaves the operation pending, the next run replaces it
+	first, err := store.CreateCSR(ctx, target, certcrypto.RSA2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.CreateCSR(ctx, target, certcrypto.RSA2048)
+	if err != nil {
+		t.Fatalf("CreateCSR() with a pending operation error = %v", err)
+	}
+	if len(vault.cancelled) != 1 || first.PublicKey.(*ecdsa.PublicKey).Equal(second.PublicKey) {
+		t.Errorf("pending operation not replaced: cancelled=%v", vault.cancelled)
+	}
+	if *vault.creates[1].CertificatePolicy.KeyProperties.KeyType != azcertificates.JSONWebKeyTypeRSA {
+		t.Errorf("key type = %s, want RSA", *vault.creates[1].CertificatePolicy.KeyProperties.KeyType)
+	}
+}
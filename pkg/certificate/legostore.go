@@ -0,0 +1,95 @@
+package certificate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// LegoStore writes certificates using the lego CLI storage layout, so that the files can be
+// consumed or renewed by the lego CLI:
+//
+//	<root>/certificates/<domain>.crt         leaf certificate followed by the issuer certificates
+//	<root>/certificates/<domain>.issuer.crt  issuer certificates
+//	<root>/certificates/<domain>.key         private key
+//	<root>/certificates/<domain>.json        certificate resource metadata
+//	<root>/certificates/<domain>.pfx         PKCS#12 archive (no password)
+//
+// <domain> is the first domain of the certificate, with "*" replaced by "_" as lego does.
+type LegoStore struct {
+	rootPath string
+}
+
+// NewLegoStore creates a certificate store using the lego layout under the given root (usually ~/.lego)
+func NewLegoStore(rootPath string) *LegoStore {
+	return &LegoStore{rootPath: filepath.Join(rootPath, "certificates")}
+}
+
+// Name identifies the store in log messages
+func (s *LegoStore) Name() string {
+	return StoreLego
+}
+
+// Get reads the leaf certificate of the target from the lego certificate bundle
+func (s *LegoStore) Get(ctx context.Context, target *zones.Target) (*StoredCertificate, error) {
+	return readStoredCertificate(s.filePath(target.Domains()[0], ".crt"))
+}
+
+// Put writes the certificate files of the target. Without a private key (generated in Key Vault), the
+// key and PKCS#12 files of an earlier certificate are removed, as they no longer match the certificate.
+func (s *LegoStore) Put(ctx context.Context, target *zones.Target, cert *IssuedCertificate) error {
+	if err := os.MkdirAll(s.rootPath, dirPerm); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	domain := target.Domains()[0]
+	if cert.Resource != nil && cert.Resource.Domain != "" {
+		domain = cert.Resource.Domain
+	}
+	baseName := sanitizedDomain(domain)
+
+	metadata, err := json.MarshalIndent(cert.Resource, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate resource: %v", err)
+	}
+
+	files := []storeFile{
+		{baseName + ".crt", encodeCertificates(cert.Chain), keyFilePerm},
+		{baseName + ".issuer.crt", encodeCertificates(cert.Chain[1:]), keyFilePerm},
+		{baseName + ".json", metadata, keyFilePerm},
+	}
+
+	if cert.PrivateKey == nil {
+		if err := removeStoreFiles(s.rootPath, baseName+".key", baseName+".pfx"); err != nil {
+			return err
+		}
+	} else {
+		pfxData, err := cert.EncodePFX()
+		if err != nil {
+			return fmt.Errorf("PKCS12 encoding failed: %v", err)
+		}
+		files = append(files,
+			storeFile{baseName + ".key", certcrypto.PEMEncode(cert.PrivateKey), keyFilePerm},
+			storeFile{baseName + ".pfx", pfxData, keyFilePerm},
+		)
+	}
+
+	return writeStoreFiles(s.rootPath, files)
+}
+
+// filePath returns the path of a lego certificate file for a domain
+func (s *LegoStore) filePath(domain, extension string) string {
+	return filepath.Join(s.rootPath, sanitizedDomain(domain)+extension)
+}
+
+// sanitizedDomain converts a domain into a file name the same way the lego CLI does
+func sanitizedDomain(domain string) string {
+	return strings.NewReplacer(":", "-", "*", "_").Replace(domain)
+}
@@ -0,0 +1,200 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// DefaultNameTemplate reproduces the historical naming scheme: "cert-" followed by the dashed FQDN
+// for single records and "cert-group-" followed by the group name for groups.
+// A wildcard record set ("*") maps to "cert-wildcard-<zone>", while a record flagged with acme-wildcard
+// (covering both the name and "*." + name) gets a "-wildcard" suffix.
+const DefaultNameTemplate = `{{if .Group}}cert-group-{{sanitize .Group}}{{else}}cert-{{dashed .FQDN}}{{if .Wildcard}}-wildcard{{end}}{{end}}`
+
+// maxNameLength is the maximum length of a Key Vault object name
+const maxNameLength = 127
+
+// validName matches valid Key Vault object names
+var validName = regexp.MustCompile(`^[0-9a-zA-Z-]+$`)
+
+// invalidNameChars matches characters not allowed in Key Vault object names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// nameFuncs are the functions available in naming templates
+var nameFuncs = template.FuncMap{
+	// dashed replaces dots with dashes and a leading "*" with "wildcard" (not collision-free)
+	"dashed": func(s string) string {
+		return strings.ReplaceAll(strings.Replace(strings.ToLower(s), "*", "wildcard", 1), ".", "-")
+	},
+	// encode doubles dashes before replacing dots with dashes, so that distinct names stay distinct
+	"encode": func(s string) string {
+		s = strings.ReplaceAll(strings.ToLower(s), "-", "--")
+		return strings.ReplaceAll(strings.Replace(s, "*", "wildcard", 1), ".", "-")
+	},
+	// sanitize replaces runs of characters not allowed in Key Vault names with a single dash
+	"sanitize": func(s string) string {
+		return strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	},
+	// hash returns the first 16 hexadecimal digits of the SHA-256 hash of a string
+	"hash": func(s string) string {
+		sum := sha256.Sum256([]byte(strings.ToLower(s)))
+		return hex.EncodeToString(sum[:8])
+	},
+	"lower": strings.ToLower,
+}
+
+// NameData holds the fields available in naming templates
+type NameData struct {
+	// FQDN is the FQDN of the first record of the target
+	FQDN string
+	// Zone is the DNS zone of the first record
+	Zone string
+	// Record is the record set name of the first record ("@" for the zone apex)
+	Record string
+	// Group is the acme-group metadata value, empty for single records
+	Group string
+	// Wildcard is set when the first record is flagged with acme-wildcard
+	Wildcard bool
+}
+
+// Namer assigns Key Vault certificate names to targets using a Go template
+type Namer struct {
+	tmpl *template.Template
+}
+
+// defaultNamer renders DefaultNameTemplate for targets without an assigned name
+var defaultNamer = MustNewNamer(DefaultNameTemplate)
+
+// NewNamer parses a naming template. An empty template selects DefaultNameTemplate.
+func NewNamer(text string) (*Namer, error) {
+	if strings.TrimSpace(text) == "" {
+		text = DefaultNameTemplate
+	}
+
+	tmpl, err := template.New("certificate-name").Funcs(nameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate name template: %v", err)
+	}
+
+	return &Namer{tmpl: tmpl}, nil
+}
+
+// MustNewNamer is like NewNamer but panics when the template is invalid
+func MustNewNamer(text string) *Namer {
+	namer, err := NewNamer(text)
+	if err != nil {
+		panic(err)
+	}
+	return namer
+}
+
+// Name returns the Key Vault certificate name of a target, honouring the acme-cert-name metadata override.
+// All records of a group that set the override must agree on its value.
+func (n *Namer) Name(target *zones.Target) (string, error) {
+	override := ""
+	for _, record := range target.Records {
+		value := record.Settings.CertName
+		if value == "" {
+			continue
+		}
+		if override != "" && override != value {
+			return "", fmt.Errorf("conflicting certificate names %q and %q in group %s", override, value, target.Group)
+		}
+		override = value
+	}
+
+	name := override
+	if name == "" {
+		var err error
+		if name, err = n.render(target); err != nil {
+			return "", err
+		}
+	}
+
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// AssignNames sets the certificate name of every target. Targets with an invalid name and all targets
+// sharing a name with another target are logged and left out of the result, so that no order is placed for them.
+func (n *Namer) AssignNames(targets []*zones.Target) []*zones.Target {
+	byName := make(map[string][]*zones.Target)
+	var named []*zones.Target
+	for _, target := range targets {
+		name, err := n.Name(target)
+		if err != nil {
+			log.Printf("Certificate name invalid, target skipped: name=%s, error=%v", target.Name(), err)
+			continue
+		}
+		target.CertName = name
+		byName[strings.ToLower(name)] = append(byName[strings.ToLower(name)], target)
+		named = append(named, target)
+	}
+
+	var result []*zones.Target
+	for _, target := range named {
+		colliding := byName[strings.ToLower(target.CertName)]
+		if len(colliding) > 1 {
+			var names []string
+			for _, other := range colliding {
+				names = append(names, other.Name())
+			}
+			log.Printf("Certificate name collision, target skipped: name=%s, cert_name=%s, targets=%s", target.Name(), target.CertName, strings.Join(names, ","))
+			continue
+		}
+		result = append(result, target)
+	}
+	return result
+}
+
+// render executes the naming template for a target
+func (n *Namer) render(target *zones.Target) (string, error) {
+	record := target.Records[0]
+	data := NameData{
+		FQDN:     strings.ToLower(record.FQDN),
+		Zone:     strings.ToLower(record.Zone),
+		Record:   record.Name,
+		Group:    target.Group,
+		Wildcard: record.Wildcard,
+	}
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("certificate name template failed: %v", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// ValidateName checks that a name is a valid Key Vault object name
+func ValidateName(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("certificate name %q is longer than %d characters", name, maxNameLength)
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("certificate name %q may only contain letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// CertificateName returns the Key Vault certificate name of a target: the name assigned by a Namer,
+// or the DefaultNameTemplate name for targets that were not named
+func CertificateName(target *zones.Target) string {
+	if target.CertName != "" {
+		return target.CertName
+	}
+	name, err := defaultNamer.render(target)
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,114 @@
+package certificate
+
+import (
+	"strings"
+	"testing"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+func TestCertificateName(t *testing.T) {
+	single := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+	if got := CertificateName(single); got != "cert-www-example-com" {
+		t.Errorf("CertificateName() of a record = %q, want cert-www-example-com", got)
+	}
+
+	wildcardSet := &zones.Target{Records: []*zones.Record{{FQDN: "*.Example.com"}}}
+	if got := CertificateName(wildcardSet); got != "cert-wildcard-example-com" {
+		t.Errorf("CertificateName() of a wildcard record set = %q, want cert-wildcard-example-com", got)
+	}
+
+	flagged := &zones.Target{Records: []*zones.Record{{FQDN: "example.com", Wildcard: true}}}
+	if got := CertificateName(flagged); got != "cert-example-com-wildcard" {
+		t.Errorf("CertificateName() of an acme-wildcard record = %q, want cert-example-com-wildcard", got)
+	}
+
+	group := &zones.Target{Group: "Web Shop_1.", Records: single.Records}
+	if got := CertificateName(group); got != "cert-group-web-shop-1" {
+		t.Errorf("CertificateName() of a group = %q, want cert-group-web-shop-1", got)
+	}
+}
+
+func TestNamerTemplates(t *testing.T) {
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "Shop-1.Example.com", Zone: "example.com", Name: "shop-1"}}}
+
+	for text, want := range map[string]string{
+		"":                                  "cert-shop-1-example-com",
+		"{{encode .FQDN}}":                  "shop--1-example-com",
+		"kv-{{dashed .Zone}}-{{.Record}}":   "kv-example-com-shop-1",
+		"cert-{{hash .FQDN}}":               "cert-1547b8b8d3f3be56",
+		" {{sanitize \"Shop 1 (EU)\"}} ":    "shop-1-eu",
+		"{{lower \"WEB\"}}-{{.Record}}":     "web-shop-1",
+		"{{if .Wildcard}}w{{else}}s{{end}}": "s",
+	} {
+		namer, err := NewNamer(text)
+		if err != nil {
+			t.Fatalf("NewNamer(%q) error = %v", text, err)
+		}
+		if got, err := namer.Name(target); err != nil || got != want {
+			t.Errorf("template %q: Name() = %q, %v, want %q", text, got, err, want)
+		}
+	}
+
+	// Names that Key Vault would reject are errors
+	for _, text := range []string{"{{.FQDN}}", "cert_{{.Record}}", strings.Repeat("a", maxNameLength+1)} {
+		if got, err := MustNewNamer(text).Name(target); err == nil {
+			t.Errorf("template %q: Name() = %q, want an error", text, got)
+		}
+	}
+
+	if _, err := NewNamer("{{.FQDN"); err == nil {
+		t.Error("NewNamer() accepted an unterminated template")
+	}
+}
+
+func TestNamerCertNameOverride(t *testing.T) {
+	group := func(certNames ...string) *zones.Target {
+		target := &zones.Target{Group: "web"}
+		for _, certName := range certNames {
+			target.Records = append(target.Records, &zones.Record{
+				FQDN:     "www.example.com",
+				Settings: zones.Settings{CertName: certName},
+			})
+		}
+		return target
+	}
+	namer := MustNewNamer("")
+
+	if got, err := namer.Name(group("", "web-frontend", "web-frontend")); err != nil || got != "web-frontend" {
+		t.Errorf("Name() = %q, %v, want the override web-frontend", got, err)
+	}
+	if got, err := namer.Name(group("web-a", "web-b")); err == nil {
+		t.Errorf("Name() with conflicting overrides = %q, want an error", got)
+	}
+	if got, err := namer.Name(group("web.frontend")); err == nil {
+		t.Errorf("Name() with an invalid override = %q, want an error", got)
+	}
+}
+
+func TestAssignNames(t *testing.T) {
+	target := func(fqdn, certName string) *zones.Target {
+		return &zones.Target{Records: []*zones.Record{{FQDN: fqdn, Settings: zones.Settings{CertName: certName}}}}
+	}
+	www := target("www.example.com", "")
+	api := target("api.example.com", "")
+	// The dashed default names of these two FQDNs collide
+	dashed := target("a-b.example.com", "")
+	dotted := target("a.b.example.com", "")
+	invalid := target("mail.example.com", "mail_example")
+	// Names are compared case-insensitively, as Key Vault does
+	override := target("shop.example.com", "CERT-WWW-EXAMPLE-COM")
+
+	got := MustNewNamer("").AssignNames([]*zones.Target{www, api, dashed, dotted, invalid, override})
+
+	if len(got) != 1 || got[0] != api {
+		var names []string
+		for _, target := range got {
+			names = append(names, target.CertName)
+		}
+		t.Fatalf("AssignNames() kept %v, want only cert-api-example-com", names)
+	}
+	if api.CertName != "cert-api-example-com" || CertificateName(api) != api.CertName {
+		t.Errorf("assigned name = %q, want cert-api-example-com", api.CertName)
+	}
+}
@@ -0,0 +1,109 @@
+package certificate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// Content types of the Key Vault secret backing a certificate
+const (
+	ContentTypePKCS12 = "application/x-pkcs12"
+	ContentTypePEM    = "application/x-pem-file"
+)
+
+// ImportPolicy configures the Key Vault certificate policy sent with imported and merged certificates
+type ImportPolicy struct {
+	// Exportable allows the private key to be downloaded as part of the certificate secret
+	Exportable bool
+	// ContentType is the content type of the certificate secret (ContentTypePKCS12 or ContentTypePEM)
+	ContentType string
+	// LifetimeActions are executed by Key Vault as the certificate approaches its expiry
+	LifetimeActions []*azcertificates.LifetimeAction
+}
+
+// DefaultImportPolicy returns the policy used when none is configured: exportable PKCS#12 without lifetime actions
+func DefaultImportPolicy() *ImportPolicy {
+	return &ImportPolicy{
+		Exportable:  true,
+		ContentType: ContentTypePKCS12,
+	}
+}
+
+// ParseContentType converts a content type name (pkcs12, pem or a MIME type) to a Key Vault secret content type
+func ParseContentType(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "pkcs12", "pfx", ContentTypePKCS12:
+		return ContentTypePKCS12, nil
+	case "pem", ContentTypePEM:
+		return ContentTypePEM, nil
+	default:
+		return "", fmt.Errorf("unsupported content type %q (supported: pkcs12, pem)", name)
+	}
+}
+
+// ParseLifetimeActions parses lifetime actions in the form "<action>:<trigger>", where the trigger is
+// a number of days before expiry ("30d") or a percentage of the certificate lifetime ("80%").
+// Only EmailContacts is accepted, because Key Vault cannot renew certificates of an unknown issuer.
+func ParseLifetimeActions(values []string) ([]*azcertificates.LifetimeAction, error) {
+	var actions []*azcertificates.LifetimeAction
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+
+			actionName, trigger, found := strings.Cut(entry, ":")
+			if !found {
+				return nil, fmt.Errorf("invalid lifetime action %q (expected <action>:<days>d or <action>:<percent>%%)", entry)
+			}
+			if !strings.EqualFold(actionName, string(azcertificates.CertificatePolicyActionEmailContacts)) {
+				return nil, fmt.Errorf("unsupported lifetime action %q (supported: EmailContacts)", actionName)
+			}
+
+			action := &azcertificates.LifetimeAction{
+				Action:  &azcertificates.Action{ActionType: to.Ptr(azcertificates.CertificatePolicyActionEmailContacts)},
+				Trigger: &azcertificates.Trigger{},
+			}
+
+			switch {
+			case strings.HasSuffix(trigger, "d"):
+				days, err := strconv.Atoi(strings.TrimSuffix(trigger, "d"))
+				if err != nil || days < 1 {
+					return nil, fmt.Errorf("invalid days before expiry in lifetime action %q", entry)
+				}
+				action.Trigger.DaysBeforeExpiry = to.Ptr(int32(days))
+			case strings.HasSuffix(trigger, "%"):
+				percent, err := strconv.Atoi(strings.TrimSuffix(trigger, "%"))
+				if err != nil || percent < 1 || percent > 99 {
+					return nil, fmt.Errorf("invalid lifetime percentage in lifetime action %q (1-99)", entry)
+				}
+				action.Trigger.LifetimePercentage = to.Ptr(int32(percent))
+			default:
+				return nil, fmt.Errorf("invalid trigger in lifetime action %q (expected <days>d or <percent>%%)", entry)
+			}
+
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+// certificatePolicy builds the Key Vault certificate policy for a key type
+func (p *ImportPolicy) certificatePolicy(keyType certcrypto.KeyType) *azcertificates.CertificatePolicy {
+	props := keyProperties(keyType)
+	props.Exportable = to.Ptr(p.Exportable)
+
+	return &azcertificates.CertificatePolicy{
+		KeyProperties: props,
+		SecretProperties: &azcertificates.SecretProperties{
+			ContentType: to.Ptr(p.ContentType),
+		},
+		LifetimeActions: p.LifetimeActions,
+	}
+}
@@ -0,0 +1,60 @@
+package certificate
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+)
+
+func TestParseContentType(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"", ContentTypePKCS12},
+		{"PKCS12", ContentTypePKCS12},
+		{"pfx", ContentTypePKCS12},
+		{" pem ", ContentTypePEM},
+		{"application/x-pem-file", ContentTypePEM},
+	} {
+		got, err := ParseContentType(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseContentType(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+
+	if _, err := ParseContentType("der"); err == nil {
+		t.Error("ParseContentType(der) did not fail")
+	}
+}
+
+func TestParseLifetimeActions(t *testing.T) {
+	actions, err := ParseLifetimeActions([]string{"EmailContacts:30d, emailcontacts:80%", ""})
+	if err != nil {
+		t.Fatalf("ParseLifetimeActions() error = %v", err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("ParseLifetimeActions() returned %d actions, want 2", len(actions))
+	}
+	for _, action := range actions {
+		if *action.Action.ActionType != azcertificates.CertificatePolicyActionEmailContacts {
+			t.Errorf("action type = %s, want EmailContacts", *action.Action.ActionType)
+		}
+	}
+	if days := actions[0].Trigger.DaysBeforeExpiry; days == nil || *days != 30 || actions[0].Trigger.LifetimePercentage != nil {
+		t.Errorf("first trigger = %+v, want 30 days before expiry", actions[0].Trigger)
+	}
+	if percent := actions[1].Trigger.LifetimePercentage; percent == nil || *percent != 80 || actions[1].Trigger.DaysBeforeExpiry != nil {
+		t.Errorf("second trigger = %+v, want 80%% of the lifetime", actions[1].Trigger)
+	}
+
+	for _, value := range []string{
+		"EmailContacts",
+		"AutoRenew:30d",
+		"EmailContacts:0d",
+		"EmailContacts:100%",
+		"EmailContacts:30",
+		"EmailContacts:xd",
+	} {
+		if _, err := ParseLifetimeActions([]string{value}); err == nil {
+			t.Errorf("ParseLifetimeActions(%q) did not fail", value)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+// RenewalInfo holds the CA-suggested renewal window of a certificate (ACME ARI, RFC 9773)
+type RenewalInfo struct {
+	CertID         string
+	WindowStart    time.Time
+	WindowEnd      time.Time
+	ExplanationURL string
+
+	response *certificate.RenewalInfoResponse
+}
+
+// FetchRenewalInfo queries the ACME server for the suggested renewal window of a DER-encoded certificate.
+// It returns an error wrapping api.ErrNoARI when the server does not support ARI.
+func FetchRenewalInfo(client *lego.Client, der []byte) (*RenewalInfo, error) {
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	certID, err := certificate.MakeARICertID(leaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build ARI certificate ID: %w", err)
+	}
+
+	resp, err := client.Certificate.GetRenewalInfo(certificate.RenewalInfoRequest{Cert: leaf})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get renewal info: %w", err)
+	}
+
+	return &RenewalInfo{
+		CertID:         certID,
+		WindowStart:    resp.SuggestedWindow.Start,
+		WindowEnd:      resp.SuggestedWindow.End,
+		ExplanationURL: resp.ExplanationURL,
+		response:       resp,
+	}, nil
+}
+
+// ShouldRenew picks a random time inside the suggested window and reports whether it has passed.
+// A window that has already ended, which is how a CA signals revocation or mass replacement,
+// always results in an immediate renewal.
+func (r *RenewalInfo) ShouldRenew(now time.Time) bool {
+	return r.response.ShouldRenewAt(now, 0) != nil
+}
+
+// InWindow reports whether the suggested renewal window has started
+func (r *RenewalInfo) InWindow(now time.Time) bool {
+	return !now.Before(r.WindowStart)
+}
+
+// WindowPassed reports whether the suggested renewal window has already ended
+func (r *RenewalInfo) WindowPassed(now time.Time) bool {
+	return now.After(r.WindowEnd)
+}
@@ -0,0 +1,68 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme/api"
+)
+
+func TestFetchRenewalInfo(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := selfSignedDER(t, key, time.Now().Add(30*24*time.Hour), "www.example.com")
+
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+
+	server := newTestACMEServer(t)
+	var requested string
+	server.handle("/renewal-info/", func(w http.ResponseWriter, r *http.Request) {
+		requested = strings.TrimPrefix(r.URL.Path, "/renewal-info/")
+		fmt.Fprintf(w, `{"suggestedWindow":{"start":%q,"end":%q},"explanationURL":"https://ca.example/incident"}`,
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+	})
+
+	info, err := FetchRenewalInfo(server.client(t), der)
+	if err != nil {
+		t.Fatalf("FetchRenewalInfo() error = %v", err)
+	}
+	if info.CertID == "" || info.CertID != requested {
+		t.Errorf("CertID = %q, want the requested ID %q", info.CertID, requested)
+	}
+	if !info.WindowStart.Equal(start) || !info.WindowEnd.Equal(end) || info.ExplanationURL != "https://ca.example/incident" {
+		t.Errorf("FetchRenewalInfo() = %+v", info)
+	}
+
+	if info.InWindow(start.Add(-time.Minute)) || info.ShouldRenew(start.Add(-time.Minute)) {
+		t.Error("renewal due before the window starts")
+	}
+	if !info.InWindow(start) || info.WindowPassed(end) {
+		t.Error("window bounds not inclusive")
+	}
+	if !info.WindowPassed(end.Add(time.Second)) || !info.ShouldRenew(end.Add(time.Second)) {
+		t.Error("renewal not due after the window ended")
+	}
+}
+
+func TestFetchRenewalInfoWithoutARI(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := selfSignedDER(t, key, time.Now().Add(30*24*time.Hour), "www.example.com")
+
+	_, err = FetchRenewalInfo(newTestACMEServer(t).client(t), der)
+	if !errors.Is(err, api.ErrNoARI) {
+		t.Errorf("FetchRenewalInfo() error = %v, want api.ErrNoARI", err)
+	}
+}
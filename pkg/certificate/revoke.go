@@ -0,0 +1,218 @@
+package certificate
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	legoACME "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+)
+
+// revocationReasons maps RFC 5280 reason names to CRL reason codes accepted by ACME CAs
+var revocationReasons = map[string]uint{
+	"unspecified":          legoACME.CRLReasonUnspecified,
+	"keycompromise":        legoACME.CRLReasonKeyCompromise,
+	"affiliationchanged":   legoACME.CRLReasonAffiliationChanged,
+	"superseded":           legoACME.CRLReasonSuperseded,
+	"cessationofoperation": legoACME.CRLReasonCessationOfOperation,
+}
+
+// ParseRevocationReason converts a reason name (e.g. keyCompromise) or code (e.g. 1) to a CRL reason code
+func ParseRevocationReason(value string) (uint, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if reason, ok := revocationReasons[value]; ok {
+		return reason, nil
+	}
+
+	if code, err := strconv.ParseUint(value, 10, 8); err == nil {
+		for _, reason := range revocationReasons {
+			if uint(code) == reason {
+				return reason, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported revocation reason %q (supported: unspecified (0), keyCompromise (1), affiliationChanged (3), superseded (4), cessationOfOperation (5))", value)
+}
+
+// RevokedCertificate describes a certificate revoked by the Revoker
+type RevokedCertificate struct {
+	Name    string
+	Version string
+	Leaf    *x509.Certificate
+}
+
+// Revoker revokes certificates stored in Key Vault
+type Revoker struct {
+	acmeClient     *lego.Client
+	kvCertClient   *azcertificates.Client
+	kvSecretClient *azsecrets.Client
+	namer          *Namer
+	directoryURL   string
+}
+
+// NewRevoker creates a new certificate revoker.
+// acmeClient is the ACME account client, directoryURL is used to revoke with the certificate key instead.
+// namer names certificates of FQDNs that are not found by their fqdn tag.
+func NewRevoker(acmeClient *lego.Client, kvCertClient *azcertificates.Client, kvSecretClient *azsecrets.Client, namer *Namer, directoryURL string) *Revoker {
+	return &Revoker{
+		acmeClient:     acmeClient,
+		kvCertClient:   kvCertClient,
+		kvSecretClient: kvSecretClient,
+		namer:          namer,
+		directoryURL:   directoryURL,
+	}
+}
+
+// FindCertificateName resolves an FQDN to the name of the Key Vault certificate covering it.
+// Certificates are matched by their fqdn tag, falling back to the name of a single-record certificate.
+func (r *Revoker) FindCertificateName(ctx context.Context, fqdn string) (string, error) {
+	fqdn = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(fqdn), "."))
+
+	var matches []string
+	pager := r.kvCertClient.NewListCertificatesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to list certificates: %v", err)
+		}
+		for _, item := range page.Value {
+			if item.ID == nil || item.Tags[TagFQDN] == nil {
+				continue
+			}
+			domains := strings.Split(strings.ToLower(*item.Tags[TagFQDN]), ",")
+			if slices.Contains(domains, fqdn) {
+				matches = append(matches, item.ID.Name())
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		record := &zones.Record{FQDN: fqdn}
+		if strings.HasPrefix(fqdn, "*.") {
+			record = &zones.Record{FQDN: strings.TrimPrefix(fqdn, "*."), Wildcard: true}
+		}
+		return r.namer.Name(&zones.Target{Records: []*zones.Record{record}})
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s is covered by several certificates: %s", fqdn, strings.Join(matches, ", "))
+	}
+}
+
+// Revoke revokes the current version of a Key Vault certificate. It uses the ACME account first and falls
+// back to the certificate private key, which is only available when the key is exportable.
+func (r *Revoker) Revoke(ctx context.Context, certName string, reason uint) (*RevokedCertificate, error) {
+	resp, err := r.kvCertClient.GetCertificate(ctx, certName, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %v", err)
+	}
+	if len(resp.CER) == 0 || resp.ID == nil {
+		return nil, fmt.Errorf("certificate %s has no issued version", certName)
+	}
+
+	leaf, err := x509.ParseCertificate(resp.CER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	revoked := &RevokedCertificate{Name: certName, Version: resp.ID.Version(), Leaf: leaf}
+	certPEM := encodeCertificates([]*x509.Certificate{leaf})
+
+	var accountErr error
+	if r.acmeClient != nil {
+		accountErr = r.acmeClient.Certificate.RevokeWithReason(certPEM, &reason)
+		if accountErr == nil {
+			log.Printf("Certificate revoked with ACME account: cert_name=%s, version=%s, serial=%s", certName, revoked.Version, leaf.SerialNumber.Text(16))
+			return revoked, nil
+		}
+		log.Printf("Certificate revocation with ACME account failed, trying certificate key: cert_name=%s, error=%v", certName, accountErr)
+	}
+
+	privateKey, err := r.privateKey(ctx, certName, revoked.Version)
+	if err != nil {
+		if accountErr != nil {
+			return nil, fmt.Errorf("revocation with ACME account failed: %v; certificate key unavailable: %v", accountErr, err)
+		}
+		return nil, fmt.Errorf("certificate key unavailable: %v", err)
+	}
+
+	keyClient, err := acme.NewKeyClient(r.directoryURL, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := keyClient.Certificate.RevokeWithReason(certPEM, &reason); err != nil {
+		return nil, fmt.Errorf("revocation with certificate key failed: %v", err)
+	}
+
+	log.Printf("Certificate revoked with certificate key: cert_name=%s, version=%s, serial=%s", certName, revoked.Version, leaf.SerialNumber.Text(16))
+	return revoked, nil
+}
+
+// Disable disables a Key Vault certificate version
+func (r *Revoker) Disable(ctx context.Context, certName, version string) error {
+	_, err := r.kvCertClient.UpdateCertificate(ctx, certName, version, azcertificates.UpdateCertificateParameters{
+		CertificateAttributes: &azcertificates.CertificateAttributes{
+			Enabled: to.Ptr(false),
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to disable certificate version: %v", err)
+	}
+	return nil
+}
+
+// privateKey reads the private key of a certificate version from the secret backing it
+func (r *Revoker) privateKey(ctx context.Context, certName, version string) (crypto.PrivateKey, error) {
+	resp, err := r.kvSecretClient.GetSecret(ctx, certName, version, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate secret: %v", err)
+	}
+	if resp.Value == nil {
+		return nil, fmt.Errorf("certificate secret is empty")
+	}
+
+	if resp.ContentType != nil && *resp.ContentType == ContentTypePEM {
+		rest := []byte(*resp.Value)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				return nil, fmt.Errorf("certificate secret holds no private key (key not exportable)")
+			}
+			if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				return certcrypto.ParsePEMPrivateKey(pem.EncodeToMemory(block))
+			}
+		}
+	}
+
+	pfxData, err := base64.StdEncoding.DecodeString(*resp.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate secret: %v", err)
+	}
+
+	privateKey, _, _, err := pkcs12.DecodeChain(pfxData, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS12 certificate secret (key not exportable?): %v", err)
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,175 @@
+package certificate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	legoACME "github.com/go-acme/lego/v4/acme"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+func TestParseRevocationReason(t *testing.T) {
+	tests := map[string]uint{
+		"unspecified":          legoACME.CRLReasonUnspecified,
+		"keyCompromise":        legoACME.CRLReasonKeyCompromise,
+		" Superseded ":         legoACME.CRLReasonSuperseded,
+		"cessationOfOperation": legoACME.CRLReasonCessationOfOperation,
+		"0":                    legoACME.CRLReasonUnspecified,
+		"3":                    legoACME.CRLReasonAffiliationChanged,
+	}
+	for value, want := range tests {
+		if got, err := ParseRevocationReason(value); err != nil || got != want {
+			t.Errorf("ParseRevocationReason(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+
+	// CA compromise, certificate hold and the reasons above 5 cannot be requested from ACME CAs
+	for _, value := range []string{"", "caCompromise", "2", "6", "-1", "256"} {
+		if _, err := ParseRevocationReason(value); err == nil {
+			t.Errorf("ParseRevocationReason(%q) did not fail", value)
+		}
+	}
+}
+
+// trustTestServer makes ACME clients created without an HTTP client trust the test server
+func trustTestServer(t *testing.T, server *testACMEServer) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LEGO_CA_CERTIFICATES", path)
+}
+
+// signedWithKey reports whether a JWS request embeds its public key instead of naming an account
+func signedWithKey(t *testing.T, r *http.Request) bool {
+	var body struct {
+		Protected string `json:"protected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("invalid JWS body: %v", err)
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(body.Protected)
+	if err != nil {
+		t.Errorf("invalid JWS header: %v", err)
+		return false
+	}
+	var header map[string]any
+	json.Unmarshal(data, &header)
+	return header["jwk"] != nil
+}
+
+func TestRevokerFindCertificateName(t *testing.T) {
+	ctx := context.Background()
+	_, client := newFakeKeyVault(t)
+	store := NewKeyVaultStore(client, DefaultImportPolicy(), "")
+
+	web := &zones.Target{Group: "web", Records: []*zones.Record{{FQDN: "www.example.com"}, {FQDN: "shop.example.com"}}}
+	api := &zones.Target{Records: []*zones.Record{{FQDN: "api.example.com"}}}
+	shop := &zones.Target{Records: []*zones.Record{{FQDN: "shop.example.com"}}}
+	for _, target := range []*zones.Target{web, api} {
+		if err := store.Put(ctx, target, issuedCertificate(t, target.Domains()...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revoker := NewRevoker(nil, client, nil, MustNewNamer(""), "")
+	for fqdn, want := range map[string]string{
+		"Shop.Example.com.": CertificateName(web),
+		"api.example.com":   CertificateName(api),
+		"mail.example.com":  "cert-mail-example-com",
+		"*.example.com":     "cert-example-com-wildcard",
+	} {
+		if got, err := revoker.FindCertificateName(ctx, fqdn); err != nil || got != want {
+			t.Errorf("FindCertificateName(%q) = %q, %v, want %q", fqdn, got, err, want)
+		}
+	}
+
+	if err := store.Put(ctx, shop, issuedCertificate(t, "shop.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := revoker.FindCertificateName(ctx, "shop.example.com"); err == nil {
+		t.Errorf("FindCertificateName() of a name covered twice = %q, want an error", got)
+	}
+}
+
+func TestRevokerRevoke(t *testing.T) {
+	ctx := context.Background()
+	vault, client := newFakeKeyVault(t)
+	target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+	issued := issuedCertificate(t, "www.example.com")
+	if err := NewKeyVaultStore(client, DefaultImportPolicy(), "").Put(ctx, target, issued); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestACMEServer(t)
+	var revocations int
+	server.handle("/revoke-cert", func(w http.ResponseWriter, r *http.Request) {
+		if signedWithKey(t, r) {
+			t.Error("revocation signed with the certificate key, want the account")
+		}
+		revocations++
+	})
+
+	revoker := NewRevoker(server.client(t), client, vault.secretsClient(t), MustNewNamer(""), server.URL+"/directory")
+	revoked, err := revoker.Revoke(ctx, CertificateName(target), legoACME.CRLReasonSuperseded)
+	if err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if revocations != 1 || !revoked.Leaf.Equal(issued.Leaf()) {
+		t.Errorf("Revoke() = %+v after %d revocations, want the stored leaf revoked once", revoked, revocations)
+	}
+
+	if err := revoker.Disable(ctx, revoked.Name, revoked.Version); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if enabled := vault.version(revoked.Name, revoked.Version).Attributes.Enabled; *enabled {
+		t.Error("Disable() left the version enabled")
+	}
+}
+
+func TestRevokerRevokeWithCertificateKey(t *testing.T) {
+	ctx := context.Background()
+	server := newTestACMEServer(t)
+	trustTestServer(t, server)
+	var keyRevocations int
+	server.handle("/revoke-cert", func(w http.ResponseWriter, r *http.Request) {
+		if !signedWithKey(t, r) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"type":"urn:ietf:params:acme:error:unauthorized","detail":"account did not issue the certificate"}`))
+			return
+		}
+		keyRevocations++
+	})
+
+	for _, exportable := range []bool{true, false} {
+		vault, client := newFakeKeyVault(t)
+		target := &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com"}}}
+		policy := &ImportPolicy{Exportable: exportable, ContentType: ContentTypePKCS12}
+		if err := NewKeyVaultStore(client, policy, "").Put(ctx, target, issuedCertificate(t, "www.example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		revoker := NewRevoker(server.client(t), client, vault.secretsClient(t), MustNewNamer(""), server.URL+"/directory")
+		_, err := revoker.Revoke(ctx, CertificateName(target), legoACME.CRLReasonKeyCompromise)
+		if exportable && err != nil {
+			t.Errorf("Revoke() with exportable key error = %v", err)
+		}
+		if !exportable && (err == nil || !strings.Contains(err.Error(), "certificate key unavailable")) {
+			t.Errorf("Revoke() without exportable key error = %v, want the key to be unavailable", err)
+		}
+	}
+	if keyRevocations != 1 {
+		t.Errorf("%d revocations with the certificate key, want 1", keyRevocations)
+	}
+}
@@ -0,0 +1,38 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// DiffSANs compares the DNS names of a DER-encoded certificate with the desired domains.
+// It returns the domains missing from the certificate and the certificate names no longer desired.
+func DiffSANs(der []byte, domains []string) (missing []string, extra []string, err error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	present := make(map[string]bool, len(cert.DNSNames))
+	for _, name := range cert.DNSNames {
+		present[strings.ToLower(name)] = true
+	}
+
+	desired := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		desired[domain] = true
+		if !present[domain] {
+			missing = append(missing, domain)
+		}
+	}
+
+	for _, name := range cert.DNSNames {
+		if !desired[strings.ToLower(name)] {
+			extra = append(extra, name)
+		}
+	}
+
+	return missing, extra, nil
+}
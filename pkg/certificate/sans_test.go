@@ -0,0 +1,31 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiffSANs(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := selfSignedDER(t, key, time.Now().Add(24*time.Hour), "www.example.com", "Example.com")
+
+	missing, extra, err := DiffSANs(der, []string{"www.example.com", "example.com"})
+	if err != nil || missing != nil || extra != nil {
+		t.Errorf("DiffSANs() of matching names = %v, %v, %v, want no differences", missing, extra, err)
+	}
+
+	missing, extra, err = DiffSANs(der, []string{"WWW.example.com", "api.example.com"})
+	if err != nil {
+		t.Fatalf("DiffSANs() error = %v", err)
+	}
+	if !slices.Equal(missing, []string{"api.example.com"}) || !slices.Equal(extra, []string{"Example.com"}) {
+		t.Errorf("DiffSANs() = missing %v, extra %v, want missing [api.example.com], extra [Example.com]", missing, extra)
+	}
+}
@@ -0,0 +1,102 @@
+package certificate
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// Store kinds selectable with the stores setting
+const (
+	StoreKeyVault   = "keyvault"
+	StoreFilesystem = "filesystem"
+	StoreLego       = "lego"
+)
+
+// Store persists issued certificates
+type Store interface {
+	// Name identifies the store in log messages
+	Name() string
+	// Get returns the certificate currently stored for the target, or nil when there is none
+	Get(ctx context.Context, target *zones.Target) (*StoredCertificate, error)
+	// Put stores a newly issued certificate for the target
+	Put(ctx context.Context, target *zones.Target, cert *IssuedCertificate) error
+}
+
+// CSRIssuer is implemented by stores that generate the private key themselves. The handler
+// obtains a certificate for the CSR they create and completes the request with Put, passing
+// an IssuedCertificate without a private key.
+type CSRIssuer interface {
+	Store
+	// CreateCSR starts a pending certificate request for the target and returns its CSR
+	CreateCSR(ctx context.Context, target *zones.Target, keyType certcrypto.KeyType) (*x509.CertificateRequest, error)
+	// CancelCSR abandons a pending certificate request of the target, if there is one
+	CancelCSR(ctx context.Context, target *zones.Target) error
+}
+
+// StoredCertificate describes the current certificate held by a store
+type StoredCertificate struct {
+	Leaf    *x509.Certificate
+	Expires time.Time
+}
+
+// IssuedCertificate holds a freshly obtained certificate with its chain and, unless the key
+// was generated by a CSRIssuer, its private key
+type IssuedCertificate struct {
+	Resource   *certificate.Resource
+	PrivateKey crypto.PrivateKey
+	KeyType    certcrypto.KeyType
+	Chain      []*x509.Certificate
+	IssuedAt   time.Time
+}
+
+// Leaf returns the end-entity certificate
+func (c *IssuedCertificate) Leaf() *x509.Certificate {
+	return c.Chain[0]
+}
+
+// EncodePFX encodes the private key, leaf and intermediates as an unencrypted PKCS#12 archive
+func (c *IssuedCertificate) EncodePFX() ([]byte, error) {
+	return pkcs12.Modern.Encode(c.PrivateKey, c.Leaf(), c.Chain[1:], "")
+}
+
+// ParseStoreKinds validates a list of store kinds, removing duplicates.
+// Entries may also hold comma-separated lists, as set through environment variables.
+func ParseStoreKinds(values []string) ([]string, error) {
+	var kinds []string
+	for _, value := range values {
+		for _, kind := range strings.Split(value, ",") {
+			if kind = strings.ToLower(strings.TrimSpace(kind)); kind != "" {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	for _, kind := range kinds {
+		switch kind {
+		case StoreKeyVault, StoreFilesystem, StoreLego:
+		default:
+			return nil, fmt.Errorf("unsupported certificate store %q (supported: keyvault, filesystem, lego)", kind)
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			result = append(result, kind)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("at least one certificate store is required")
+	}
+	return result, nil
+}
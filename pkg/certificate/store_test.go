@@ -0,0 +1,32 @@
+package certificate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseStoreKinds(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{values: []string{"keyvault"}, want: []string{"keyvault"}},
+		{values: []string{"filesystem", "KeyVault", "filesystem"}, want: []string{"filesystem", "keyvault"}},
+		{values: []string{"keyvault, lego ,filesystem"}, want: []string{"keyvault", "lego", "filesystem"}},
+		{values: []string{"keyvault", "s3"}, wantErr: true},
+		{values: []string{" , "}, wantErr: true},
+		{values: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStoreKinds(tt.values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStoreKinds(%q) error = %v, want error %t", tt.values, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseStoreKinds(%q) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
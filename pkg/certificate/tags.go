@@ -0,0 +1,74 @@
+package certificate
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+// Key Vault certificate tags describing where a certificate came from
+const (
+	TagFQDN         = "fqdn"
+	TagZone         = "zone"
+	TagRecordName   = "record-name"
+	TagRecordType   = "record-type"
+	TagGroup        = "acme-group"
+	TagDirectoryURL = "acme-directory"
+	TagIssuer       = "issuer"
+	TagCertURL      = "acme-cert-url"
+	TagKeyType      = "key-type"
+	TagVersion      = "provisioner-version"
+	TagIssuedAt     = "issued-at"
+)
+
+// maxTagValueLength is the maximum length of a Key Vault tag value
+const maxTagValueLength = 256
+
+// certificateTags builds the Key Vault tags recorded with an issued certificate
+func certificateTags(target *zones.Target, cert *IssuedCertificate, directoryURL string) map[string]*string {
+	var zoneNames, recordNames, recordTypes []string
+	for _, record := range target.Records {
+		zoneNames = appendUnique(zoneNames, record.Zone)
+		recordNames = appendUnique(recordNames, record.Name)
+		recordTypes = appendUnique(recordTypes, record.Type)
+	}
+
+	tags := map[string]string{
+		TagFQDN:         strings.Join(target.Domains(), ","),
+		TagZone:         strings.Join(zoneNames, ","),
+		TagRecordName:   strings.Join(recordNames, ","),
+		TagRecordType:   strings.Join(recordTypes, ","),
+		TagGroup:        target.Group,
+		TagKeyType:      KeyTypeName(cert.KeyType),
+		TagVersion:      utilities.GetVersion(),
+		TagIssuedAt:     cert.IssuedAt.UTC().Format(time.RFC3339),
+		TagIssuer:       cert.Leaf().Issuer.CommonName,
+		TagDirectoryURL: directoryURL,
+	}
+	if cert.Resource != nil {
+		tags[TagCertURL] = cert.Resource.CertURL
+	}
+
+	result := make(map[string]*string, len(tags))
+	for name, value := range tags {
+		if value == "" {
+			continue
+		}
+		if len(value) > maxTagValueLength {
+			value = value[:maxTagValueLength]
+		}
+		result[name] = &value
+	}
+	return result
+}
+
+// appendUnique appends a value to a list unless it is already present
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -0,0 +1,67 @@
+package certificate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+func TestCertificateTags(t *testing.T) {
+	target := &zones.Target{
+		Group: "web",
+		Records: []*zones.Record{
+			{FQDN: "www.example.com", Zone: "example.com", Name: "www", Type: "CNAME"},
+			{FQDN: "shop.example.com", Zone: "example.com", Name: "shop", Type: "A"},
+		},
+	}
+	issued := issuedCertificate(t, "www.example.com", "shop.example.com")
+	issued.IssuedAt = time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	issued.Resource = &certificate.Resource{CertURL: "https://acme.example/cert/1"}
+
+	tags := certificateTags(target, issued, "https://acme.example/directory")
+
+	want := map[string]string{
+		TagFQDN:         "www.example.com,shop.example.com",
+		TagZone:         "example.com",
+		TagRecordName:   "www,shop",
+		TagRecordType:   "CNAME,A",
+		TagGroup:        "web",
+		TagDirectoryURL: "https://acme.example/directory",
+		TagIssuer:       "Test Intermediate",
+		TagCertURL:      "https://acme.example/cert/1",
+		TagKeyType:      "ec256",
+		TagIssuedAt:     "2030-01-02T02:04:05Z",
+	}
+	for name, value := range want {
+		if got := tags[name]; got == nil || *got != value {
+			t.Errorf("tag %s = %v, want %q", name, got, value)
+		}
+	}
+	if tags[TagVersion] == nil {
+		t.Errorf("tag %s is missing", TagVersion)
+	}
+}
+
+func TestCertificateTagsOmitsEmptyAndTruncates(t *testing.T) {
+	var records []*zones.Record
+	for i := 0; i < 40; i++ {
+		name := strings.Repeat("a", 10) + string(rune('a'+i%26)) + strings.Repeat("b", i/26)
+		records = append(records, &zones.Record{FQDN: name + ".example.com", Zone: "example.com", Name: name, Type: "A"})
+	}
+	target := &zones.Target{Records: records}
+
+	tags := certificateTags(target, issuedCertificate(t, "www.example.com"), "")
+
+	for _, name := range []string{TagGroup, TagDirectoryURL, TagCertURL} {
+		if value, ok := tags[name]; ok {
+			t.Errorf("tag %s = %q, want it omitted", name, *value)
+		}
+	}
+	if got := len(*tags[TagFQDN]); got != maxTagValueLength {
+		t.Errorf("length of tag %s = %d, want %d", TagFQDN, got, maxTagValueLength)
+	}
+}
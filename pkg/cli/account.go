@@ -0,0 +1,345 @@
+package cli
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// createAccountCommand creates the account command group
+func (c *Commands) createAccountCommand() *cobra.Command {
+	var accountCmd = &cobra.Command{
+		Use:   "account",
+		Short: "Manage the ACME account",
+		Long: `Show, register, update, rotate the key of, or deactivate the ACME account of an email address.
+Accounts are kept in the lego-compatible account storage, separately for every ACME server.`,
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the ACME account registration",
+		Run: func(cmd *cobra.Command, args []string) {
+			c.showAccount()
+		},
+	}
+	addAccountFlags(showCmd, nil)
+
+	registerCmd := &cobra.Command{
+		Use:   "register",
+		Short: "Register a new ACME account",
+		Run: func(cmd *cobra.Command, args []string) {
+			c.registerAccount()
+		},
+	}
+	registerCmd.Flags().StringP("key-type", "k", "ec256", "Account key type (rsa2048, rsa3072, rsa4096, ec256, ec384), used when no account key exists yet")
+	registerCmd.Flags().Bool("agree-tos", false, "Agree to the terms of service of the ACME server (required)")
+	registerCmd.Flags().String("eab-kid", "", "External account binding key ID, for ACME servers that require EAB (e.g. ZeroSSL, Google)")
+	registerCmd.Flags().String("eab-hmac", "", "External account binding HMAC key (base64url encoded)")
+	registerCmd.Flags().String("eab-hmac-secret", "", "Name of the Key Vault secret holding the EAB HMAC key, used when --eab-hmac is not set")
+	addAccountFlags(registerCmd, map[string]string{
+		"account-key-type":  "key-type",
+		"account-agree-tos": "agree-tos",
+		"eab-kid":           "eab-kid",
+		"eab-hmac":          "eab-hmac",
+		"eab-hmac-secret":   "eab-hmac-secret",
+	})
+
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Change the contact email addresses of the ACME account",
+		Run: func(cmd *cobra.Command, args []string) {
+			c.updateAccount()
+		},
+	}
+	updateCmd.Flags().StringSlice("contact", nil, "Contact email address (can be used multiple times, required)")
+	addAccountFlags(updateCmd, map[string]string{
+		"account-contacts": "contact",
+	})
+
+	keyRolloverCmd := &cobra.Command{
+		Use:   "key-rollover",
+		Short: "Replace the ACME account key",
+		Run: func(cmd *cobra.Command, args []string) {
+			c.rolloverAccountKey()
+		},
+	}
+	keyRolloverCmd.Flags().StringP("key-type", "k", "", "Type of the new account key (rsa2048, rsa3072, rsa4096, ec256, ec384); defaults to the type of the current key")
+	addAccountFlags(keyRolloverCmd, map[string]string{
+		"account-key-type": "key-type",
+	})
+
+	deactivateCmd := &cobra.Command{
+		Use:   "deactivate",
+		Short: "Deactivate the ACME account (cannot be undone)",
+		Run: func(cmd *cobra.Command, args []string) {
+			c.deactivateAccount()
+		},
+	}
+	deactivateCmd.Flags().Bool("yes", false, "Confirm the deactivation (required)")
+	addAccountFlags(deactivateCmd, map[string]string{
+		"account-confirm": "yes",
+	})
+
+	accountCmd.AddCommand(showCmd)
+	accountCmd.AddCommand(registerCmd)
+	accountCmd.AddCommand(updateCmd)
+	accountCmd.AddCommand(keyRolloverCmd)
+	accountCmd.AddCommand(deactivateCmd)
+	return accountCmd
+}
+
+// addAccountFlags adds the flags selecting the account and its ACME server, and binds them
+// together with the command specific bindings (key: flag name) when the command runs
+func addAccountFlags(cmd *cobra.Command, bindings map[string]string) {
+	cmd.Flags().StringP("email", "e", "", "Email address of the ACME account (required)")
+	cmd.Flags().Bool("staging", true, "Use Let's Encrypt staging environment (ignored when --acme-server is set)")
+	cmd.Flags().String("acme-server", "", acmeServerHelp())
+	cmd.Flags().StringSlice("ca-bundle", nil, "PEM file(s) with additional CA certificates to trust for the ACME server (can be used multiple times)")
+	cmd.Flags().String("account-storage", acme.AccountStorageFilesystem, "ACME account storage: filesystem or keyvault")
+	cmd.Flags().String("lego-path", "", "lego root directory for ACME accounts and the lego certificate store (default: ~/.lego)")
+
+	all := map[string]string{
+		"email":           "email",
+		"staging":         "staging",
+		"acme-server":     "acme-server",
+		"acme-ca-bundle":  "ca-bundle",
+		"account-storage": "account-storage",
+		"lego-path":       "lego-path",
+	}
+	for key, flag := range bindings {
+		all[key] = flag
+	}
+	bindFlagsOnRun(cmd, all)
+}
+
+// selectedAccount identifies the ACME account a command operates on
+type selectedAccount struct {
+	email        string
+	serverURL    string
+	store        acme.AccountStore
+	azureClients *azure.Clients
+}
+
+// selectAccount returns the account of the email address and ACME server settings, in the
+// configured account storage. Azure clients are only created when Key Vault is needed.
+func selectAccount(ctx context.Context) *selectedAccount {
+	email := viper.GetString("email")
+	if email == "" {
+		log.Fatalf("Email address not specified.")
+	}
+
+	serverURL, err := acmeServerURL()
+	if err != nil {
+		log.Fatalf("Invalid ACME server: %v", err)
+	}
+
+	storage, err := selectedAccountStorage()
+	if err != nil {
+		log.Fatalf("Invalid account storage: %v", err)
+	}
+
+	var azureClients *azure.Clients
+	if storage == acme.AccountStorageKeyVault || viper.GetString("eab-hmac-secret") != "" {
+		vaultURL := viper.GetString("key-vault-url")
+		if vaultURL == "" {
+			log.Fatalf("AZURE_KEY_VAULT_URL environment variable is required")
+		}
+		azureClients, err = azure.NewClients(viper.GetString("subscription"), vaultURL)
+		if err != nil {
+			log.Fatalf("Failed to create Azure clients: %v", err)
+		}
+	}
+
+	store, err := createAccountStore(ctx, email, serverURL, azureClients)
+	if err != nil {
+		log.Fatalf("Failed to create ACME account storage: %v", err)
+	}
+
+	return &selectedAccount{
+		email:        email,
+		serverURL:    serverURL,
+		store:        store,
+		azureClients: azureClients,
+	}
+}
+
+// loadRegistered loads the existing account, failing when it is not registered
+func (a *selectedAccount) loadRegistered(ctx context.Context) *types.AcmeUser {
+	user, err := acme.LoadExistingAccount(ctx, a.store, a.email)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if user.Registration == nil {
+		log.Fatalf("ACME account for %s is not registered, use 'account register'", a.email)
+	}
+	return user
+}
+
+// save writes the account to the account storage
+func (a *selectedAccount) save(ctx context.Context, user *types.AcmeUser) {
+	if err := acme.SaveAccountData(ctx, a.store, user); err != nil {
+		log.Fatalf("ACME account save failed: %v", err)
+	}
+}
+
+// showAccount prints the registration of the account, as known to the ACME server when it can be queried
+func (c *Commands) showAccount() {
+	ctx := context.Background()
+	account := selectAccount(ctx)
+
+	user, err := acme.LoadExistingAccount(ctx, account.store, account.email)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	keyType := "unknown"
+	if accountKeyType, err := acme.PrivateKeyType(user.GetPrivateKey()); err == nil {
+		keyType = certificate.KeyTypeName(accountKeyType)
+	}
+
+	utilities.LogDefault("Account email: %s", user.Email)
+	utilities.LogDefault("Account storage: %s", account.store.Location())
+	utilities.LogDefault("Account key type: %s", keyType)
+
+	if user.Registration == nil {
+		utilities.LogDefault("Registration: none (use 'account register')")
+		return
+	}
+
+	reg := user.Registration
+	if acmeClient, err := acme.NewClient(user, account.serverURL); err != nil {
+		utilities.LogDefault("Registration query failed, showing the stored registration: %v", err)
+	} else if current, err := acmeClient.Registration.QueryRegistration(); err != nil {
+		utilities.LogDefault("Registration query failed, showing the stored registration: %v", err)
+	} else {
+		reg = current
+	}
+
+	utilities.LogDefault("Registration URI: %s", reg.URI)
+	utilities.LogDefault("Status: %s", reg.Body.Status)
+	utilities.LogDefault("Contacts: %s", strings.Join(reg.Body.Contact, ", "))
+	if reg.Body.Orders != "" {
+		utilities.LogVerbose("Orders URL: %s", reg.Body.Orders)
+	}
+	if keyID := acme.ExternalAccountKeyID(user); keyID != "" {
+		utilities.LogDefault("External account binding: %s", keyID)
+	}
+}
+
+// registerAccount registers a new account with the ACME server
+func (c *Commands) registerAccount() {
+	ctx := context.Background()
+
+	keyType, err := certificate.ParseKeyType(viper.GetString("account-key-type"))
+	if err != nil {
+		log.Fatalf("Invalid key type: %v", err)
+	}
+
+	account := selectAccount(ctx)
+	user, err := acme.LoadOrCreateAccount(ctx, account.store, account.email, keyType)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if user.Registration != nil {
+		log.Fatalf("ACME account for %s is already registered: %s", account.email, user.Registration.URI)
+	}
+
+	acmeClient, err := acme.NewClient(user, account.serverURL)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if !viper.GetBool("account-agree-tos") {
+		log.Fatalf("Registration requires agreeing to the terms of service (%s), use --agree-tos", acmeClient.GetToSURL())
+	}
+
+	eab, err := externalAccountBinding(ctx, account.azureClients)
+	if err != nil {
+		log.Fatalf("Invalid external account binding: %v", err)
+	}
+
+	if err := acme.RegisterAccount(user, acmeClient, account.serverURL, eab); err != nil {
+		log.Fatalf("Failed to register ACME account: %v", err)
+	}
+
+	account.save(ctx, user)
+	utilities.LogDefault("ACME account registered: %s", user.Registration.URI)
+}
+
+// updateAccount replaces the contact email addresses of the account
+func (c *Commands) updateAccount() {
+	contacts := viper.GetStringSlice("account-contacts")
+	if len(contacts) == 0 {
+		log.Fatalf("Contact email address not specified.")
+	}
+
+	ctx := context.Background()
+	account := selectAccount(ctx)
+	user := account.loadRegistered(ctx)
+	if err := acme.UpdateAccountContacts(user, account.serverURL, contacts); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	account.save(ctx, user)
+	utilities.LogDefault("ACME account contacts updated: %s", strings.Join(user.Registration.Body.Contact, ", "))
+	if !strings.EqualFold(contacts[0], account.email) {
+		utilities.LogDefault("The account remains stored under %s, keep using --email %s", account.email, account.email)
+	}
+}
+
+// rolloverAccountKey replaces the account key with a new one
+func (c *Commands) rolloverAccountKey() {
+	ctx := context.Background()
+	account := selectAccount(ctx)
+	user := account.loadRegistered(ctx)
+
+	var keyType certcrypto.KeyType
+	var err error
+	if name := viper.GetString("account-key-type"); name != "" {
+		keyType, err = certificate.ParseKeyType(name)
+	} else {
+		keyType, err = acme.PrivateKeyType(user.GetPrivateKey())
+	}
+	if err != nil {
+		log.Fatalf("Invalid key type: %v", err)
+	}
+
+	if err := acme.RolloverAccountKey(ctx, account.store, user, account.serverURL, keyType); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	utilities.LogDefault("ACME account key replaced: email=%s, key_type=%s", account.email, certificate.KeyTypeName(keyType))
+}
+
+// deactivateAccount deactivates the account on the ACME server
+func (c *Commands) deactivateAccount() {
+	if !viper.GetBool("account-confirm") {
+		log.Fatalf("Deactivating an ACME account cannot be undone, confirm with --yes")
+	}
+
+	ctx := context.Background()
+	account := selectAccount(ctx)
+	user := account.loadRegistered(ctx)
+	acmeClient, err := acme.NewClient(user, account.serverURL)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := acme.DeactivateAccount(user, acmeClient); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	account.save(ctx, user)
+	utilities.LogDefault("ACME account deactivated: %s", user.Registration.URI)
+	utilities.LogDefault("Remove the stored account of %s (%s) before registering a new account with this address", account.email, account.store.Location())
+}
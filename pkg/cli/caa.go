@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// CAA record management modes
+const (
+	caaRecordsOff    = "off"
+	caaRecordsRepair = "repair"
+	caaRecordsCreate = "create"
+)
+
+// caaIdentities returns the CA issuer domain names of the caa-identities setting, or the ones
+// advertised in the directory of the ACME server. Entries may be separated by commas or spaces.
+func caaIdentities(serverURL string) ([]string, error) {
+	var identities []string
+	for _, entry := range viper.GetStringSlice("caa-identities") {
+		identities = append(identities, strings.FieldsFunc(strings.ToLower(entry), func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+	}
+	if len(identities) > 0 {
+		return identities, nil
+	}
+	return acme.CAAIdentities(serverURL)
+}
+
+// caaRecordsMode returns the caa-records setting
+func caaRecordsMode() (string, error) {
+	switch mode := strings.ToLower(viper.GetString("caa-records")); mode {
+	case "", caaRecordsOff:
+		return caaRecordsOff, nil
+	case caaRecordsRepair, caaRecordsCreate:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported CAA record management %q (use %s, %s or %s)", mode, caaRecordsOff, caaRecordsRepair, caaRecordsCreate)
+	}
+}
+
+// newCAAPolicy returns the CAA policy of the ACME server and account, or nil when CAA checks are
+// disabled or the CA identities are unknown
+func newCAAPolicy(serverURL, accountURI string) *zones.CAAPolicy {
+	if !viper.GetBool("caa-check") {
+		utilities.LogDefault("CAA check disabled")
+		return nil
+	}
+
+	identities, err := caaIdentities(serverURL)
+	if err != nil {
+		utilities.LogDefault("CAA check disabled, the CA identities could not be read: %v", err)
+		return nil
+	}
+	if len(identities) == 0 {
+		utilities.LogDefault("CAA check disabled, the ACME server advertises no CAA identities (set caa-identities)")
+		return nil
+	}
+
+	utilities.LogDefault("CAA identities: %s", strings.Join(identities, ", "))
+	return &zones.CAAPolicy{Identities: identities, AccountURI: accountURI}
+}
+
+// storedAccountURI returns the registration URI of the stored ACME account of an email address without
+// migrating or registering accounts, or an empty string when there is none
+func storedAccountURI(ctx context.Context, email, serverURL string, azureClients *azure.Clients) string {
+	var store acme.AccountStore
+	var err error
+	if kind, _ := selectedAccountStorage(); kind == acme.AccountStorageKeyVault && viper.GetString("key-vault-url") != "" {
+		store, err = acme.NewKeyVaultAccountStorage(azureClients.KVSecret, email, serverURL)
+	} else {
+		var rootPath string
+		if rootPath, err = legoPath(); err == nil {
+			store, err = acme.NewAccountStorage(rootPath, email, serverURL)
+		}
+	}
+	if err != nil {
+		utilities.LogVerbose("ACME account storage unavailable, CAA accounturi parameters are not matched: %v", err)
+		return ""
+	}
+
+	user, err := acme.LoadExistingAccount(ctx, store, email)
+	if err != nil || user.Registration == nil {
+		utilities.LogVerbose("ACME account not found, CAA accounturi parameters are not matched")
+		return ""
+	}
+	return user.Registration.URI
+}
+
+// caaCheck returns a preflight check verifying that the CAA records of every name of a target permit
+// the CA to issue. With the repair mode, CAA record sets in Azure DNS zones of the subscription that do
+// not list the CA get an issue (or issuewild) property for it; with the create mode, zones without CAA
+// records also get an issue property at their apex.
+func caaCheck(res *resolver.Resolver, policy zones.CAAPolicy, defaultChallenge, mode string, provider *azure.DNSProvider) certificate.PreflightCheck {
+	created := make(map[string]bool)
+
+	return func(ctx context.Context, target *zones.Target) error {
+		policy := policy
+		if challengeType, err := certificate.ResolveChallengeType(target, defaultChallenge); err == nil {
+			policy.ValidationMethod = challengeType
+		}
+
+		statuses, err := zones.CheckCAAs(ctx, res, target, policy)
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		for _, status := range statuses {
+			switch {
+			case status.Permitted && (status.Name != "" || mode != caaRecordsCreate):
+				utilities.LogVerbose("CAA check passed: domain=%s, %s", status.Domain(), status.Reason)
+			case status.Permitted:
+				zone := recordZone(target, status.FQDN)
+				if created[zone] {
+					continue
+				}
+				if err := addCAAIssuer(ctx, provider, zone, zones.CAATagIssue, policy.Identities[0]); err != nil {
+					utilities.LogDefault("CAA record creation failed: zone=%s, error=%v", zone, err)
+				}
+				created[zone] = true
+			case mode == caaRecordsOff:
+				errs = append(errs, fmt.Errorf("CAA records do not permit issuance for %s: %s (use --caa-records=repair to add the CA)", status.Domain(), status.Reason))
+			case status.Restricted || status.UnknownCritical != "":
+				errs = append(errs, fmt.Errorf("CAA records do not permit issuance for %s: %s (not repaired, change the record set manually)", status.Domain(), status.Reason))
+			default:
+				if err := addCAAIssuer(ctx, provider, status.Name, status.Property, policy.Identities[0]); err != nil {
+					errs = append(errs, fmt.Errorf("CAA records do not permit issuance for %s: %s (repair failed: %v)", status.Domain(), status.Reason, err))
+				}
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// addCAAIssuer adds an issue or issuewild property for a CA to the CAA record set of a name
+func addCAAIssuer(ctx context.Context, provider *azure.DNSProvider, name, tag, identity string) error {
+	zone, err := provider.AddCAARecords(ctx, name, []azure.CAARecord{{Tag: tag, Value: identity}})
+	if err != nil {
+		return err
+	}
+	utilities.LogDefault("CAA record added: name=%s, zone=%s, resource_group=%s, property=%s %q", name, zone.Name, zone.ResourceGroup, tag, identity)
+	return nil
+}
+
+// recordZone returns the zone of the record of a target with the given FQDN
+func recordZone(target *zones.Target, fqdn string) string {
+	for _, record := range target.Records {
+		if strings.EqualFold(record.FQDN, fqdn) {
+			return record.Zone
+		}
+	}
+	return fqdn
+}
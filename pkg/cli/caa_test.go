@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCAARecordsMode(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	for value, want := range map[string]string{"": caaRecordsOff, "off": caaRecordsOff, "Repair": caaRecordsRepair, "create": caaRecordsCreate} {
+		viper.Set("caa-records", value)
+		if got, err := caaRecordsMode(); err != nil || got != want {
+			t.Errorf("caaRecordsMode() of %q = %q, %v, want %q", value, got, err, want)
+		}
+	}
+
+	viper.Set("caa-records", "replace")
+	if _, err := caaRecordsMode(); err == nil {
+		t.Error("caaRecordsMode() accepted an unknown mode")
+	}
+}
+
+func TestNewCAAPolicy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	const accountURI = "https://acme.example.com/acct/1"
+
+	// Configured identities take precedence over the directory of the server, which is not queried
+	viper.Set("caa-check", true)
+	viper.Set("caa-identities", []string{"LetsEncrypt.org, example-ca.com", "other-ca.net"})
+	policy := newCAAPolicy("https://acme.invalid/directory", accountURI)
+	if policy == nil {
+		t.Fatal("newCAAPolicy() = nil, want a policy")
+	}
+	if want := []string{"letsencrypt.org", "example-ca.com", "other-ca.net"}; !slices.Equal(policy.Identities, want) {
+		t.Errorf("policy identities = %v, want %v", policy.Identities, want)
+	}
+	if policy.AccountURI != accountURI {
+		t.Errorf("policy account URI = %q, want %q", policy.AccountURI, accountURI)
+	}
+
+	viper.Set("caa-check", false)
+	if policy := newCAAPolicy("https://acme.invalid/directory", accountURI); policy != nil {
+		t.Errorf("newCAAPolicy() with the check disabled = %+v, want nil", policy)
+	}
+}
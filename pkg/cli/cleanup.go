@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+// defaultChallengeMaxAge is the age after which a challenge record is no longer tied to an in-flight order
+const defaultChallengeMaxAge = time.Hour
+
+// Classifications of challenge record sets by the cleanup
+const (
+	challengeInUse   = "in use"
+	challengeStale   = "stale"
+	challengeUnknown = "unknown age"
+)
+
+// createCleanupChallengesCommand creates the cleanup-challenges command
+func (c *Commands) createCleanupChallengesCommand() *cobra.Command {
+	var cleanupCmd = &cobra.Command{
+		Use:   "cleanup-challenges",
+		Short: "Remove stale DNS-01 challenge records",
+		Long: `Scan Azure DNS zones for _acme-challenge TXT record sets left behind by interrupted runs or failed cleanups, and remove them.
+Record sets written by the provisioner are removed once they are older than --max-age. Other challenge record sets may belong to an order of another ACME client; they are reported and only removed with --remove-unknown.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			c.runCleanupChallenges()
+		},
+	}
+
+	cleanupCmd.Flags().StringSliceP("zones", "z", nil, "DNS zone(s) to scan for challenge records (can be used multiple times). If omitted, all zones in the resource group will be scanned")
+	cleanupCmd.Flags().StringP("subscription", "s", "", "Azure subscription ID")
+	cleanupCmd.Flags().StringP("resource-group", "g", "", "Azure resource group name")
+	cleanupCmd.Flags().String("max-age", "", "Age after which a challenge record is considered stale, e.g. 30m (default: 1h)")
+	cleanupCmd.Flags().Bool("dry-run", false, "List the stale challenge records without removing them")
+	cleanupCmd.Flags().Bool("remove-unknown", false, "Also remove challenge records not written by the provisioner, whose age is unknown (they may belong to orders of other ACME clients)")
+	cleanupCmd.Flags().String("discovery", discoveryResourceGroup, "Zone discovery: resource-group (zones of --resource-group) or resource-graph (zones of the discovery subscriptions and management groups)")
+	cleanupCmd.Flags().StringSlice("discovery-subscription", nil, "Subscription searched in resource-graph discovery mode (can be used multiple times, default: --subscription)")
+	cleanupCmd.Flags().StringSlice("discovery-management-group", nil, "Management group searched in resource-graph discovery mode (can be used multiple times)")
+
+	bindFlagsOnRun(cleanupCmd, map[string]string{
+		"zones":                       "zones",
+		"subscription":                "subscription",
+		"resource-group":              "resource-group",
+		"challenge-max-age":           "max-age",
+		"cleanup-dry-run":             "dry-run",
+		"cleanup-remove-unknown":      "remove-unknown",
+		"discovery":                   "discovery",
+		"discovery-subscriptions":     "discovery-subscription",
+		"discovery-management-groups": "discovery-management-group",
+	})
+
+	return cleanupCmd
+}
+
+// runCleanupChallenges executes the challenge record cleanup
+func (c *Commands) runCleanupChallenges() {
+	ctx := context.Background()
+
+	zonesList := viper.GetStringSlice("zones")
+	subscriptionId := viper.GetString("subscription")
+	resourceGroupName := viper.GetString("resource-group")
+
+	if subscriptionId == "" {
+		log.Fatalf("Subscription ID not specified.")
+	}
+
+	mode, err := discoveryMode()
+	if err != nil {
+		log.Fatalf("Invalid discovery settings: %v", err)
+	}
+
+	if resourceGroupName == "" && mode == discoveryResourceGroup {
+		log.Fatalf("Resource Group Name not specified.")
+	}
+
+	maxAge, err := durationSetting("challenge-max-age", defaultChallengeMaxAge)
+	if err != nil {
+		log.Fatalf("Invalid challenge cleanup settings: %v", err)
+	}
+
+	// The record sets client does not use Key Vault
+	azureClients, err := azure.NewClients(subscriptionId, viper.GetString("key-vault-url"))
+	if err != nil {
+		log.Fatalf("Failed to create Azure clients: %v", err)
+	}
+
+	dnsZones, err := discoverZones(ctx, azureClients, mode, zonesList, resourceGroupName)
+	if err != nil {
+		log.Fatalf("Failed to list DNS zones: %v", err)
+	}
+
+	cleanupChallenges(ctx, azureClients, dnsZones, maxAge, viper.GetBool("cleanup-remove-unknown"), viper.GetBool("cleanup-dry-run"))
+}
+
+// classifyChallenge classifies a challenge record set as stale when the provider wrote it more than
+// maxAge ago, in use when it wrote it more recently, or of unknown age when the provider did not write it
+func classifyChallenge(recordSet *azure.ChallengeRecordSet, now time.Time, maxAge time.Duration) (string, time.Duration) {
+	age, known := recordSet.Age(now)
+	switch {
+	case !known:
+		return challengeUnknown, 0
+	case age > maxAge:
+		return challengeStale, age
+	default:
+		return challengeInUse, age
+	}
+}
+
+// cleanupChallenges removes the stale _acme-challenge TXT record sets of the zones, written by the
+// provider more than maxAge ago. Record sets the provider did not write may belong to orders of other
+// ACME clients; they are reported and only removed with removeUnknown. Record sets modified while the
+// cleanup runs are kept.
+func cleanupChallenges(ctx context.Context, azureClients *azure.Clients, dnsZones []azure.DNSZone, maxAge time.Duration, removeUnknown, dryRun bool) {
+	utilities.LogDefault("Challenge cleanup started: zones=%d, max_age=%s, remove_unknown=%t, dry_run=%t", len(dnsZones), maxAge, removeUnknown, dryRun)
+
+	now := time.Now()
+	var found, stale, unknown, removed, failed int
+	for _, zone := range dnsZones {
+		client, err := azureClients.RecordSets(zone.Subscription)
+		if err != nil {
+			utilities.LogDefault("Challenge record listing failed: zone=%s, error=%v", zone.Name, err)
+			failed++
+			continue
+		}
+
+		recordSets, err := azure.ListChallengeRecordSets(ctx, client, zone)
+		if err != nil {
+			utilities.LogDefault("Challenge record listing failed: zone=%s, error=%v", zone.Name, err)
+			failed++
+			continue
+		}
+
+		for _, recordSet := range recordSets {
+			found++
+
+			switch state, age := classifyChallenge(recordSet, now, maxAge); state {
+			case challengeUnknown:
+				unknown++
+				if !removeUnknown {
+					utilities.LogDefault("Challenge record of unknown age kept: fqdn=%s, values=%d, reason=not written by the provisioner (use --remove-unknown to remove it)", recordSet.FQDN, recordSet.Values)
+					continue
+				}
+				utilities.LogDefault("Challenge record of unknown age: fqdn=%s, values=%d, reason=not written by the provisioner", recordSet.FQDN, recordSet.Values)
+			case challengeStale:
+				utilities.LogDefault("Stale challenge record: fqdn=%s, values=%d, age=%s", recordSet.FQDN, recordSet.Values, age.Round(time.Second))
+			default:
+				utilities.LogVerbose("Challenge record in use: fqdn=%s, age=%s", recordSet.FQDN, age.Round(time.Second))
+				continue
+			}
+			stale++
+
+			if dryRun {
+				continue
+			}
+			if err := azure.DeleteChallengeRecordSet(ctx, client, recordSet); err != nil {
+				utilities.LogDefault("Challenge record removal failed: %v", err)
+				failed++
+				continue
+			}
+			utilities.LogDefault("Challenge record removed: fqdn=%s", recordSet.FQDN)
+			removed++
+		}
+	}
+
+	utilities.LogDefault("Challenge cleanup summary: zones=%d, challenge_records=%d, stale=%d, unknown_age=%d, removed=%d, kept=%d, failed=%d, dry_run=%t",
+		len(dnsZones), found, stale, unknown, removed, found-stale, failed, dryRun)
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+func TestClassifyChallenge(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Record sets without the update time were not written by the provider and are never stale
+	unknown := &azure.ChallengeRecordSet{FQDN: "_acme-challenge.www.example.com"}
+	if state, _ := classifyChallenge(unknown, now, time.Hour); state != challengeUnknown {
+		t.Errorf("classifyChallenge() of a foreign record set = %q, want %q", state, challengeUnknown)
+	}
+
+	for updated, want := range map[time.Duration]string{
+		10 * time.Minute: challengeInUse,
+		time.Hour:        challengeInUse,
+		2 * time.Hour:    challengeStale,
+	} {
+		recordSet := &azure.ChallengeRecordSet{FQDN: "_acme-challenge.www.example.com", Updated: now.Add(-updated)}
+		state, age := classifyChallenge(recordSet, now, time.Hour)
+		if state != want || age != updated {
+			t.Errorf("classifyChallenge() of a record set written %s ago = %q, %s, want %q", updated, state, age, want)
+		}
+	}
+}
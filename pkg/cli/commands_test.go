@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// prepareCommand parses the arguments of a subcommand and binds its flags as when it runs
+func prepareCommand(t *testing.T, name string, args ...string) *cobra.Command {
+	t.Helper()
+
+	viper.Reset()
+	root := NewCommands().CreateRootCommand()
+	cmd, _, err := root.Find([]string{name})
+	if err != nil {
+		t.Fatalf("command %s not found: %v", name, err)
+	}
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatalf("failed to parse %v: %v", args, err)
+	}
+	if cmd.PreRun != nil {
+		cmd.PreRun(cmd, nil)
+	}
+	return cmd
+}
+
+func TestFlagBindingsOfSharedKeys(t *testing.T) {
+	for _, name := range []string{"run", "list"} {
+		t.Run(name, func(t *testing.T) {
+			prepareCommand(t, name, "--zones", "example.com", "--zones", "example.net", "--subscription", "sub", "--staging=false", "--expire-threshold", "21")
+
+			if got := viper.GetStringSlice("zones"); !slices.Equal(got, []string{"example.com", "example.net"}) {
+				t.Errorf("zones = %v", got)
+			}
+			if got := viper.GetString("subscription"); got != "sub" {
+				t.Errorf("subscription = %q", got)
+			}
+			if viper.GetBool("staging") {
+				t.Error("staging = true, want the --staging=false flag")
+			}
+			if got := viper.GetInt("expire-threshold"); got != 21 {
+				t.Errorf("expire-threshold = %d, want 21", got)
+			}
+		})
+	}
+
+	prepareCommand(t, "create-sp", "--subscription-id", "sp-sub")
+	if got := viper.GetString("subscription"); got != "sp-sub" {
+		t.Errorf("create-sp subscription = %q, want sp-sub", got)
+	}
+}
+
+func TestFlagBindingsOfRunOnlyKeys(t *testing.T) {
+	prepareCommand(t, "run", "--key-type", "ec256", "--store", "filesystem", "--store", "lego")
+	if got := viper.GetString("key-type"); got != "ec256" {
+		t.Errorf("key-type = %q, want ec256", got)
+	}
+	if got := viper.GetStringSlice("stores"); !slices.Equal(got, []string{"filesystem", "lego"}) {
+		t.Errorf("stores = %v, want [filesystem lego]", got)
+	}
+
+	// A command without the flag leaves the key to the environment and configuration
+	prepareCommand(t, "delete-sp")
+	if got := viper.GetString("key-type"); got != "" {
+		t.Errorf("key-type of delete-sp = %q, want it unset", got)
+	}
+}
+
+func TestFlagBindingsOfLegoPath(t *testing.T) {
+	for _, name := range []string{"run", "list", "revoke"} {
+		prepareCommand(t, name, "--lego-path", "/data/lego")
+		if got := viper.GetString("lego-path"); got != "/data/lego" {
+			t.Errorf("%s: lego-path = %q, want /data/lego", name, got)
+		}
+	}
+}
+
+func TestRevokeBindsRunFlagsForReissue(t *testing.T) {
+	cmd := prepareCommand(t, "revoke", "--fqdn", "www.example.com", "--reissue", "--store", "filesystem", "--key-type", "ec384", "--challenge", "http-01", "-z", "example.com")
+
+	if !viper.GetBool("revoke-reissue") || viper.GetString("revoke-fqdn") != "www.example.com" {
+		t.Errorf("revoke flags not bound: reissue=%t, fqdn=%q", viper.GetBool("revoke-reissue"), viper.GetString("revoke-fqdn"))
+	}
+	if got := viper.GetStringSlice("stores"); !slices.Equal(got, []string{"filesystem"}) {
+		t.Errorf("stores = %v, want [filesystem]", got)
+	}
+	if viper.GetString("key-type") != "ec384" || viper.GetString("challenge") != "http-01" {
+		t.Errorf("key-type, challenge = %q, %q, want the flags", viper.GetString("key-type"), viper.GetString("challenge"))
+	}
+	// The flags revoke defines itself keep their own help
+	if usage := cmd.Flags().Lookup("zones").Usage; !strings.Contains(usage, "record of the certificate") {
+		t.Errorf("zones flag usage = %q, want the revoke flag", usage)
+	}
+}
@@ -36,6 +36,18 @@ func (c *Commands) createSPCommand() *cobra.Command {
 	createSPCmd.MarkFlagRequired("tenant-id")
 	createSPCmd.MarkFlagRequired("subscription-id")
 
+	bindFlagsOnRun(createSPCmd, map[string]string{
+		"sp-name":           "name",
+		"azure-tenant-id":   "tenant-id",
+		"subscription":      "subscription-id",
+		"resource-group":    "resource-group",
+		"kv-name":           "kv-name",
+		"kv-resource-group": "kv-resource-group",
+		"sp-no-roles":       "no-roles",
+		"sp-use-cert-auth":  "use-cert-auth",
+		"shell":             "shell",
+	})
+
 	return createSPCmd
 }
 
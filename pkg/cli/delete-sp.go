@@ -34,6 +34,12 @@ This command will:
 	cmd.MarkFlagRequired("client-id")
 	cmd.MarkFlagRequired("subscription-id")
 
+	bindFlagsOnRun(cmd, map[string]string{
+		"delete-sp-client-id": "client-id",
+		"azure-tenant-id":     "tenant-id",
+		"subscription":        "subscription-id",
+	})
+
 	return cmd
 }
 
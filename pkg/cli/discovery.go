@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+// Record discovery modes
+const (
+	discoveryResourceGroup = "resource-group"
+	discoveryResourceGraph = "resource-graph"
+)
+
+// discoveryMode returns the discovery setting
+func discoveryMode() (string, error) {
+	switch mode := strings.ToLower(viper.GetString("discovery")); mode {
+	case "", discoveryResourceGroup:
+		return discoveryResourceGroup, nil
+	case discoveryResourceGraph:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported discovery mode %q (use %s or %s)", mode, discoveryResourceGroup, discoveryResourceGraph)
+	}
+}
+
+// graphScope returns the subscriptions and management groups searched in resource-graph discovery mode.
+// Without discovery-subscriptions and discovery-management-groups, the subscription setting is searched.
+// Entries may be separated by commas or spaces, e.g. in environment variables.
+func graphScope() azure.GraphScope {
+	scope := azure.GraphScope{
+		Subscriptions:    listSetting("discovery-subscriptions"),
+		ManagementGroups: listSetting("discovery-management-groups"),
+	}
+	if len(scope.Subscriptions) == 0 && len(scope.ManagementGroups) == 0 {
+		scope.Subscriptions = []string{viper.GetString("subscription")}
+	}
+	return scope
+}
+
+// enumerateTargets finds the records marked for ACME processing in the selected discovery mode and
+// calls the processor for each certificate target
+func enumerateTargets(ctx context.Context, enumerator *zones.Enumerator, mode string, zonesList []string, resourceGroupName string, expireThreshold int, prepare zones.PrepareFunc, processor zones.ProcessorFunc) error {
+	if mode == discoveryResourceGraph {
+		return enumerator.DiscoverAndProcess(ctx, graphScope(), zonesList, expireThreshold, prepare, processor)
+	}
+	return enumerator.EnumerateAndProcess(ctx, zonesList, resourceGroupName, expireThreshold, prepare, processor)
+}
+
+// discoverZones returns the DNS zones of the selected discovery mode: the given zones or all zones of
+// the resource group, or the zones of the Resource Graph scope (restricted to the given zone names)
+func discoverZones(ctx context.Context, azureClients *azure.Clients, mode string, zonesList []string, resourceGroupName string) ([]azure.DNSZone, error) {
+	if mode != discoveryResourceGraph {
+		names, err := zones.NewEnumerator(azureClients).ListZones(ctx, zonesList, resourceGroupName)
+		if err != nil {
+			return nil, err
+		}
+		dnsZones := make([]azure.DNSZone, 0, len(names))
+		for _, name := range names {
+			dnsZones = append(dnsZones, azure.DNSZone{Name: name, ResourceGroup: resourceGroupName})
+		}
+		return dnsZones, nil
+	}
+
+	graphZones, err := azure.QueryDNSZones(ctx, azureClients.ResourceGraph, graphScope())
+	if err != nil {
+		return nil, err
+	}
+	var dnsZones []azure.DNSZone
+	for _, graphZone := range graphZones {
+		if len(zonesList) == 0 || containsFold(zonesList, graphZone.Zone.Name) {
+			dnsZones = append(dnsZones, graphZone.Zone)
+		}
+	}
+	return dnsZones, nil
+}
+
+// listSetting returns a list setting whose entries may also be separated by commas or spaces
+func listSetting(key string) []string {
+	var values []string
+	for _, entry := range viper.GetStringSlice(key) {
+		values = append(values, strings.FieldsFunc(entry, func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+	}
+	return values
+}
+
+// containsFold reports whether a list contains a value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDiscoveryMode(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	for value, want := range map[string]string{"": discoveryResourceGroup, "resource-group": discoveryResourceGroup, "Resource-Graph": discoveryResourceGraph} {
+		viper.Set("discovery", value)
+		if got, err := discoveryMode(); err != nil || got != want {
+			t.Errorf("discoveryMode() of %q = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	viper.Set("discovery", "subscription")
+	if _, err := discoveryMode(); err == nil {
+		t.Error("discoveryMode() accepted an unknown mode")
+	}
+}
+
+func TestGraphScope(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	// Without a discovery scope, the subscription of the other clients is searched
+	viper.Set("subscription", "sub-1")
+	if scope := graphScope(); !slices.Equal(scope.Subscriptions, []string{"sub-1"}) || scope.ManagementGroups != nil {
+		t.Errorf("graphScope() = %+v, want the subscription setting", scope)
+	}
+
+	// Entries of environment variables are separated by commas or spaces
+	viper.Set("discovery-subscriptions", []string{"sub-2,sub-3 sub-4"})
+	viper.Set("discovery-management-groups", "platform, landing-zones")
+	scope := graphScope()
+	if !slices.Equal(scope.Subscriptions, []string{"sub-2", "sub-3", "sub-4"}) {
+		t.Errorf("graphScope() subscriptions = %v", scope.Subscriptions)
+	}
+	if !slices.Equal(scope.ManagementGroups, []string{"platform", "landing-zones"}) {
+		t.Errorf("graphScope() management groups = %v", scope.ManagementGroups)
+	}
+
+	viper.Set("discovery-subscriptions", nil)
+	if scope := graphScope(); scope.Subscriptions != nil {
+		t.Errorf("graphScope() with management groups only = %+v, want no subscriptions", scope)
+	}
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// DNS-01 propagation check modes
+const (
+	propagationCheckAuthoritative = "authoritative"
+	propagationCheckRecursive     = "recursive"
+	propagationCheckAll           = "all"
+	propagationCheckNone          = "none"
+)
+
+// dnsResolvers returns the recursive nameservers of the dns-resolvers setting. Entries may be
+// separated by commas or spaces, like DNS_RESOLVERS of the container script.
+func dnsResolvers() []string {
+	var resolvers []string
+	for _, entry := range viper.GetStringSlice("dns-resolvers") {
+		resolvers = append(resolvers, strings.FieldsFunc(entry, func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+	}
+	return dns01.ParseNameservers(resolvers)
+}
+
+// dns01Options builds the DNS-01 challenge options from the dns-resolvers and dns-propagation-check settings
+func dns01Options() ([]dns01.ChallengeOption, error) {
+	var options []dns01.ChallengeOption
+
+	if resolvers := dnsResolvers(); len(resolvers) > 0 {
+		utilities.LogDefault("DNS resolvers: %s", strings.Join(resolvers, ", "))
+		options = append(options, dns01.AddRecursiveNameservers(resolvers))
+	}
+
+	check := viper.GetString("dns-propagation-check")
+	switch check {
+	case "", propagationCheckAuthoritative:
+		// lego checks the authoritative nameservers of the zone only by default
+	case propagationCheckRecursive:
+		options = append(options, dns01.DisableAuthoritativeNssPropagationRequirement(), dns01.RecursiveNSsPropagationRequirement())
+	case propagationCheckAll:
+		options = append(options, dns01.RecursiveNSsPropagationRequirement())
+	case propagationCheckNone:
+		options = append(options, dns01.DisableAuthoritativeNssPropagationRequirement())
+	default:
+		return nil, fmt.Errorf("unsupported DNS propagation check %q (use %s, %s, %s or %s)", check,
+			propagationCheckAuthoritative, propagationCheckRecursive, propagationCheckAll, propagationCheckNone)
+	}
+	if check != "" {
+		utilities.LogDefault("DNS propagation check: %s", check)
+	}
+
+	return options, nil
+}
+
+// newDNSProvider creates the DNS-01 provider on the Azure DNS client from the dns-ttl,
+// dns-propagation-timeout and dns-polling-interval settings. In resource-graph discovery mode, the zones
+// of challenge aliases, CAA records and additional names are searched in the whole discovery scope.
+func newDNSProvider(azureClients *azure.Clients, discovery string) (*azure.DNSProvider, error) {
+	ttl := viper.GetInt("dns-ttl")
+	if ttl <= 0 {
+		ttl = azure.DefaultDNSTTL
+	}
+
+	propagationTimeout, err := durationSetting("dns-propagation-timeout", azure.DefaultDNSPropagationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pollingInterval, err := durationSetting("dns-polling-interval", azure.DefaultDNSPollingInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	utilities.LogVerbose("DNS challenge records: ttl=%d, propagation_timeout=%s, polling_interval=%s", ttl, propagationTimeout, pollingInterval)
+	provider := azure.NewDNSProvider(azureClients.DNS, azureClients.DNSZones, ttl, propagationTimeout, pollingInterval)
+	provider.SetSubscriptionClients(azureClients.RecordSets)
+	if discovery == discoveryResourceGraph {
+		provider.SetZoneLister("the resource-graph discovery scope", func(ctx context.Context) ([]azure.DNSZone, error) {
+			graphZones, err := azure.QueryDNSZones(ctx, azureClients.ResourceGraph, graphScope())
+			if err != nil {
+				return nil, err
+			}
+			dnsZones := make([]azure.DNSZone, 0, len(graphZones))
+			for _, graphZone := range graphZones {
+				dnsZones = append(dnsZones, graphZone.Zone)
+			}
+			return dnsZones, nil
+		})
+	} else {
+		provider.SetZoneLister("subscription "+viper.GetString("subscription"), nil)
+	}
+	return provider, nil
+}
+
+// withChallengeZones records the zone of every domain of the targets in the DNS provider before
+// the targets are prepared, so challenge records are written to the zone the domain was found in,
+// or to the Azure DNS zone of its challenge alias. Additional names (acme-extra-sans) in the zone
+// of their record use that zone; the provider looks up the zone of the others (see newDNSProvider).
+func withChallengeZones(provider *azure.DNSProvider, prepare zones.PrepareFunc) zones.PrepareFunc {
+	return func(targets []*zones.Target) []*zones.Target {
+		for _, target := range targets {
+			for _, record := range target.Records {
+				zone := azure.DNSZone{Name: record.Zone, ResourceGroup: record.ResourceGroup, Subscription: record.Subscription}
+				provider.AddDomain(record.FQDN, zone)
+				if alias := record.ChallengeAlias(); alias != "" {
+					provider.AddChallengeAlias(record.FQDN, alias)
+				}
+				for _, san := range record.Settings.ExtraSANs {
+					if name := strings.TrimPrefix(san, "*."); name == record.Zone || strings.HasSuffix(name, "."+record.Zone) {
+						provider.AddDomain(name, zone)
+					}
+				}
+			}
+		}
+		return prepare(targets)
+	}
+}
+
+// withChallengeAliases assigns the challenge aliases of the challenge-aliases setting to the records
+// without acme-challenge-alias metadata before the targets are prepared
+func withChallengeAliases(prepare zones.PrepareFunc) zones.PrepareFunc {
+	aliases := challengeAliases()
+	return func(targets []*zones.Target) []*zones.Target {
+		for _, target := range targets {
+			for _, record := range target.Records {
+				if alias, ok := aliases[strings.ToLower(record.FQDN)]; ok && record.ChallengeAlias() == "" {
+					record.SetChallengeAlias(alias)
+				}
+			}
+		}
+		return prepare(targets)
+	}
+}
+
+// challengeAliases returns the challenge-aliases setting (FQDN: alias). Besides a map in configuration
+// files it accepts a list of fqdn=alias pairs separated by commas or spaces, e.g. from CHALLENGE_ALIASES.
+func challengeAliases() map[string]string {
+	aliases := make(map[string]string)
+	add := func(fqdn, alias string) {
+		fqdn = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(fqdn), "."))
+		if alias = strings.TrimSpace(alias); fqdn != "" && alias != "" {
+			aliases[fqdn] = alias
+		}
+	}
+
+	if value, ok := viper.Get("challenge-aliases").(string); ok {
+		for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if fqdn, alias, found := strings.Cut(pair, "="); found {
+				add(fqdn, alias)
+			}
+		}
+		return aliases
+	}
+
+	for fqdn, alias := range viper.GetStringMapString("challenge-aliases") {
+		add(fqdn, alias)
+	}
+	return aliases
+}
+
+// delegationCheck returns a preflight check verifying the CNAME records of delegated DNS-01 challenges
+func delegationCheck(res *resolver.Resolver, defaultChallenge string) certificate.PreflightCheck {
+	return func(ctx context.Context, target *zones.Target) error {
+		if challengeType, err := certificate.ResolveChallengeType(target, defaultChallenge); err == nil && challengeType != certificate.ChallengeDNS01 {
+			return nil
+		}
+		return zones.CheckChallengeDelegations(ctx, res, target)
+	}
+}
+
+// durationSetting reads a duration setting given as a Go duration (e.g. 5m) or as a number of seconds
+func durationSetting(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(viper.GetString(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("invalid %s %q: must be at least 1s", key, value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if duration < time.Second {
+		return 0, fmt.Errorf("invalid %s %q: must be at least 1s", key, value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+func TestDNSResolvers(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("dns-resolvers", []string{"1.1.1.1, 8.8.8.8:5353", "9.9.9.9 [2606:4700::1111]:53"})
+	want := []string{"1.1.1.1:53", "8.8.8.8:5353", "9.9.9.9:53", "[2606:4700::1111]:53"}
+	if got := dnsResolvers(); !slices.Equal(got, want) {
+		t.Errorf("dnsResolvers() = %v, want %v", got, want)
+	}
+}
+
+func TestDNS01Options(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	for check, want := range map[string]int{"": 0, "authoritative": 0, "recursive": 2, "all": 1, "none": 1} {
+		viper.Set("dns-propagation-check", check)
+		options, err := dns01Options()
+		if err != nil || len(options) != want {
+			t.Errorf("dns01Options() with check %q = %d options, %v, want %d", check, len(options), err, want)
+		}
+	}
+
+	viper.Set("dns-resolvers", []string{"1.1.1.1"})
+	viper.Set("dns-propagation-check", "")
+	if options, err := dns01Options(); err != nil || len(options) != 1 {
+		t.Errorf("dns01Options() with resolvers = %d options, %v, want 1", len(options), err)
+	}
+
+	viper.Set("dns-propagation-check", "strict")
+	if _, err := dns01Options(); err == nil {
+		t.Error("dns01Options() accepted an unknown propagation check")
+	}
+}
+
+func TestDurationSetting(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: time.Minute},
+		{value: "120", want: 2 * time.Minute},
+		{value: " 5m ", want: 5 * time.Minute},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "0", wantErr: true},
+		{value: "500ms", wantErr: true},
+		{value: "five minutes", wantErr: true},
+	}
+	for _, tt := range tests {
+		viper.Set("dns-propagation-timeout", tt.value)
+		got, err := durationSetting("dns-propagation-timeout", time.Minute)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("durationSetting(%q) = %s, %v, want %s (error %t)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewDNSProvider(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	provider, err := newDNSProvider(&azure.Clients{}, discoveryResourceGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout, interval := provider.Timeout(); timeout != azure.DefaultDNSPropagationTimeout || interval != azure.DefaultDNSPollingInterval {
+		t.Errorf("Timeout() = %s, %s, want the defaults", timeout, interval)
+	}
+
+	viper.Set("dns-propagation-timeout", "10m")
+	viper.Set("dns-polling-interval", "5")
+	provider, err = newDNSProvider(&azure.Clients{}, discoveryResourceGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout, interval := provider.Timeout(); timeout != 10*time.Minute || interval != 5*time.Second {
+		t.Errorf("Timeout() = %s, %s, want 10m0s, 5s", timeout, interval)
+	}
+
+	viper.Set("dns-polling-interval", "soon")
+	if _, err := newDNSProvider(&azure.Clients{}, discoveryResourceGroup); err == nil {
+		t.Error("newDNSProvider() accepted an invalid polling interval")
+	}
+}
+
+func TestChallengeAliases(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	want := map[string]string{"www.example.com": "www.validation.example.net", "example.com": "apex.validation.example.net"}
+
+	viper.Set("challenge-aliases", "WWW.example.com.=www.validation.example.net, example.com=apex.validation.example.net invalid")
+	if got := challengeAliases(); !maps.Equal(got, want) {
+		t.Errorf("challengeAliases() of a list = %v, want %v", got, want)
+	}
+
+	viper.Set("challenge-aliases", map[string]any{"www.example.com": "www.validation.example.net", "example.com.": " apex.validation.example.net ", "shop.example.com": ""})
+	if got := challengeAliases(); !maps.Equal(got, want) {
+		t.Errorf("challengeAliases() of a map = %v, want %v", got, want)
+	}
+}
+
+func TestWithChallengeAliases(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("challenge-aliases", "www.example.com=www.validation.example.net,shop.example.com=shop.validation.example.net")
+
+	www := &zones.Record{FQDN: "www.example.com", Metadata: map[string]string{}}
+	shop := &zones.Record{FQDN: "shop.example.com", Metadata: map[string]string{zones.MetadataChallengeAlias: "shop.acme.example.org"}}
+	prepare := withChallengeAliases(func(targets []*zones.Target) []*zones.Target { return targets })
+	prepare([]*zones.Target{{Records: []*zones.Record{www, shop}}})
+
+	if got := www.ChallengeAlias(); got != "www.validation.example.net" {
+		t.Errorf("alias of www.example.com = %q, want the configured alias", got)
+	}
+	if got := shop.ChallengeAlias(); got != "shop.acme.example.org" {
+		t.Errorf("alias of shop.example.com = %q, want the metadata to take precedence", got)
+	}
+}
@@ -0,0 +1,305 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/resolver"
+	"azure-ssl-certificate-provisioner/internal/types"
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// handlerSettings holds the settings shared by the certificate handlers of all ACME servers and Key Vaults
+type handlerSettings struct {
+	email            string
+	eabServer        string
+	storeKinds       []string
+	keyType          certcrypto.KeyType
+	preferredChain   string
+	defaultChallenge string
+	caaMode          string
+	forced           []string
+	azureClients     *azure.Clients
+	provider         *azure.DNSProvider
+	dnsOptions       []dns01.ChallengeOption
+	httpProvider     challenge.Provider
+	resolver         *resolver.Resolver
+}
+
+// handlerKey identifies the certificate handler of an ACME server and Key Vault
+type handlerKey struct {
+	serverURL string
+	vaultURL  string
+}
+
+// serverAccount is the ACME account of an ACME server, shared by the certificate handlers of all Key Vaults
+// ordering from the server: the account is loaded or registered once, and its client and challenge
+// solvers are used by every handler of the server.
+type serverAccount struct {
+	user    *types.AcmeUser
+	client  *lego.Client
+	solvers *certificate.ChallengeSolvers
+
+	// recovery recovers the account (see accountRecovery), run at most once by recover
+	recovery    certificate.AccountRecovery
+	recoverOnce sync.Once
+	recovered   *lego.Client
+	recoverErr  error
+}
+
+// recover recovers the account when the first handler of the server calls it, and returns the result of
+// that recovery to the handlers calling it later (implements certificate.AccountRecovery)
+func (a *serverAccount) recover(ctx context.Context) (*lego.Client, error) {
+	a.recoverOnce.Do(func() {
+		a.recovered, a.recoverErr = a.recovery(ctx)
+		if a.recovered != nil {
+			a.client = a.recovered
+		}
+	})
+	return a.recovered, a.recoverErr
+}
+
+// certificateHandlers processes every certificate target with the handler of its ACME server and Key Vault.
+// Handlers for the acme-server and acme-vault overrides are created when the first target using them is
+// processed; a handler that cannot be created is reported once and its targets are skipped. The handlers
+// of an ACME server share its account.
+type certificateHandlers struct {
+	settings      *handlerSettings
+	defaultServer string
+	defaultVault  string
+	handlers      map[handlerKey]*certificate.Handler
+	errs          map[handlerKey]error
+	accounts      map[string]*serverAccount
+	accountErrs   map[string]error
+}
+
+// newCertificateHandlers creates the handler of the configured ACME server and Key Vault
+func newCertificateHandlers(ctx context.Context, settings *handlerSettings, serverURL, vaultURL string) (*certificateHandlers, error) {
+	h := &certificateHandlers{
+		settings:      settings,
+		defaultServer: serverURL,
+		defaultVault:  normalizeVaultURL(vaultURL),
+		handlers:      make(map[handlerKey]*certificate.Handler),
+		errs:          make(map[handlerKey]error),
+		accounts:      make(map[string]*serverAccount),
+		accountErrs:   make(map[string]error),
+	}
+	if _, err := h.handler(ctx, serverURL, h.defaultVault); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ProcessTarget processes a target with the handler of its ACME server and Key Vault (matches zones.ProcessorFunc signature)
+func (h *certificateHandlers) ProcessTarget(ctx context.Context, target *zones.Target, expireThreshold int) {
+	serverURL, serverErr := target.Server()
+	vaultURL, vaultErr := target.Vault()
+	if err := errors.Join(serverErr, vaultErr); err != nil {
+		utilities.LogDefault("Invalid record metadata, target skipped: name=%s, error=%v", target.Name(), err)
+		return
+	}
+
+	if serverURL == "" {
+		serverURL = h.defaultServer
+	}
+	if vaultURL != "" && !usesKeyVault(h.settings.storeKinds) {
+		utilities.LogDefault("Key Vault override ignored, the %s store is not used: name=%s, vault=%s", certificate.StoreKeyVault, target.Name(), vaultURL)
+		vaultURL = ""
+	}
+	if vaultURL == "" {
+		vaultURL = h.defaultVault
+	}
+
+	handler, err := h.handler(ctx, serverURL, vaultURL)
+	if err != nil {
+		utilities.LogDefault("Certificate handler unavailable, target skipped: name=%s, server=%s, vault=%s, error=%v", target.Name(), serverURL, vaultURL, err)
+		return
+	}
+	handler.ProcessTarget(ctx, target, expireThreshold)
+}
+
+// handler returns the handler of an ACME server and Key Vault, creating it on first use
+func (h *certificateHandlers) handler(ctx context.Context, serverURL, vaultURL string) (*certificate.Handler, error) {
+	key := handlerKey{serverURL: serverURL, vaultURL: vaultURL}
+	if handler, ok := h.handlers[key]; ok {
+		return handler, nil
+	}
+	if err, ok := h.errs[key]; ok {
+		return nil, err
+	}
+
+	if serverURL != h.defaultServer || vaultURL != h.defaultVault {
+		utilities.LogDefault("Creating certificate handler for metadata overrides: server=%s, vault=%s", serverURL, vaultURL)
+	}
+	account, err := h.account(ctx, serverURL)
+	if err != nil {
+		h.errs[key] = err
+		return nil, err
+	}
+	handler, err := newCertificateHandler(h.settings, account, serverURL, vaultURL)
+	if err != nil {
+		h.errs[key] = err
+		return nil, err
+	}
+	h.handlers[key] = handler
+	return handler, nil
+}
+
+// account returns the ACME account of an ACME server, loading or registering it on first use
+func (h *certificateHandlers) account(ctx context.Context, serverURL string) (*serverAccount, error) {
+	if account, ok := h.accounts[serverURL]; ok {
+		return account, nil
+	}
+	if err, ok := h.accountErrs[serverURL]; ok {
+		return nil, err
+	}
+
+	account, err := newServerAccount(ctx, h.settings, serverURL)
+	if err != nil {
+		h.accountErrs[serverURL] = err
+		return nil, err
+	}
+	h.accounts[serverURL] = account
+	return account, nil
+}
+
+// newServerAccount loads or registers the ACME account of an ACME server, and creates its client and
+// challenge solvers
+func newServerAccount(ctx context.Context, s *handlerSettings, serverURL string) (*serverAccount, error) {
+	// Load or create ACME account with persistence
+	accountStore, err := createAccountStore(ctx, s.email, serverURL, s.azureClients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME account storage: %v", err)
+	}
+
+	acmeClient, user, err := newAccountClient(ctx, accountStore, s.email, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only register if we don't have existing registration
+	if user.Registration == nil {
+		eab, err := s.externalAccountBinding(ctx, serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external account binding: %v", err)
+		}
+
+		if err := acme.RegisterAccount(user, acmeClient, serverURL, eab); err != nil {
+			return nil, fmt.Errorf("failed to register ACME account: %v", err)
+		}
+
+		// Save the account data for future runs
+		if err := acme.SaveAccountData(ctx, accountStore, user); err != nil {
+			utilities.LogDefault("ACME account save failed: %v", err)
+		} else {
+			utilities.LogDefault("ACME account saved successfully")
+		}
+	} else {
+		utilities.LogDefault("ACME account loaded: %s", user.Email)
+	}
+
+	// Wildcard orders (*.zone plus the apex) need two TXT values on the same _acme-challenge
+	// record set. The provider adds and removes single values of the record set, and lego
+	// presents all DNS-01 challenges of an order before cleaning any of them up.
+	solvers := certificate.NewChallengeSolvers(acmeClient, s.defaultChallenge, s.provider, s.dnsOptions, s.httpProvider)
+	if err := solvers.Use(s.defaultChallenge); err != nil {
+		return nil, fmt.Errorf("failed to set challenge provider: %v", err)
+	}
+
+	return &serverAccount{
+		user:     user,
+		client:   acmeClient,
+		solvers:  solvers,
+		recovery: accountRecovery(s, accountStore, user, acmeClient, serverURL),
+	}, nil
+}
+
+// newCertificateHandler creates a certificate handler ordering certificates from an ACME server with its
+// account and writing them to the stores, using the given Key Vault
+func newCertificateHandler(s *handlerSettings, account *serverAccount, serverURL, vaultURL string) (*certificate.Handler, error) {
+	stores, err := createCertificateStores(s.storeKinds, s.azureClients, vaultURL, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate stores: %v", err)
+	}
+
+	certHandler := certificate.NewHandler(account.client, stores, s.keyType, s.preferredChain)
+	certHandler.SetChallengeSolvers(account.solvers)
+	certHandler.SetAccountRecovery(account.recover)
+	certHandler.AddPreflightCheck(delegationCheck(s.resolver, s.defaultChallenge))
+	if len(s.forced) > 0 {
+		certHandler.ForceRenewal(s.forced...)
+	}
+
+	// Skip orders for names whose CAA records do not permit the CA, instead of failing after validation
+	accountURI := ""
+	if account.user.Registration != nil {
+		accountURI = account.user.Registration.URI
+	}
+	if policy := newCAAPolicy(serverURL, accountURI); policy != nil {
+		certHandler.AddPreflightCheck(caaCheck(s.resolver, *policy, s.defaultChallenge, s.caaMode, s.provider))
+	}
+
+	return certHandler, nil
+}
+
+// accountRecovery returns the recovery of an ACME account whose orders fail with an account error. It
+// confirms with the registration of the account that the CA deleted or deactivated it, instead of querying
+// the registration on every run, and recovers the account (see acme.RecoverRegistration).
+func accountRecovery(s *handlerSettings, store acme.AccountStore, user *types.AcmeUser, client *lego.Client, serverURL string) certificate.AccountRecovery {
+	return func(ctx context.Context) (*lego.Client, error) {
+		err := acme.CheckRegistration(user, client)
+		if !errors.Is(err, acme.ErrInvalidRegistration) {
+			if err != nil {
+				utilities.LogDefault("ACME account registration check failed: %v", err)
+			}
+			return nil, nil
+		}
+		utilities.LogDefault("ACME account registration is no longer valid: %v", err)
+
+		eab, err := s.externalAccountBinding(ctx, serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external account binding: %v", err)
+		}
+
+		recreate := viper.GetBool("recreate-account")
+		recovered, err := acme.RecoverRegistration(ctx, store, user, client, serverURL, eab, recreate)
+		if err != nil && !recreate {
+			return nil, fmt.Errorf("failed to recover ACME account: %v (use --recreate-account to register a new account)", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to recover ACME account: %v", err)
+		}
+		utilities.LogDefault("ACME account recovered: %s", user.Registration.URI)
+		return recovered, nil
+	}
+}
+
+// externalAccountBinding returns the external account binding for registering an account at an ACME server.
+// An EAB is issued by the CA of the configured server, so it is not sent to the servers of acme-server
+// overrides; accounts requiring EAB at those servers must be registered with the account command first.
+func (s *handlerSettings) externalAccountBinding(ctx context.Context, serverURL string) (*acme.ExternalAccountBinding, error) {
+	if serverURL == s.eabServer {
+		return externalAccountBinding(ctx, s.azureClients)
+	}
+	if viper.GetString("eab-kid") != "" {
+		utilities.LogDefault("External account binding not used for ACME server %s, the EAB settings belong to %s", serverURL, s.eabServer)
+	}
+	return nil, nil
+}
+
+// normalizeVaultURL returns a Key Vault URL in the form of the acme-vault metadata values
+func normalizeVaultURL(vaultURL string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(vaultURL)), "/")
+}
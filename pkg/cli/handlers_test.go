@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/types"
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// captureLog returns the buffer receiving the log output until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestCertificateHandlersSelectServerAndVault(t *testing.T) {
+	const defaultServer = "https://acme-v02.api.letsencrypt.org/directory"
+	const zeroSSL = "https://acme.zerossl.com/v2/DV90"
+	const defaultVault, otherVault = "https://default.vault.azure.net", "https://other.vault.azure.net"
+
+	// Every handler fails to be created, so the selected handler is named in the log
+	handlers := func(storeKinds ...string) *certificateHandlers {
+		h := &certificateHandlers{
+			settings:      &handlerSettings{storeKinds: storeKinds},
+			defaultServer: defaultServer,
+			defaultVault:  defaultVault,
+			errs:          make(map[handlerKey]error),
+		}
+		for _, server := range []string{defaultServer, zeroSSL} {
+			for _, vault := range []string{defaultVault, otherVault} {
+				h.errs[handlerKey{serverURL: server, vaultURL: vault}] = fmt.Errorf("handler of %s and %s", server, vault)
+			}
+		}
+		return h
+	}
+	target := func(server, vault string) *zones.Target {
+		return &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com", Settings: zones.Settings{Server: server, Vault: vault}}}}
+	}
+
+	tests := []struct {
+		name       string
+		storeKinds []string
+		target     *zones.Target
+		want       string
+	}{
+		{name: "no overrides", storeKinds: []string{certificate.StoreKeyVault}, target: target("", ""), want: "handler of " + defaultServer + " and " + defaultVault},
+		{name: "server override", storeKinds: []string{certificate.StoreKeyVault}, target: target(zeroSSL, ""), want: "handler of " + zeroSSL + " and " + defaultVault},
+		{name: "vault override", storeKinds: []string{certificate.StoreKeyVault}, target: target("", otherVault), want: "handler of " + defaultServer + " and " + otherVault},
+		{name: "vault override without Key Vault store", storeKinds: []string{certificate.StoreFilesystem}, target: target(zeroSSL, otherVault), want: "handler of " + zeroSSL + " and " + defaultVault},
+		{
+			name:       "conflicting overrides",
+			storeKinds: []string{certificate.StoreKeyVault},
+			target: &zones.Target{Group: "web", Records: []*zones.Record{
+				{FQDN: "www.example.com", Settings: zones.Settings{Server: zeroSSL}},
+				{FQDN: "shop.example.com", Settings: zones.Settings{Server: defaultServer}},
+			}},
+			want: "Invalid record metadata, target skipped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := captureLog(t)
+			handlers(tt.storeKinds...).ProcessTarget(context.Background(), tt.target, 30)
+			if !strings.Contains(output.String(), tt.want) {
+				t.Errorf("ProcessTarget() logged %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateHandlersShareServerAccount(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("certificate-path", t.TempDir())
+
+	const server = "https://acme-v02.api.letsencrypt.org/directory"
+	const defaultVault, otherVault = "https://default.vault.azure.net", "https://other.vault.azure.net"
+
+	recoveries := 0
+	recovered := &lego.Client{}
+	account := &serverAccount{
+		user: &types.AcmeUser{Email: "admin@example.com"},
+		recovery: func(ctx context.Context) (*lego.Client, error) {
+			recoveries++
+			return recovered, nil
+		},
+	}
+	h := &certificateHandlers{
+		settings:      &handlerSettings{storeKinds: []string{certificate.StoreFilesystem}},
+		defaultServer: server,
+		defaultVault:  defaultVault,
+		handlers:      make(map[handlerKey]*certificate.Handler),
+		errs:          make(map[handlerKey]error),
+		accounts:      map[string]*serverAccount{server: account},
+		accountErrs:   make(map[string]error),
+	}
+
+	// The handlers of both Key Vaults use the account of the server instead of loading their own
+	for _, vault := range []string{defaultVault, otherVault} {
+		if _, err := h.handler(context.Background(), server, vault); err != nil {
+			t.Fatalf("handler(%s) error = %v", vault, err)
+		}
+	}
+	if len(h.handlers) != 2 || len(h.accounts) != 1 {
+		t.Fatalf("handlers = %d, accounts = %d, want 2 handlers sharing 1 account", len(h.handlers), len(h.accounts))
+	}
+
+	// Both handlers failing with an account error get the client of a single recovery
+	for range h.handlers {
+		client, err := account.recover(context.Background())
+		if err != nil || client != recovered {
+			t.Fatalf("recover() = %p, %v, want the recovered client", client, err)
+		}
+	}
+	if recoveries != 1 || account.client != recovered {
+		t.Errorf("account recovered %d times, client updated %t, want one recovery used by later handlers", recoveries, account.client == recovered)
+	}
+}
+
+func TestNormalizeVaultURL(t *testing.T) {
+	if got := normalizeVaultURL(" https://My-Vault.vault.azure.net/ "); got != "https://my-vault.vault.azure.net" {
+		t.Errorf("normalizeVaultURL() = %q", got)
+	}
+}
+
+func TestExternalAccountBindingOnlyForConfiguredServer(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("eab-kid", "kid-1")
+	viper.Set("eab-hmac", "c2VjcmV0")
+
+	s := &handlerSettings{eabServer: "https://acme.zerossl.com/v2/DV90"}
+
+	eab, err := s.externalAccountBinding(context.Background(), "https://acme-v02.api.letsencrypt.org/directory")
+	if err != nil || eab != nil {
+		t.Errorf("externalAccountBinding() of an override server = %v, %v, want none", eab, err)
+	}
+
+	eab, err = s.externalAccountBinding(context.Background(), s.eabServer)
+	if err != nil {
+		t.Fatalf("externalAccountBinding() of the configured server error = %v", err)
+	}
+	if eab == nil || eab.KeyID != "kid-1" {
+		t.Errorf("externalAccountBinding() of the configured server = %+v, want the configured EAB", eab)
+	}
+}
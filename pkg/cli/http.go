@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/providers/http/webroot"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+// HTTP-01 responders
+const (
+	httpResponderStandalone = "standalone"
+	httpResponderDirectory  = "directory"
+	httpResponderStorage    = "storage"
+)
+
+// newHTTPProvider creates the HTTP-01 responder selected by the http-responder setting
+func newHTTPProvider(azureClients *azure.Clients) (challenge.Provider, error) {
+	switch responder := viper.GetString("http-responder"); responder {
+	case "", httpResponderStandalone:
+		address := viper.GetString("http-listen")
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP listen address %q: %v", address, err)
+		}
+		utilities.LogVerbose("HTTP-01 responder: standalone server on %s", address)
+		return http01.NewProviderServer(host, port), nil
+
+	case httpResponderDirectory:
+		directory := viper.GetString("http-directory")
+		if directory == "" {
+			return nil, fmt.Errorf("the directory HTTP-01 responder requires --http-directory")
+		}
+		utilities.LogVerbose("HTTP-01 responder: directory %s", directory)
+		return webroot.NewHTTPProvider(directory)
+
+	case httpResponderStorage:
+		accountURL := viper.GetString("http-storage-account-url")
+		if accountURL == "" {
+			return nil, fmt.Errorf("the storage HTTP-01 responder requires --http-storage-account-url")
+		}
+		container := viper.GetString("http-storage-container")
+		utilities.LogVerbose("HTTP-01 responder: storage account %s, container %s", accountURL, container)
+		return azure.NewBlobHTTPProvider(azureClients.Credential, accountURL, container)
+
+	default:
+		return nil, fmt.Errorf("unsupported HTTP-01 responder %q (use %s, %s or %s)", responder,
+			httpResponderStandalone, httpResponderDirectory, httpResponderStorage)
+	}
+}
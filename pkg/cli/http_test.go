@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+func TestNewHTTPProvider(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	// The directory responder writes the challenge file below the web root
+	directory := t.TempDir()
+	viper.Set("http-responder", "directory")
+	viper.Set("http-directory", directory)
+	provider, err := newHTTPProvider(&azure.Clients{})
+	if err != nil {
+		t.Fatalf("newHTTPProvider() error = %v", err)
+	}
+	if err := provider.Present("www.example.com", "token", "key-auth"); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(directory, ".well-known", "acme-challenge", "token"))
+	if err != nil || string(content) != "key-auth" {
+		t.Errorf("challenge file = %q, %v, want the key authorization", content, err)
+	}
+
+	tests := map[string]map[string]string{
+		"directory without path":       {"http-responder": "directory", "http-directory": ""},
+		"storage without account":      {"http-responder": "storage"},
+		"standalone with invalid host": {"http-responder": "standalone", "http-listen": "80"},
+		"unknown responder":            {"http-responder": "ftp"},
+	}
+	for name, settings := range tests {
+		viper.Reset()
+		for key, value := range settings {
+			viper.Set(key, value)
+		}
+		if _, err := newHTTPProvider(&azure.Clients{}); err == nil {
+			t.Errorf("newHTTPProvider() with %s succeeded", name)
+		}
+	}
+}
@@ -2,16 +2,22 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azcertificates"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
 	"github.com/spf13/viper"
 
+	"azure-ssl-certificate-provisioner/internal/resolver"
 	"azure-ssl-certificate-provisioner/internal/utilities"
 	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
 	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
 )
 
 // listCertificatesAndRecords lists DNS records and their certificate status
@@ -25,12 +31,32 @@ func (c *Commands) listCertificatesAndRecords() {
 	expireThreshold := viper.GetInt("expire-threshold")
 	email := viper.GetString("email")
 
+	keyType, err := certificate.ParseKeyType(viper.GetString("key-type"))
+	if err != nil {
+		log.Fatalf("Invalid key type: %v", err)
+	}
+
 	// Validate required parameters
+	storeKinds, err := selectedStores()
+	if err != nil {
+		log.Fatalf("Invalid certificate stores: %v", err)
+	}
+
+	namer, err := certificateNamer()
+	if err != nil {
+		log.Fatalf("Invalid certificate naming: %v", err)
+	}
+
 	if subscriptionId == "" {
 		log.Fatalf("Subscription ID not specified.")
 	}
 
-	if resourceGroupName == "" {
+	discovery, err := discoveryMode()
+	if err != nil {
+		log.Fatalf("Invalid discovery settings: %v", err)
+	}
+
+	if resourceGroupName == "" && discovery == discoveryResourceGroup {
 		log.Fatalf("Resource Group Name not specified.")
 	}
 
@@ -40,7 +66,7 @@ func (c *Commands) listCertificatesAndRecords() {
 
 	// Validate required environment variables (but don't require ACME auth for listing)
 	vaultURL := viper.GetString("key-vault-url")
-	if vaultURL == "" {
+	if usesKeyVault(storeKinds) && vaultURL == "" {
 		log.Fatalf("AZURE_KEY_VAULT_URL environment variable is required")
 	}
 
@@ -50,76 +76,327 @@ func (c *Commands) listCertificatesAndRecords() {
 		log.Fatalf("Failed to create Azure clients: %v", err)
 	}
 
-	utilities.LogDefault("List mode started: subscription=%s, resource_group=%s, key_vault=%s, expire_threshold=%d", subscriptionId, resourceGroupName, vaultURL, expireThreshold)
+	serverURL, err := acmeServerURL()
+	if err != nil {
+		log.Fatalf("Invalid ACME server: %v", err)
+	}
+
+	stores, err := createCertificateStores(storeKinds, azureClients, "", serverURL)
+	if err != nil {
+		log.Fatalf("Failed to create certificate stores: %v", err)
+	}
+
+	// Renewal info (ARI) lookups are unauthenticated, so a throwaway ACME key is sufficient
+	acmeClient, err := acme.NewReadOnlyClient(serverURL)
+	if err != nil {
+		utilities.LogDefault("ACME client setup failed, renewal windows will not be shown: %v", err)
+	}
+
+	utilities.LogDefault("List mode started: subscription=%s, resource_group=%s, stores=%s, expire_threshold=%d", subscriptionId, resourceGroupName, strings.Join(storeKinds, ","), expireThreshold)
 
 	// Create zones enumerator and process zones with listing processor
 	enumerator := zones.NewEnumerator(azureClients)
 
 	listProcessor := &CertificateListProcessor{
-		kvClient:        azureClients.KVCert,
+		stores:          stores,
+		acmeClient:      acmeClient,
+		storeKinds:      storeKinds,
+		azureClients:    azureClients,
+		serverURL:       serverURL,
+		email:           email,
 		expireThreshold: expireThreshold,
+		keyType:         keyType,
+		resolver:        resolver.NewResolver(dnsResolvers()),
+		caaPolicy:       newCAAPolicy(serverURL, storedAccountURI(ctx, email, serverURL, azureClients)),
+	}
+	if listProcessor.challenge, err = certificate.ParseChallengeType(viper.GetString("challenge")); err != nil {
+		log.Fatalf("Invalid challenge type: %v", err)
 	}
 
-	if err := enumerator.EnumerateAndProcess(ctx, zonesList, resourceGroupName, expireThreshold, listProcessor.ProcessFQDN); err != nil {
+	if viper.GetBool("zone-delegation-check") {
+		enumerator.SetDelegationCheck(listProcessor.resolver)
+	}
+
+	if err := enumerateTargets(ctx, enumerator, discovery, zonesList, resourceGroupName, expireThreshold, withChallengeAliases(namer.AssignNames), listProcessor.ProcessTarget); err != nil {
 		log.Fatalf("Failed to enumerate and process zones: %v", err)
 	}
 
+	listProcessor.ReportZoneDelegations(enumerator.ZoneDelegations())
+	listProcessor.ReportMetadataErrors(enumerator.MetadataErrors())
+
 	// Print summary
 	listProcessor.PrintSummary()
 }
 
-// CertificateListProcessor processes FQDNs for listing purposes
+// CertificateListProcessor processes certificate targets for listing purposes
 type CertificateListProcessor struct {
-	kvClient        *azcertificates.Client
+	stores          []certificate.Store
+	acmeClient      *lego.Client
+	storeKinds      []string
+	azureClients    *azure.Clients
+	serverURL       string
+	email           string
+	vaultStores     map[string][]certificate.Store
+	serverClients   map[string]*lego.Client
+	serverPolicies  map[string]*zones.CAAPolicy
 	expireThreshold int
+	keyType         certcrypto.KeyType
+	resolver        *resolver.Resolver
+	challenge       string
+	caaPolicy       *zones.CAAPolicy
 	totalRecords    int
 	validCerts      int
 	expiredCerts    int
 	missingCerts    int
+	mismatchedKeys  int
+	mismatchedSANs  int
+	renewalsDue     int
+	badDelegations  int
+	caaErrors       int
+	badZones        int
+	metadataErrors  int
 }
 
-// ProcessFQDN processes a single FQDN for listing (matches zones.ProcessorFunc signature)
-func (p *CertificateListProcessor) ProcessFQDN(ctx context.Context, fqdn string, expireThreshold int) {
-	p.totalRecords++
+// ProcessTarget processes a single certificate target for listing (matches zones.ProcessorFunc signature)
+func (p *CertificateListProcessor) ProcessTarget(ctx context.Context, target *zones.Target, expireThreshold int) {
+	p.totalRecords += len(target.Records)
 
-	certName := "cert-" + strings.ReplaceAll(fqdn, ".", "-")
-	utilities.LogDefault("DNS record found and marked for ACME processing")
+	certName := certificate.CertificateName(target)
+	domains := target.Domains()
+	if target.Group != "" {
+		utilities.LogDefault("DNS record group %s found and marked for ACME processing: %s", target.Group, strings.Join(domains, ", "))
+	} else {
+		utilities.LogDefault("DNS record found and marked for ACME processing")
+	}
 	utilities.LogDefault("Checking certificate: %s", certName)
 
-	// Check certificate status in Key Vault
-	resp, err := p.kvClient.GetCertificate(ctx, certName, "", nil)
+	// acme-threshold, acme-server and acme-vault metadata override the configuration
+	if threshold := target.Threshold(expireThreshold); threshold != expireThreshold {
+		utilities.LogDefault("Expiration threshold: %d days", threshold)
+		expireThreshold = threshold
+	}
+	stores, acmeClient, err := p.targetStores(target)
 	if err != nil {
-		utilities.LogDefault("Certificate not found in Key Vault")
-		p.missingCerts++
+		utilities.LogDefault("Invalid record metadata: %v", err)
+		p.metadataErrors++
 		return
 	}
 
-	// Check certificate expiration
-	daysLeft := 0
-	if resp.Attributes != nil && resp.Attributes.Expires != nil {
-		daysLeft = int(time.Until(*resp.Attributes.Expires).Hours() / 24)
+	challengeType, err := certificate.ResolveChallengeType(target, p.challenge)
+	if err != nil {
+		utilities.LogDefault("Invalid challenge type: %v", err)
+	} else if challengeType != p.challenge {
+		utilities.LogDefault("Challenge type: %s", challengeType)
+	}
 
-		if daysLeft <= expireThreshold {
-			utilities.LogDefault("Certificate expires in %d days (threshold: %d)", daysLeft, expireThreshold)
-			p.expiredCerts++
+	// Check that delegated DNS-01 challenge records point to their aliases
+	for _, record := range target.Records {
+		if challengeType == certificate.ChallengeHTTP01 {
+			break
+		}
+		alias := record.ChallengeAlias()
+		if alias == "" {
+			continue
+		}
+		if err := zones.CheckChallengeDelegation(ctx, p.resolver, record.FQDN, alias); err != nil {
+			utilities.LogDefault("Challenge delegation misconfigured: %v", err)
+			p.badDelegations++
 		} else {
-			utilities.LogDefault("Certificate valid for %d days", daysLeft)
-			p.validCerts++
+			utilities.LogDefault("Challenge delegated: %s -> %s", record.FQDN, alias)
 		}
+	}
+
+	// Check that the CAA records of every name permit the CA to issue
+	if caaPolicy := p.targetCAAPolicy(ctx, target); caaPolicy != nil {
+		policy := *caaPolicy
+		policy.ValidationMethod = challengeType
+		statuses, err := zones.CheckCAAs(ctx, p.resolver, target, policy)
+		if err != nil {
+			utilities.LogDefault("CAA check failed: %v", err)
+			p.caaErrors++
+		}
+		for _, status := range statuses {
+			if status.Permitted {
+				utilities.LogDefault("CAA permits issuance for %s: %s", status.Domain(), status.Reason)
+			} else {
+				utilities.LogDefault("CAA does not permit issuance for %s: %s", status.Domain(), status.Reason)
+				p.caaErrors++
+			}
+		}
+	}
+
+	keyType, err := certificate.ResolveKeyType(target, p.keyType)
+	if err != nil {
+		// The handler skips the target as well
+		utilities.LogDefault("Invalid key type metadata: %v", err)
+		p.metadataErrors++
+		return
+	}
+
+	// Check certificate status in every store, reporting on the one expiring first
+	var stored *certificate.StoredCertificate
+	for _, store := range stores {
+		current, err := store.Get(ctx, target)
+		if err != nil {
+			utilities.LogDefault("Certificate lookup failed in %s store: %v", store.Name(), err)
+			p.missingCerts++
+			return
+		}
+		if current == nil {
+			utilities.LogDefault("Certificate not found in %s store", store.Name())
+			p.missingCerts++
+			return
+		}
+		if stored == nil || current.Expires.Before(stored.Expires) {
+			stored = current
+		}
+	}
+	der := stored.Leaf.Raw
+
+	// Check certificate key type against the desired one
+	currentKeyType, err := certificate.CertificateKeyType(der)
+	if err != nil {
+		utilities.LogDefault("Certificate key type unknown: %v", err)
+	} else if currentKeyType != keyType {
+		utilities.LogDefault("Certificate key type: %s (expected: %s, reissue required)", certificate.KeyTypeName(currentKeyType), certificate.KeyTypeName(keyType))
+		p.mismatchedKeys++
 	} else {
-		utilities.LogDefault("Certificate found but expiration date unavailable")
-		p.missingCerts++
+		utilities.LogDefault("Certificate key type: %s", certificate.KeyTypeName(currentKeyType))
+	}
+
+	// Check certificate SANs against the records of the target
+	missing, extra, err := certificate.DiffSANs(der, domains)
+	if err != nil {
+		utilities.LogDefault("Certificate SAN check failed: %v", err)
+	} else if len(missing) > 0 || len(extra) > 0 {
+		utilities.LogDefault("Certificate SANs out of date (missing: %s, extra: %s, reissue required)", strings.Join(missing, ", "), strings.Join(extra, ", "))
+		p.mismatchedSANs++
+	}
+
+	// Show the CA-suggested renewal window when the CA supports ARI
+	if acmeClient != nil {
+		info, err := certificate.FetchRenewalInfo(acmeClient, der)
+		if errors.Is(err, api.ErrNoARI) {
+			utilities.LogVerbose("Renewal info not supported by CA")
+		} else if err != nil {
+			utilities.LogDefault("Renewal info lookup failed: %v", err)
+		} else {
+			now := time.Now()
+			status := "not yet open"
+			if info.WindowPassed(now) {
+				status = "passed, immediate replacement requested"
+				p.renewalsDue++
+			} else if info.InWindow(now) {
+				status = "open, renewal due"
+				p.renewalsDue++
+			}
+			utilities.LogDefault("Suggested renewal window: %s - %s (%s)", info.WindowStart.Format(time.RFC3339), info.WindowEnd.Format(time.RFC3339), status)
+			if info.ExplanationURL != "" {
+				utilities.LogDefault("Renewal window explanation: %s", info.ExplanationURL)
+			}
+		}
+	}
+
+	// Check certificate expiration
+	daysLeft := int(time.Until(stored.Expires).Hours() / 24)
+	if daysLeft <= expireThreshold {
+		utilities.LogDefault("Certificate expires in %d days (threshold: %d)", daysLeft, expireThreshold)
+		p.expiredCerts++
+	} else {
+		utilities.LogDefault("Certificate valid for %d days", daysLeft)
+		p.validCerts++
+	}
+}
+
+// targetStores returns the certificate stores of the Key Vault of a target and the ACME client used for
+// renewal info lookups at its ACME server, creating them on first use
+func (p *CertificateListProcessor) targetStores(target *zones.Target) ([]certificate.Store, *lego.Client, error) {
+	serverURL, serverErr := target.Server()
+	vaultURL, vaultErr := target.Vault()
+	if err := errors.Join(serverErr, vaultErr); err != nil {
+		return nil, nil, err
+	}
+
+	stores, acmeClient := p.stores, p.acmeClient
+	if serverURL != "" && serverURL != p.serverURL {
+		utilities.LogDefault("ACME server: %s", serverURL)
+		if p.serverClients == nil {
+			p.serverClients = make(map[string]*lego.Client)
+		}
+		client, ok := p.serverClients[serverURL]
+		if !ok {
+			var err error
+			if client, err = acme.NewReadOnlyClient(serverURL); err != nil {
+				utilities.LogDefault("ACME client setup failed, renewal windows will not be shown: %v", err)
+			}
+			p.serverClients[serverURL] = client
+		}
+		acmeClient = client
+	}
+
+	if vaultURL != "" && usesKeyVault(p.storeKinds) {
+		utilities.LogDefault("Key Vault: %s", vaultURL)
+		if p.vaultStores == nil {
+			p.vaultStores = make(map[string][]certificate.Store)
+		}
+		if _, ok := p.vaultStores[vaultURL]; !ok {
+			vaultStores, err := createCertificateStores(p.storeKinds, p.azureClients, vaultURL, p.serverURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.vaultStores[vaultURL] = vaultStores
+		}
+		stores = p.vaultStores[vaultURL]
+	}
+
+	return stores, acmeClient, nil
+}
+
+// targetCAAPolicy returns the CAA policy of the ACME server of a target, resolving the policy of an
+// acme-server override with the account stored for that server on first use
+func (p *CertificateListProcessor) targetCAAPolicy(ctx context.Context, target *zones.Target) *zones.CAAPolicy {
+	serverURL, err := target.Server()
+	if err != nil || serverURL == "" || serverURL == p.serverURL {
+		return p.caaPolicy
+	}
+
+	if p.serverPolicies == nil {
+		p.serverPolicies = make(map[string]*zones.CAAPolicy)
+	}
+	policy, ok := p.serverPolicies[serverURL]
+	if !ok {
+		policy = newCAAPolicy(serverURL, storedAccountURI(ctx, p.email, serverURL, p.azureClients))
+		p.serverPolicies[serverURL] = policy
+	}
+	return policy
+}
+
+// ReportMetadataErrors prints the invalid metadata values of the records that were skipped
+func (p *CertificateListProcessor) ReportMetadataErrors(errs []*zones.MetadataError) {
+	for _, err := range errs {
+		utilities.LogDefault("Record %s skipped: %v", err.FQDN, err)
+	}
+	p.metadataErrors += len(errs)
+}
+
+// ReportZoneDelegations prints the public delegation status of every checked zone
+func (p *CertificateListProcessor) ReportZoneDelegations(delegations []*zones.ZoneDelegation) {
+	for _, delegation := range delegations {
+		utilities.LogDefault("Zone %s: %s", delegation.Zone, delegation)
+		if delegation.Status != zones.DelegationOK {
+			p.badZones++
+		}
 	}
 }
 
 // PrintSummary prints a summary of the listing results
 func (p *CertificateListProcessor) PrintSummary() {
 	needsAction := ""
-	if p.expiredCerts > 0 || p.missingCerts > 0 {
+	if p.expiredCerts > 0 || p.missingCerts > 0 || p.mismatchedKeys > 0 || p.mismatchedSANs > 0 || p.renewalsDue > 0 || p.badDelegations > 0 || p.caaErrors > 0 || p.badZones > 0 || p.metadataErrors > 0 {
 		needsAction = ", action_needed=true"
 	} else {
 		needsAction = ", action_needed=false"
 	}
-	utilities.LogDefault("Summary: total_records=%d, valid_certs=%d, expired_certs=%d, missing_certs=%d%s",
-		p.totalRecords, p.validCerts, p.expiredCerts, p.missingCerts, needsAction)
+	utilities.LogDefault("Summary: total_records=%d, valid_certs=%d, expired_certs=%d, missing_certs=%d, key_type_mismatches=%d, san_mismatches=%d, ari_renewals_due=%d, delegation_errors=%d, caa_errors=%d, zone_delegation_errors=%d, metadata_errors=%d%s",
+		p.totalRecords, p.validCerts, p.expiredCerts, p.missingCerts, p.mismatchedKeys, p.mismatchedSANs, p.renewalsDue, p.badDelegations, p.caaErrors, p.badZones, p.metadataErrors, needsAction)
 }
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+)
+
+func TestListTargetCAAPolicy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	ctx := context.Background()
+	legoPath := t.TempDir()
+	viper.Set("lego-path", legoPath)
+	viper.Set("caa-check", true)
+	viper.Set("caa-identities", []string{"example-ca.com"})
+
+	const email = "admin@example.com"
+	const defaultServer, otherServer = "https://acme.example.com/directory", "https://acme.example.net/directory"
+	const otherAccountURI = "https://acme.example.net/acct/2"
+
+	// The account of the override server is stored next to the one of the configured server
+	store, err := acme.NewAccountStorage(legoPath, email, otherServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := acme.LoadOrCreateAccount(ctx, store, email, certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Registration = &registration.Resource{URI: otherAccountURI, Body: legoacme.Account{Status: legoacme.StatusValid}}
+	if err := acme.SaveAccountData(ctx, store, user); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultPolicy := &zones.CAAPolicy{Identities: []string{"example-ca.com"}, AccountURI: "https://acme.example.com/acct/1"}
+	p := &CertificateListProcessor{serverURL: defaultServer, email: email, caaPolicy: defaultPolicy}
+	target := func(server string) *zones.Target {
+		return &zones.Target{Records: []*zones.Record{{FQDN: "www.example.com", Settings: zones.Settings{Server: server}}}}
+	}
+
+	for _, server := range []string{"", defaultServer} {
+		if policy := p.targetCAAPolicy(ctx, target(server)); policy != defaultPolicy {
+			t.Errorf("targetCAAPolicy() of server %q = %+v, want the policy of the configured server", server, policy)
+		}
+	}
+
+	policy := p.targetCAAPolicy(ctx, target(otherServer))
+	if policy == nil || policy.AccountURI != otherAccountURI {
+		t.Fatalf("targetCAAPolicy() of an override server = %+v, want the account URI %s", policy, otherAccountURI)
+	}
+	if cached := p.targetCAAPolicy(ctx, target(otherServer)); cached != policy {
+		t.Errorf("targetCAAPolicy() resolved the policy of %s again", otherServer)
+	}
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/internal/zones"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// createRevokeCommand creates the revoke command
+func (c *Commands) createRevokeCommand() *cobra.Command {
+	var revokeCmd = &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke a certificate stored in Key Vault",
+		Long: `Revoke the current version of a certificate stored in Key Vault, identified by its Key Vault name or by an FQDN it covers.
+The certificate is revoked with the ACME account, or with the certificate private key when the account cannot revoke it and the key is exportable.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			c.runRevoke()
+		},
+	}
+
+	revokeCmd.Flags().StringP("name", "n", "", "Key Vault certificate name (e.g. cert-www-example-com)")
+	revokeCmd.Flags().StringP("fqdn", "f", "", "FQDN covered by the certificate")
+	revokeCmd.Flags().StringP("reason", "r", "unspecified", "Revocation reason: unspecified, keyCompromise, affiliationChanged, superseded, cessationOfOperation (or its code)")
+	revokeCmd.Flags().Bool("disable", false, "Disable the revoked Key Vault certificate version")
+	revokeCmd.Flags().Bool("reissue", false, "Reissue the certificate immediately after revocation")
+	revokeCmd.Flags().String("name-template", "", "Go template for Key Vault certificate names, used to find the certificate of an FQDN without an fqdn tag")
+	revokeCmd.Flags().StringSliceP("zones", "z", nil, "DNS zone(s) to search for the record of the certificate, whose acme-vault and acme-server metadata are honoured, and to process when reissuing")
+	revokeCmd.Flags().StringP("subscription", "s", "", "Azure subscription ID")
+	revokeCmd.Flags().StringP("resource-group", "g", "", "Azure resource group name")
+	revokeCmd.Flags().Bool("staging", true, "Use Let's Encrypt staging environment (ignored when --acme-server is set)")
+	revokeCmd.Flags().String("acme-server", "", acmeServerHelp())
+	revokeCmd.Flags().StringSlice("ca-bundle", nil, "PEM file(s) with additional CA certificates to trust for the ACME server (can be used multiple times)")
+	revokeCmd.Flags().String("lego-path", "", "lego root directory for ACME accounts and the lego certificate store (default: ~/.lego)")
+	revokeCmd.Flags().String("account-storage", acme.AccountStorageFilesystem, "ACME account storage: filesystem or keyvault")
+	revokeCmd.Flags().StringP("email", "e", "", "Email address of the ACME account (required)")
+
+	// --reissue provisions the certificate like the run command, so the run flags revoke does not define
+	// itself are added: the reissued certificate then uses the same stores, key type and challenges as run
+	runFlags := &cobra.Command{}
+	addRunFlags(runFlags)
+	revokeCmd.Flags().AddFlagSet(runFlags.Flags())
+
+	bindings := runFlagBindings()
+	maps.Copy(bindings, map[string]string{
+		"revoke-name":               "name",
+		"revoke-fqdn":               "fqdn",
+		"revoke-reason":             "reason",
+		"revoke-disable":            "disable",
+		"revoke-reissue":            "reissue",
+		"zones":                     "zones",
+		"certificate-name-template": "name-template",
+		"subscription":              "subscription",
+		"resource-group":            "resource-group",
+		"staging":                   "staging",
+		"acme-server":               "acme-server",
+		"acme-ca-bundle":            "ca-bundle",
+		"email":                     "email",
+		"account-storage":           "account-storage",
+		"lego-path":                 "lego-path",
+	})
+	bindFlagsOnRun(revokeCmd, bindings)
+
+	return revokeCmd
+}
+
+// runRevoke executes the certificate revocation logic
+func (c *Commands) runRevoke() {
+	ctx := context.Background()
+
+	certName := viper.GetString("revoke-name")
+	fqdn := viper.GetString("revoke-fqdn")
+	subscriptionId := viper.GetString("subscription")
+	email := viper.GetString("email")
+	vaultURL := viper.GetString("key-vault-url")
+
+	if (certName == "") == (fqdn == "") {
+		log.Fatalf("Specify either --name or --fqdn.")
+	}
+
+	namer, err := certificateNamer()
+	if err != nil {
+		log.Fatalf("Invalid certificate naming: %v", err)
+	}
+
+	reason, err := certificate.ParseRevocationReason(viper.GetString("revoke-reason"))
+	if err != nil {
+		log.Fatalf("Invalid revocation reason: %v", err)
+	}
+
+	if subscriptionId == "" {
+		log.Fatalf("Subscription ID not specified.")
+	}
+
+	if email == "" {
+		log.Fatalf("Email address not specified.")
+	}
+
+	if vaultURL == "" {
+		log.Fatalf("AZURE_KEY_VAULT_URL environment variable is required")
+	}
+
+	azureClients, err := azure.NewClients(subscriptionId, vaultURL)
+	if err != nil {
+		log.Fatalf("Failed to create Azure clients: %v", err)
+	}
+
+	serverURL, err := acmeServerURL()
+	if err != nil {
+		log.Fatalf("Invalid ACME server: %v", err)
+	}
+
+	// The record of the certificate may store it in another Key Vault and order it from another ACME server
+	target, err := findRevokeTarget(ctx, azureClients, namer, certName, fqdn)
+	if err != nil {
+		log.Fatalf("Failed to find certificate: %v", err)
+	}
+	if target != nil {
+		certName = certificate.CertificateName(target)
+		targetVault, vaultErr := target.Vault()
+		targetServer, serverErr := target.Server()
+		if err := errors.Join(vaultErr, serverErr); err != nil {
+			log.Fatalf("Invalid record metadata: name=%s, error=%v", target.Name(), err)
+		}
+		if targetVault != "" {
+			vaultURL = targetVault
+		}
+		if targetServer != "" {
+			serverURL = targetServer
+		}
+		utilities.LogDefault("Certificate of record %s: name=%s, vault=%s, server=%s", target.Name(), certName, vaultURL, serverURL)
+	}
+
+	kvCert, err := azureClients.Certificates(vaultURL)
+	if err != nil {
+		log.Fatalf("Failed to create Azure clients: %v", err)
+	}
+	kvSecret, err := azureClients.Secrets(vaultURL)
+	if err != nil {
+		log.Fatalf("Failed to create Azure clients: %v", err)
+	}
+
+	// A missing account is not fatal, the certificate key may still be used for revocation.
+	// The account is only loaded, revoking must not create account keys in the account storage.
+	var acmeClient *lego.Client
+	accountStore, err := createAccountStore(ctx, email, serverURL, azureClients)
+	if err != nil {
+		utilities.LogDefault("ACME account unavailable, only the certificate key can be used: %v", err)
+	} else if user, err := acme.LoadExistingAccount(ctx, accountStore, email); err != nil {
+		utilities.LogDefault("ACME account unavailable, only the certificate key can be used: %v", err)
+	} else if user.Registration == nil {
+		utilities.LogDefault("ACME account for %s is not registered, only the certificate key can be used", email)
+	} else if acmeClient, err = acme.NewClient(user, serverURL); err != nil {
+		utilities.LogDefault("ACME client unavailable, only the certificate key can be used: %v", err)
+		acmeClient = nil
+	}
+
+	revoker := certificate.NewRevoker(acmeClient, kvCert, kvSecret, namer, serverURL)
+
+	if certName == "" {
+		certName, err = revoker.FindCertificateName(ctx, fqdn)
+		if err != nil {
+			log.Fatalf("Failed to find certificate: %v", err)
+		}
+		utilities.LogDefault("Certificate for %s: %s", fqdn, certName)
+	}
+
+	revoked, err := revoker.Revoke(ctx, certName, reason)
+	if err != nil {
+		log.Fatalf("Failed to revoke certificate %s: %v", certName, err)
+	}
+	utilities.LogDefault("Certificate revoked: name=%s, version=%s, subject=%s, reason=%d", revoked.Name, revoked.Version, revoked.Leaf.Subject.CommonName, reason)
+
+	if viper.GetBool("revoke-disable") {
+		if err := revoker.Disable(ctx, revoked.Name, revoked.Version); err != nil {
+			log.Fatalf("Failed to disable certificate %s: %v", revoked.Name, err)
+		}
+		utilities.LogDefault("Certificate version disabled: name=%s, version=%s", revoked.Name, revoked.Version)
+	}
+
+	if viper.GetBool("revoke-reissue") {
+		utilities.LogDefault("Reissuing certificate: %s", revoked.Name)
+		c.provisionCertificates([]string{revoked.Name})
+	}
+}
+
+// findRevokeTarget searches the records of the zones for the certificate target with the certificate name or
+// covering the FQDN. It returns nil when no record matches, or when no resource group is configured to search.
+func findRevokeTarget(ctx context.Context, azureClients *azure.Clients, namer *certificate.Namer, certName, fqdn string) (*zones.Target, error) {
+	discovery, err := discoveryMode()
+	if err != nil {
+		return nil, err
+	}
+	resourceGroupName := viper.GetString("resource-group")
+	if resourceGroupName == "" && discovery == discoveryResourceGroup {
+		utilities.LogDefault("Records not searched without a resource group, the configured Key Vault and ACME server are used")
+		return nil, nil
+	}
+
+	// Only the targets are collected, none is processed
+	var targets []*zones.Target
+	prepare := func(found []*zones.Target) []*zones.Target {
+		targets = namer.AssignNames(found)
+		return nil
+	}
+	if err := enumerateTargets(ctx, zones.NewEnumerator(azureClients), discovery, viper.GetStringSlice("zones"), resourceGroupName, 0, prepare, nil); err != nil {
+		return nil, err
+	}
+	return selectRevokeTarget(targets, certName, fqdn)
+}
+
+// selectRevokeTarget returns the target with the certificate name or covering the FQDN, or nil when none does
+func selectRevokeTarget(targets []*zones.Target, certName, fqdn string) (*zones.Target, error) {
+	fqdn = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(fqdn), "."))
+
+	var found *zones.Target
+	for _, target := range targets {
+		if certName != "" && certificate.CertificateName(target) != certName {
+			continue
+		}
+		if fqdn != "" && !slices.Contains(target.Domains(), fqdn) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s is covered by certificates %s and %s", fqdn, certificate.CertificateName(found), certificate.CertificateName(target))
+		}
+		found = target
+	}
+	return found, nil
+}
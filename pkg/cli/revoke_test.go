@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"testing"
+
+	"azure-ssl-certificate-provisioner/internal/zones"
+)
+
+func TestSelectRevokeTarget(t *testing.T) {
+	web := &zones.Target{Group: "web", Records: []*zones.Record{
+		{FQDN: "www.example.com", Settings: zones.Settings{Vault: "https://web.vault.azure.net"}},
+		{FQDN: "shop.example.com"},
+	}}
+	api := &zones.Target{Records: []*zones.Record{{FQDN: "api.example.com", Wildcard: true}}, CertName: "api-cert"}
+	targets := []*zones.Target{web, api}
+
+	for _, tc := range []struct {
+		certName, fqdn string
+		want           *zones.Target
+	}{
+		{fqdn: "Shop.Example.com.", want: web},
+		{fqdn: "*.api.example.com", want: api},
+		{certName: "api-cert", want: api},
+		{certName: "cert-group-web", want: web},
+		{fqdn: "mail.example.com"},
+		{certName: "cert-mail-example-com"},
+	} {
+		got, err := selectRevokeTarget(targets, tc.certName, tc.fqdn)
+		if err != nil || got != tc.want {
+			t.Errorf("selectRevokeTarget(%q, %q) = %v, %v, want %v", tc.certName, tc.fqdn, got, err, tc.want)
+		}
+	}
+
+	// A name covered by two certificates is ambiguous
+	shop := &zones.Target{Records: []*zones.Record{{FQDN: "shop.example.com"}}}
+	if got, err := selectRevokeTarget(append(targets, shop), "", "shop.example.com"); err == nil {
+		t.Errorf("selectRevokeTarget() of a name covered twice = %v, want an error", got)
+	}
+}
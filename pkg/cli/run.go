@@ -2,14 +2,16 @@ package cli
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"os"
+	"slices"
 
 	"github.com/go-acme/lego/v4/lego"
-	legoAzure "github.com/go-acme/lego/v4/providers/dns/azuredns"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"azure-ssl-certificate-provisioner/internal/resolver"
+	"azure-ssl-certificate-provisioner/internal/types"
 	"azure-ssl-certificate-provisioner/internal/utilities"
 	"azure-ssl-certificate-provisioner/internal/zones"
 	"azure-ssl-certificate-provisioner/pkg/acme"
@@ -29,13 +31,8 @@ func (c *Commands) createRunCommand() *cobra.Command {
 		},
 	}
 
-	// Configure flags for run command
-	runCmd.Flags().StringSliceP("zones", "z", nil, "DNS zone(s) to search for records (can be used multiple times). If omitted, all zones in the resource group will be scanned")
-	runCmd.Flags().StringP("subscription", "s", "", "Azure subscription ID")
-	runCmd.Flags().StringP("resource-group", "g", "", "Azure resource group name")
-	runCmd.Flags().Bool("staging", true, "Use Let's Encrypt staging environment")
-	runCmd.Flags().IntP("expire-threshold", "t", 7, "Certificate expiration threshold in days")
-	runCmd.Flags().StringP("email", "e", "", "Email address for ACME account registration (required)")
+	addRunFlags(runCmd)
+	bindFlagsOnRun(runCmd, runFlagBindings())
 
 	// Mark required flags
 	// Note: All these parameters can be provided via environment variables, so we don't use MarkFlagRequired
@@ -45,12 +42,113 @@ func (c *Commands) createRunCommand() *cobra.Command {
 	return runCmd
 }
 
+// addRunFlags adds the flags of the run command, which also configure the certificates reissued by revoke
+func addRunFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringSliceP("zones", "z", nil, "DNS zone(s) to search for records (can be used multiple times). If omitted, all zones in the resource group will be scanned")
+	flags.StringP("subscription", "s", "", "Azure subscription ID")
+	flags.StringP("resource-group", "g", "", "Azure resource group name")
+	flags.String("discovery", discoveryResourceGroup, "Record discovery: resource-group (zones of --resource-group) or resource-graph (Resource Graph query across the discovery subscriptions and management groups)")
+	flags.StringSlice("discovery-subscription", nil, "Subscription searched in resource-graph discovery mode (can be used multiple times, default: --subscription)")
+	flags.StringSlice("discovery-management-group", nil, "Management group searched in resource-graph discovery mode (can be used multiple times)")
+	flags.Bool("staging", true, "Use Let's Encrypt staging environment (ignored when --acme-server is set)")
+	flags.String("acme-server", "", acmeServerHelp())
+	flags.StringSlice("ca-bundle", nil, "PEM file(s) with additional CA certificates to trust for the ACME server (can be used multiple times)")
+	flags.IntP("expire-threshold", "t", 7, "Certificate expiration threshold in days")
+	flags.StringP("email", "e", "", "Email address for ACME account registration (required)")
+	flags.StringP("key-type", "k", certificate.DefaultKeyType, "Certificate key type (rsa2048, rsa3072, rsa4096, ec256, ec384)")
+	flags.String("account-storage", acme.AccountStorageFilesystem, "ACME account storage: filesystem (lego-compatible) or keyvault (Key Vault secrets)")
+	flags.Bool("recreate-account", false, "Register a new ACME account with a new key when the stored account was deactivated or revoked by the CA")
+	flags.String("eab-kid", "", "External account binding key ID, for ACME servers that require EAB (e.g. ZeroSSL, Google)")
+	flags.String("eab-hmac", "", "External account binding HMAC key (base64url encoded)")
+	flags.String("eab-hmac-secret", "", "Name of the Key Vault secret holding the EAB HMAC key, used when --eab-hmac is not set")
+	flags.StringSlice("dns-resolvers", nil, "Recursive nameservers used for DNS-01 propagation checks, e.g. 8.8.8.8:53 (can be used multiple times)")
+	flags.String("challenge", certificate.ChallengeDNS01, "Default challenge type: dns-01 or http-01 (acme-challenge metadata overrides it per certificate)")
+	flags.String("http-responder", httpResponderStandalone, "HTTP-01 responder: standalone (built-in server), directory or storage (Azure Storage static website)")
+	flags.String("http-listen", ":80", "Listen address of the standalone HTTP-01 responder")
+	flags.String("http-directory", "", "Web root directory the directory HTTP-01 responder writes challenge files to")
+	flags.String("http-storage-account-url", "", "Blob endpoint of the storage account used by the storage HTTP-01 responder, e.g. https://account.blob.core.windows.net")
+	flags.String("http-storage-container", azure.StaticWebsiteContainer, "Container used by the storage HTTP-01 responder")
+	flags.StringToString("challenge-alias", nil, "Delegate the DNS-01 challenge of an FQDN to a name in an Azure DNS zone, e.g. www.example.com=www.validation.example.net (can be used multiple times)")
+	flags.String("dns-propagation-check", propagationCheckAuthoritative, "DNS-01 propagation check: authoritative (zone nameservers only), recursive, all or none")
+	flags.String("dns-propagation-timeout", "", "Maximum time to wait for DNS-01 records to propagate, e.g. 5m (default: 2m)")
+	flags.String("dns-polling-interval", "", "Interval between DNS-01 propagation checks, e.g. 5s (default: 2s)")
+	flags.Int("dns-ttl", 0, "TTL of DNS-01 challenge records in seconds (default: 60)")
+	flags.Bool("zone-delegation-check", true, "Skip DNS zones that their parent zone does not delegate to the Azure nameservers in public DNS")
+	flags.Bool("caa-check", true, "Check that CAA records permit the CA to issue before ordering certificates")
+	flags.StringSlice("caa-identities", nil, "CA issuer domain names expected in CAA records, e.g. letsencrypt.org (default: caaIdentities of the ACME directory)")
+	flags.String("caa-records", caaRecordsOff, "Manage CAA records in Azure DNS zones of the subscription: off, repair (add the CA to record sets that do not permit it) or create (also create records in zones without CAA records)")
+	flags.Bool("cleanup-challenges", false, "Remove stale DNS-01 challenge records from the zones before processing them")
+	flags.String("challenge-max-age", "", "Age after which a challenge record is considered stale by --cleanup-challenges, e.g. 30m (default: 1h)")
+	flags.String("preferred-chain", "", "Common name of the root certificate of an alternate chain offered by the CA")
+	flags.StringSlice("store", []string{certificate.StoreKeyVault}, "Certificate store(s) to write to: keyvault, filesystem, lego (can be used multiple times)")
+	flags.String("certificate-path", "certificates", "Directory used by the filesystem certificate store")
+	flags.String("lego-path", "", "lego root directory for ACME accounts and the lego certificate store (default: ~/.lego)")
+	flags.String("name-template", "", "Go template for Key Vault certificate names (fields: .FQDN, .Zone, .Record, .Group, .Wildcard)")
+	flags.Bool("key-vault-generate-keys", false, "Generate private keys inside Key Vault and obtain certificates for their CSR")
+	flags.Bool("key-vault-hsm", false, "Use HSM-backed keys when generating keys in Key Vault (Premium vaults only)")
+	flags.Bool("key-vault-exportable", false, "Allow the private key to be exported from Key Vault (default: true for imported keys, false for keys generated in Key Vault)")
+	flags.String("key-vault-content-type", "pkcs12", "Content type of the Key Vault certificate secret (pkcs12, pem)")
+	flags.StringSlice("key-vault-lifetime-actions", nil, "Key Vault lifetime actions, e.g. EmailContacts:30d or EmailContacts:80% (can be used multiple times)")
+}
+
+// runFlagBindings returns the viper keys of the flags added by addRunFlags (key: flag name)
+func runFlagBindings() map[string]string {
+	return map[string]string{
+		"zones":                       "zones",
+		"subscription":                "subscription",
+		"resource-group":              "resource-group",
+		"discovery":                   "discovery",
+		"discovery-subscriptions":     "discovery-subscription",
+		"discovery-management-groups": "discovery-management-group",
+		"staging":                     "staging",
+		"acme-server":                 "acme-server",
+		"acme-ca-bundle":              "ca-bundle",
+		"expire-threshold":            "expire-threshold",
+		"email":                       "email",
+		"key-type":                    "key-type",
+		"dns-resolvers":               "dns-resolvers",
+		"challenge":                   "challenge",
+		"http-responder":              "http-responder",
+		"http-listen":                 "http-listen",
+		"http-directory":              "http-directory",
+		"http-storage-account-url":    "http-storage-account-url",
+		"http-storage-container":      "http-storage-container",
+		"challenge-aliases":           "challenge-alias",
+		"dns-propagation-check":       "dns-propagation-check",
+		"dns-propagation-timeout":     "dns-propagation-timeout",
+		"dns-polling-interval":        "dns-polling-interval",
+		"dns-ttl":                     "dns-ttl",
+		"cleanup-challenges":          "cleanup-challenges",
+		"zone-delegation-check":       "zone-delegation-check",
+		"caa-check":                   "caa-check",
+		"caa-identities":              "caa-identities",
+		"caa-records":                 "caa-records",
+		"challenge-max-age":           "challenge-max-age",
+		"preferred-chain":             "preferred-chain",
+		"account-storage":             "account-storage",
+		"recreate-account":            "recreate-account",
+		"eab-kid":                     "eab-kid",
+		"eab-hmac":                    "eab-hmac",
+		"eab-hmac-secret":             "eab-hmac-secret",
+		"stores":                      "store",
+		"certificate-path":            "certificate-path",
+		"lego-path":                   "lego-path",
+		"certificate-name-template":   "name-template",
+		"key-vault-generate-keys":     "key-vault-generate-keys",
+		"key-vault-hsm":               "key-vault-hsm",
+		"key-vault-exportable":        "key-vault-exportable",
+		"key-vault-content-type":      "key-vault-content-type",
+		"key-vault-lifetime-actions":  "key-vault-lifetime-actions",
+	}
+}
+
 // createListCommand creates the list command
 func (c *Commands) createListCommand() *cobra.Command {
 	var listCmd = &cobra.Command{
 		Use:   "list",
 		Short: "List DNS records and certificate status",
-		Long:  `Scan Azure DNS zones and list records that would be processed, along with their certificate status from Key Vault.`,
+		Long:  `Scan Azure DNS zones and list records that would be processed, along with their certificate status from the configured certificate stores.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			c.listCertificatesAndRecords()
 		},
@@ -60,30 +158,98 @@ func (c *Commands) createListCommand() *cobra.Command {
 	listCmd.Flags().StringSliceP("zones", "z", nil, "DNS zone(s) to search for records (can be used multiple times). If omitted, all zones in the resource group will be scanned")
 	listCmd.Flags().StringP("subscription", "s", "", "Azure subscription ID")
 	listCmd.Flags().StringP("resource-group", "g", "", "Azure resource group name")
-	listCmd.Flags().Bool("staging", true, "Use Let's Encrypt staging environment")
+	listCmd.Flags().String("discovery", discoveryResourceGroup, "Record discovery: resource-group or resource-graph")
+	listCmd.Flags().StringSlice("discovery-subscription", nil, "Subscription searched in resource-graph discovery mode (can be used multiple times, default: --subscription)")
+	listCmd.Flags().StringSlice("discovery-management-group", nil, "Management group searched in resource-graph discovery mode (can be used multiple times)")
+	listCmd.Flags().Bool("staging", true, "Use Let's Encrypt staging environment (ignored when --acme-server is set)")
+	listCmd.Flags().String("acme-server", "", acmeServerHelp())
+	listCmd.Flags().StringSlice("ca-bundle", nil, "PEM file(s) with additional CA certificates to trust for the ACME server (can be used multiple times)")
 	listCmd.Flags().IntP("expire-threshold", "t", 7, "Certificate expiration threshold in days")
 	listCmd.Flags().StringP("email", "e", "", "Email address for ACME account registration (used for certificate lookup)")
+	listCmd.Flags().StringP("key-type", "k", certificate.DefaultKeyType, "Expected certificate key type (rsa2048, rsa3072, rsa4096, ec256, ec384)")
+	listCmd.Flags().StringSlice("store", []string{certificate.StoreKeyVault}, "Certificate store(s) to check: keyvault, filesystem, lego (can be used multiple times)")
+	listCmd.Flags().String("certificate-path", "certificates", "Directory used by the filesystem certificate store")
+	listCmd.Flags().String("lego-path", "", "lego root directory for ACME accounts and the lego certificate store (default: ~/.lego)")
+	listCmd.Flags().String("name-template", "", "Go template for Key Vault certificate names (fields: .FQDN, .Zone, .Record, .Group, .Wildcard)")
+	listCmd.Flags().String("challenge", certificate.ChallengeDNS01, "Default challenge type: dns-01 or http-01")
+	listCmd.Flags().StringSlice("dns-resolvers", nil, "Recursive nameservers used to check DNS-01 challenge delegations (can be used multiple times)")
+	listCmd.Flags().StringToString("challenge-alias", nil, "Delegate the DNS-01 challenge of an FQDN to a name in an Azure DNS zone (can be used multiple times)")
+	listCmd.Flags().Bool("zone-delegation-check", true, "Check that the parent zones delegate the DNS zones to the Azure nameservers in public DNS")
+	listCmd.Flags().Bool("caa-check", true, "Check that CAA records permit the CA to issue")
+	listCmd.Flags().StringSlice("caa-identities", nil, "CA issuer domain names expected in CAA records (default: caaIdentities of the ACME directory)")
+	listCmd.Flags().String("account-storage", acme.AccountStorageFilesystem, "ACME account storage, read for the account URI matched with CAA accounturi parameters: filesystem or keyvault")
+	bindFlagsOnRun(listCmd, map[string]string{
+		"zones":                       "zones",
+		"subscription":                "subscription",
+		"resource-group":              "resource-group",
+		"staging":                     "staging",
+		"acme-server":                 "acme-server",
+		"acme-ca-bundle":              "ca-bundle",
+		"expire-threshold":            "expire-threshold",
+		"email":                       "email",
+		"key-type":                    "key-type",
+		"stores":                      "store",
+		"certificate-path":            "certificate-path",
+		"lego-path":                   "lego-path",
+		"certificate-name-template":   "name-template",
+		"challenge":                   "challenge",
+		"dns-resolvers":               "dns-resolvers",
+		"challenge-aliases":           "challenge-alias",
+		"caa-check":                   "caa-check",
+		"zone-delegation-check":       "zone-delegation-check",
+		"caa-identities":              "caa-identities",
+		"account-storage":             "account-storage",
+		"discovery":                   "discovery",
+		"discovery-subscriptions":     "discovery-subscription",
+		"discovery-management-groups": "discovery-management-group",
+	})
 
 	return listCmd
 }
 
 // runCertificateProvisioner executes the main certificate provisioning logic
 func (c *Commands) runCertificateProvisioner() {
+	c.provisionCertificates(nil)
+}
+
+// provisionCertificates scans the DNS zones and provisions certificates for marked records.
+// When forced is not empty, only the certificates with the listed names are processed and
+// they are reissued regardless of their expiration date.
+func (c *Commands) provisionCertificates(forced []string) {
 	ctx := context.Background()
 
 	// Get configuration values
 	zonesList := viper.GetStringSlice("zones")
 	subscriptionId := viper.GetString("subscription")
 	resourceGroupName := viper.GetString("resource-group")
-	staging := viper.GetBool("staging")
 	expireThreshold := viper.GetInt("expire-threshold")
 	email := viper.GetString("email")
 
+	keyType, err := certificate.ParseKeyType(viper.GetString("key-type"))
+	if err != nil {
+		log.Fatalf("Invalid key type: %v", err)
+	}
+
+	storeKinds, err := selectedStores()
+	if err != nil {
+		log.Fatalf("Invalid certificate stores: %v", err)
+	}
+
+	namer, err := certificateNamer()
+	if err != nil {
+		log.Fatalf("Invalid certificate naming: %v", err)
+	}
+
 	if subscriptionId == "" {
 		log.Fatalf("Subscription ID not specified.")
 	}
 
-	if resourceGroupName == "" {
+	discovery, err := discoveryMode()
+	if err != nil {
+		log.Fatalf("Invalid discovery settings: %v", err)
+	}
+
+	if resourceGroupName == "" && discovery == discoveryResourceGroup {
 		log.Fatalf("Resource Group Name not specified.")
 	}
 
@@ -91,9 +257,14 @@ func (c *Commands) runCertificateProvisioner() {
 		log.Fatalf("Email address not specified.")
 	}
 
+	accountStorage, err := selectedAccountStorage()
+	if err != nil {
+		log.Fatalf("Invalid account storage: %v", err)
+	}
+
 	// Validate all required environment variables
 	// Validate required environment variables
-	if err := config.ValidateRequiredEnvVars(); err != nil {
+	if err := config.ValidateRequiredEnvVars(usesKeyVault(storeKinds) || accountStorage == acme.AccountStorageKeyVault); err != nil {
 		log.Fatalf("Environment validation failed: %v", err)
 	}
 
@@ -105,98 +276,116 @@ func (c *Commands) runCertificateProvisioner() {
 		log.Fatalf("Failed to create Azure clients: %v", err)
 	}
 
-	// Configure ACME server based on staging flag
-	var serverURL string
-	if staging {
-		serverURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
-		utilities.LogDefault("ACME environment: staging")
-	} else {
-		serverURL = "https://acme-v02.api.letsencrypt.org/directory"
-		utilities.LogDefault("ACME environment: production")
-	}
-
-	// Load or create ACME account with persistence
-	user, err := acme.LoadOrCreateAccount(email, serverURL)
+	// Configure ACME server from the acme-server preset or URL, falling back to the staging flag
+	serverURL, err := acmeServerURL()
 	if err != nil {
-		log.Fatalf("failed to load or create ACME account: %v", err)
+		log.Fatalf("Invalid ACME server: %v", err)
 	}
 
-	config := lego.NewConfig(user)
-	if config == nil {
-		log.Fatalf("failed to create ACME config")
+	// Create the DNS-01 provider on the Azure DNS client, sharing the credential of the other clients
+	provider, err := newDNSProvider(azureClients, discovery)
+	if err != nil {
+		log.Fatalf("Invalid DNS challenge settings: %v", err)
 	}
 
-	config.CADirURL = serverURL
-
-	acmeClient, err := lego.NewClient(config)
+	dnsOptions, err := dns01Options()
 	if err != nil {
-		log.Fatalf("failed to create ACME client: %v", err)
+		log.Fatalf("Invalid DNS challenge settings: %v", err)
 	}
 
-	// Set environment variables for the Azure DNS provider
-	// The azuredns provider reads directly from environment variables
-	if err := setAzureDNSEnvironment(subscriptionId, resourceGroupName); err != nil {
-		log.Fatalf("failed to configure Azure DNS environment: %v", err)
+	// Remove challenge records left behind by interrupted runs before presenting new ones
+	if viper.GetBool("cleanup-challenges") {
+		maxAge, err := durationSetting("challenge-max-age", defaultChallengeMaxAge)
+		if err != nil {
+			log.Fatalf("Invalid challenge cleanup settings: %v", err)
+		}
+		if dnsZones, err := discoverZones(ctx, azureClients, discovery, zonesList, resourceGroupName); err != nil {
+			utilities.LogDefault("Challenge cleanup failed: %v", err)
+		} else {
+			// Record sets of unknown age may belong to other ACME clients and are never removed here
+			cleanupChallenges(ctx, azureClients, dnsZones, maxAge, false, false)
+		}
 	}
 
-	// Create Azure DNS provider - it will automatically detect the authentication method
-	provider, err := legoAzure.NewDNSProvider()
+	httpProvider, err := newHTTPProvider(azureClients)
 	if err != nil {
-		log.Fatalf("failed to initialise Azure DNS provider: %v", err)
+		log.Fatalf("Invalid HTTP challenge settings: %v", err)
 	}
 
-	if err := acmeClient.Challenge.SetDNS01Provider(provider); err != nil {
-		log.Fatalf("failed to set DNS challenge provider: %v", err)
+	defaultChallenge, err := certificate.ParseChallengeType(viper.GetString("challenge"))
+	if err != nil {
+		log.Fatalf("Invalid challenge type: %v", err)
 	}
+	utilities.LogDefault("Default challenge type: %s", defaultChallenge)
 
-	// Only register if we don't have existing registration
-	if user.Registration == nil {
-		if err := acme.RegisterAccount(user, acmeClient); err != nil {
-			log.Fatalf("Failed to register ACME account: %v", err)
-		}
+	preferredChain := viper.GetString("preferred-chain")
+	if preferredChain != "" {
+		utilities.LogDefault("Preferred certificate chain: %s", preferredChain)
+	}
 
-		// Save the account data for future runs
-		if err := acme.SaveAccountData(user, serverURL); err != nil {
-			utilities.LogDefault("ACME account save failed: %v", err)
-		} else {
-			utilities.LogDefault("ACME account saved successfully")
+	caaMode, err := caaRecordsMode()
+	if err != nil {
+		log.Fatalf("Invalid CAA settings: %v", err)
+	}
+	res := resolver.NewResolver(dnsResolvers())
+
+	// Create the certificate handler of the configured ACME server and Key Vault, which loads or
+	// registers the ACME account; targets with acme-server or acme-vault metadata get handlers of their own
+	certHandlers, err := newCertificateHandlers(ctx, &handlerSettings{
+		email:            email,
+		eabServer:        serverURL,
+		storeKinds:       storeKinds,
+		keyType:          keyType,
+		preferredChain:   preferredChain,
+		defaultChallenge: defaultChallenge,
+		caaMode:          caaMode,
+		forced:           forced,
+		azureClients:     azureClients,
+		provider:         provider,
+		dnsOptions:       dnsOptions,
+		httpProvider:     httpProvider,
+		resolver:         res,
+	}, serverURL, vaultURL)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	utilities.LogDefault("Default certificate key type: %s", certificate.KeyTypeName(keyType))
+
+	processor := certHandlers.ProcessTarget
+	if len(forced) > 0 {
+		processor = func(ctx context.Context, target *zones.Target, expireThreshold int) {
+			if slices.Contains(forced, certificate.CertificateName(target)) {
+				certHandlers.ProcessTarget(ctx, target, expireThreshold)
+			}
 		}
-	} else {
-		utilities.LogDefault("ACME account loaded: %s", user.Email)
 	}
 
-	// Create certificate handler
-	certHandler := certificate.NewHandler(acmeClient, azureClients.KVCert)
-
 	// Create zones enumerator and process zones
 	enumerator := zones.NewEnumerator(azureClients)
-	if err := enumerator.EnumerateAndProcess(ctx, zonesList, resourceGroupName, expireThreshold, certHandler.ProcessFQDN); err != nil {
+	if viper.GetBool("zone-delegation-check") {
+		enumerator.SetDelegationCheck(res)
+	}
+	if err := enumerateTargets(ctx, enumerator, discovery, zonesList, resourceGroupName, expireThreshold, withChallengeAliases(withChallengeZones(provider, namer.AssignNames)), processor); err != nil {
 		log.Fatalf("Failed to enumerate and process zones: %v", err)
 	}
-}
 
-// setAzureDNSEnvironment configures environment variables required by the azuredns provider
-func setAzureDNSEnvironment(subscriptionID, resourceGroup string) error {
-	// Set required environment variables for the azuredns provider
-	if subscriptionID != "" {
-		os.Setenv("AZURE_SUBSCRIPTION_ID", subscriptionID)
-	}
-	if resourceGroup != "" {
-		os.Setenv("AZURE_RESOURCE_GROUP", resourceGroup)
+	if invalid := len(enumerator.MetadataErrors()); invalid > 0 {
+		utilities.LogDefault("Records skipped because of invalid metadata: %d", invalid)
 	}
+}
 
-	// Set auth method if specified via viper
-	authMethod := viper.GetString("azure-auth-method")
-	if authMethod != "" {
-		os.Setenv("AZURE_AUTH_METHOD", authMethod)
-		utilities.LogDefault("Azure DNS authentication method: %s", authMethod)
+// newAccountClient loads the ACME account of an email address, or creates a new unregistered one,
+// and returns an ACME client using it
+func newAccountClient(ctx context.Context, store acme.AccountStore, email, serverURL string) (*lego.Client, *types.AcmeUser, error) {
+	user, err := acme.LoadOrCreateAccount(ctx, store, email, acme.DefaultAccountKeyType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load or create ACME account: %v", err)
 	}
 
-	// Set MSI timeout if specified
-	msiTimeout := viper.GetString("azure-auth-msi-timeout")
-	if msiTimeout != "" {
-		os.Setenv("AZURE_AUTH_MSI_TIMEOUT", msiTimeout)
+	acmeClient, err := acme.NewClient(user, serverURL)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return acmeClient, user, nil
 }
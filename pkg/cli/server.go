@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+)
+
+// acmeServerURL resolves the ACME directory URL from the acme-server and staging settings
+// and loads the custom CA bundle, when one is configured, for the ACME connections
+func acmeServerURL() (string, error) {
+	serverURL, err := acme.ResolveServerURL(viper.GetString("acme-server"), viper.GetBool("staging"))
+	if err != nil {
+		return "", err
+	}
+
+	if bundle := caBundlePaths(); len(bundle) > 0 {
+		if err := acme.LoadCABundle(bundle); err != nil {
+			return "", err
+		}
+		utilities.LogDefault("ACME CA bundle: %s", strings.Join(bundle, ", "))
+	}
+
+	utilities.LogDefault("ACME server: %s", serverURL)
+	return serverURL, nil
+}
+
+// caBundlePaths returns the CA bundle files of the acme-ca-bundle setting. Entries may hold
+// several paths separated by the OS path list separator, like LEGO_CA_CERTIFICATES.
+func caBundlePaths() []string {
+	var paths []string
+	for _, entry := range viper.GetStringSlice("acme-ca-bundle") {
+		for _, path := range strings.Split(entry, string(os.PathListSeparator)) {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// acmeServerHelp describes the acme-server flag, listing the supported presets
+func acmeServerHelp() string {
+	return fmt.Sprintf("ACME directory URL or preset (%s); overrides --staging", strings.Join(acme.ServerPresets(), ", "))
+}
+
+// externalAccountBinding reads the EAB credentials from the eab-kid and eab-hmac settings. The HMAC key
+// is read from the Key Vault secret named by eab-hmac-secret when it is not set directly.
+func externalAccountBinding(ctx context.Context, azureClients *azure.Clients) (*acme.ExternalAccountBinding, error) {
+	keyID := viper.GetString("eab-kid")
+	hmac := viper.GetString("eab-hmac")
+
+	if secretName := viper.GetString("eab-hmac-secret"); hmac == "" && secretName != "" {
+		if viper.GetString("key-vault-url") == "" {
+			return nil, fmt.Errorf("reading the EAB HMAC key from secret %s requires AZURE_KEY_VAULT_URL", secretName)
+		}
+
+		resp, err := azureClients.KVSecret.GetSecret(ctx, secretName, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EAB HMAC key from Key Vault secret %s: %v", secretName, err)
+		}
+		if resp.Value == nil {
+			return nil, fmt.Errorf("EAB HMAC key secret %s is empty", secretName)
+		}
+		hmac = *resp.Value
+		utilities.LogVerbose("EAB HMAC key read from Key Vault secret %s", secretName)
+	}
+
+	return acme.NewExternalAccountBinding(keyID, hmac)
+}
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/internal/utilities"
+	"azure-ssl-certificate-provisioner/pkg/acme"
+	"azure-ssl-certificate-provisioner/pkg/azure"
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+// selectedStores returns the certificate store kinds selected with the stores setting
+func selectedStores() ([]string, error) {
+	return certificate.ParseStoreKinds(viper.GetStringSlice("stores"))
+}
+
+// certificateNamer creates the certificate namer from the certificate-name-template setting
+func certificateNamer() (*certificate.Namer, error) {
+	return certificate.NewNamer(viper.GetString("certificate-name-template"))
+}
+
+// keyVaultImportPolicy builds the Key Vault certificate policy from the configuration.
+// Keys are exportable by default, except for keys generated inside Key Vault.
+func keyVaultImportPolicy(generateKeys bool) (*certificate.ImportPolicy, error) {
+	policy := certificate.DefaultImportPolicy()
+
+	policy.Exportable = !generateKeys
+	if viper.IsSet("key-vault-exportable") {
+		policy.Exportable = viper.GetBool("key-vault-exportable")
+	}
+
+	contentType, err := certificate.ParseContentType(viper.GetString("key-vault-content-type"))
+	if err != nil {
+		return nil, err
+	}
+	policy.ContentType = contentType
+
+	policy.LifetimeActions, err = certificate.ParseLifetimeActions(viper.GetStringSlice("key-vault-lifetime-actions"))
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// createCertificateStores builds the certificate stores of the given kinds. vaultURL selects the vault of
+// the keyvault store, empty for the key-vault-url setting. directoryURL is the ACME directory recorded
+// with certificates stored in Key Vault.
+func createCertificateStores(kinds []string, azureClients *azure.Clients, vaultURL, directoryURL string) ([]certificate.Store, error) {
+	if vaultURL == "" {
+		vaultURL = viper.GetString("key-vault-url")
+	}
+
+	var stores []certificate.Store
+	for _, kind := range kinds {
+		switch kind {
+		case certificate.StoreKeyVault:
+			kvCert, err := azureClients.Certificates(vaultURL)
+			if err != nil {
+				return nil, err
+			}
+
+			generateKeys := viper.GetBool("key-vault-generate-keys")
+			policy, err := keyVaultImportPolicy(generateKeys)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Key Vault certificate policy: %v", err)
+			}
+
+			if generateKeys {
+				hsm := viper.GetBool("key-vault-hsm")
+				stores = append(stores, certificate.NewKeyVaultCSRStore(kvCert, policy, directoryURL, hsm))
+				utilities.LogDefault("Certificate store: %s (%s, keys generated in Key Vault, hsm=%t, exportable=%t, content_type=%s)", kind, vaultURL, hsm, policy.Exportable, policy.ContentType)
+			} else {
+				stores = append(stores, certificate.NewKeyVaultStore(kvCert, policy, directoryURL))
+				utilities.LogDefault("Certificate store: %s (%s, exportable=%t, content_type=%s)", kind, vaultURL, policy.Exportable, policy.ContentType)
+			}
+		case certificate.StoreFilesystem:
+			certPath := viper.GetString("certificate-path")
+			stores = append(stores, certificate.NewFilesystemStore(certPath))
+			utilities.LogDefault("Certificate store: %s (%s)", kind, certPath)
+		case certificate.StoreLego:
+			rootPath, err := legoPath()
+			if err != nil {
+				return nil, err
+			}
+			stores = append(stores, certificate.NewLegoStore(rootPath))
+			utilities.LogDefault("Certificate store: %s (%s)", kind, rootPath)
+		}
+	}
+
+	if viper.GetBool("key-vault-generate-keys") {
+		if !usesKeyVault(kinds) {
+			return nil, fmt.Errorf("key generation in Key Vault requires the %s store", certificate.StoreKeyVault)
+		}
+		if len(kinds) > 1 {
+			utilities.LogDefault("Private keys are generated in Key Vault, other stores receive certificates without private keys")
+		}
+	}
+
+	return stores, nil
+}
+
+// usesKeyVault reports whether certificates are stored in Key Vault
+func usesKeyVault(kinds []string) bool {
+	return slices.Contains(kinds, certificate.StoreKeyVault)
+}
+
+// legoPath returns the lego root directory of the lego-path setting, ~/.lego by default.
+// It holds the local account storage and the certificates of the lego store.
+func legoPath() (string, error) {
+	if path := viper.GetString("lego-path"); path != "" {
+		return path, nil
+	}
+	return acme.DefaultLegoPath()
+}
+
+// selectedAccountStorage returns the account storage location selected with the account-storage setting
+func selectedAccountStorage() (string, error) {
+	return acme.ParseAccountStorage(viper.GetString("account-storage"))
+}
+
+// createAccountStore creates the storage of the ACME account of an email address. The first time
+// the Key Vault storage is used, an account found in the local account storage is copied into it.
+func createAccountStore(ctx context.Context, email, serverURL string, azureClients *azure.Clients) (acme.AccountStore, error) {
+	kind, err := selectedAccountStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	rootPath, err := legoPath()
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := acme.NewAccountStorage(rootPath, email, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create accounts storage: %v", err)
+	}
+
+	if kind == acme.AccountStorageFilesystem {
+		return local, nil
+	}
+
+	store, err := acme.NewKeyVaultAccountStorage(azureClients.KVSecret, email, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault account storage: %v", err)
+	}
+
+	migrated, err := acme.MigrateAccount(ctx, local, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate local ACME account to Key Vault: %v", err)
+	}
+	if migrated {
+		utilities.LogDefault("ACME account migrated to Key Vault, the local copy in %s is no longer used", local.Location())
+	}
+
+	utilities.LogVerbose("ACME account storage: %s", store.Location())
+	return store, nil
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"azure-ssl-certificate-provisioner/pkg/certificate"
+)
+
+func TestCreateCertificateStoresKeyGenerationRequiresKeyVault(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("key-vault-generate-keys", true)
+	viper.Set("certificate-path", t.TempDir())
+
+	if _, err := createCertificateStores([]string{certificate.StoreFilesystem}, nil, "", ""); err == nil {
+		t.Error("createCertificateStores() generating keys in Key Vault without the keyvault store did not fail")
+	}
+
+	viper.Set("key-vault-generate-keys", false)
+	stores, err := createCertificateStores([]string{certificate.StoreFilesystem}, nil, "", "")
+	if err != nil || len(stores) != 1 || stores[0].Name() != certificate.StoreFilesystem {
+		t.Errorf("createCertificateStores() = %v, %v, want the filesystem store", stores, err)
+	}
+}
+
+func TestLegoPath(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if got, err := legoPath(); err != nil || got != filepath.Join(home, ".lego") {
+		t.Errorf("legoPath() = %q, %v, want ~/.lego", got, err)
+	}
+
+	viper.Set("lego-path", "/data/lego")
+	if got, err := legoPath(); err != nil || got != "/data/lego" {
+		t.Errorf("legoPath() = %q, %v, want the lego-path setting", got, err)
+	}
+}
@@ -184,10 +184,47 @@ func (g *TemplateGenerator) generateJSONConfig() {
 	fmt.Print(`{
   "subscription": "your-azure-subscription-id",
   "resource-group": "your-resource-group-name",
+  "discovery": "resource-group",
+  "discovery-subscriptions": [],
+  "discovery-management-groups": [],
   "key-vault-url": "https://your-keyvault.vault.azure.net/",
   "email": "your-email@example.com",
   "staging": true,
+  "acme-server": "",
+  "acme-ca-bundle": [],
+  "account-storage": "filesystem",
+  "recreate-account": false,
+  "eab-kid": "",
+  "eab-hmac-secret": "",
   "expire-threshold": 7,
+  "key-type": "rsa2048",
+  "preferred-chain": "",
+  "challenge": "dns-01",
+  "http-responder": "standalone",
+  "http-listen": ":80",
+  "http-directory": "",
+  "http-storage-account-url": "",
+  "http-storage-container": "$web",
+  "dns-resolvers": [],
+  "challenge-aliases": {},
+  "dns-propagation-check": "authoritative",
+  "dns-propagation-timeout": "2m",
+  "dns-polling-interval": "2s",
+  "dns-ttl": 60,
+  "cleanup-challenges": false,
+  "challenge-max-age": "1h",
+  "zone-delegation-check": true,
+  "caa-check": true,
+  "caa-identities": [],
+  "caa-records": "off",
+  "stores": ["keyvault"],
+  "certificate-path": "certificates",
+  "lego-path": "",
+  "certificate-name-template": "",
+  "key-vault-generate-keys": false,
+  "key-vault-hsm": false,
+  "key-vault-content-type": "pkcs12",
+  "key-vault-lifetime-actions": [],
   "azure-client-id": "your-service-principal-client-id",
   "azure-client-secret": "your-service-principal-client-secret",
   "azure-tenant-id": "your-azure-tenant-id",
@@ -206,10 +243,46 @@ func (g *TemplateGenerator) generateTOMLConfig() {
 	fmt.Print(`# Azure SSL Certificate Provisioner Configuration
 subscription = "your-azure-subscription-id"
 resource-group = "your-resource-group-name"
+discovery = "resource-group"
+discovery-subscriptions = []
+discovery-management-groups = []
 key-vault-url = "https://your-keyvault.vault.azure.net/"
 email = "your-email@example.com"
 staging = true
+acme-server = ""
+acme-ca-bundle = []
+account-storage = "filesystem"
+recreate-account = false
+eab-kid = ""
+eab-hmac-secret = ""
 expire-threshold = 7
+key-type = "rsa2048"
+preferred-chain = ""
+challenge = "dns-01"
+http-responder = "standalone"
+http-listen = ":80"
+http-directory = ""
+http-storage-account-url = ""
+http-storage-container = "$web"
+dns-resolvers = []
+dns-propagation-check = "authoritative"
+dns-propagation-timeout = "2m"
+dns-polling-interval = "2s"
+dns-ttl = 60
+cleanup-challenges = false
+challenge-max-age = "1h"
+zone-delegation-check = true
+caa-check = true
+caa-identities = []
+caa-records = "off"
+stores = ["keyvault"]
+certificate-path = "certificates"
+lego-path = ""
+certificate-name-template = ""
+key-vault-generate-keys = false
+key-vault-hsm = false
+key-vault-content-type = "pkcs12"
+key-vault-lifetime-actions = []
 azure-client-id = "your-service-principal-client-id"
 azure-client-secret = "your-service-principal-client-secret"
 azure-tenant-id = "your-azure-tenant-id"
@@ -219,7 +292,11 @@ kv-name = "your-keyvault-name"
 kv-resource-group = "your-keyvault-resource-group"
 sp-no-roles = false
 sp-use-cert-auth = false
-shell = "bash"`)
+shell = "bash"
+
+# Delegated DNS-01 challenges (FQDN = name its _acme-challenge CNAME points to)
+[challenge-aliases]
+# "www.example.com" = "www.validation.example.net"`)
 }
 
 // generateYAMLConfig generates YAML configuration template
@@ -227,10 +304,48 @@ func (g *TemplateGenerator) generateYAMLConfig() {
 	fmt.Print(`# Azure SSL Certificate Provisioner Configuration
 subscription: "your-azure-subscription-id"
 resource-group: "your-resource-group-name"
+discovery: "resource-group"
+discovery-subscriptions: []
+discovery-management-groups: []
 key-vault-url: "https://your-keyvault.vault.azure.net/"
 email: "your-email@example.com"
 staging: true
+acme-server: ""
+acme-ca-bundle: []
+account-storage: "filesystem"
+recreate-account: false
+eab-kid: ""
+eab-hmac-secret: ""
 expire-threshold: 7
+key-type: "rsa2048"
+preferred-chain: ""
+challenge: "dns-01"
+http-responder: "standalone"
+http-listen: ":80"
+http-directory: ""
+http-storage-account-url: ""
+http-storage-container: "$web"
+dns-resolvers: []
+challenge-aliases: {}
+dns-propagation-check: "authoritative"
+dns-propagation-timeout: "2m"
+dns-polling-interval: "2s"
+dns-ttl: 60
+cleanup-challenges: false
+challenge-max-age: "1h"
+zone-delegation-check: true
+caa-check: true
+caa-identities: []
+caa-records: "off"
+stores:
+  - "keyvault"
+certificate-path: "certificates"
+lego-path: ""
+certificate-name-template: ""
+key-vault-generate-keys: false
+key-vault-hsm: false
+key-vault-content-type: "pkcs12"
+key-vault-lifetime-actions: []
 azure-client-id: "your-service-principal-client-id"
 azure-client-secret: "your-service-principal-client-secret"
 azure-tenant-id: "your-azure-tenant-id"
@@ -9,11 +9,12 @@ import (
 	"azure-ssl-certificate-provisioner/internal/utilities"
 )
 
-// ValidateRequiredEnvVars validates that all required environment variables are set
-func ValidateRequiredEnvVars() error {
+// ValidateRequiredEnvVars validates that all required environment variables are set.
+// The Key Vault URL is only required when certificates are stored in Key Vault.
+func ValidateRequiredEnvVars(requireKeyVault bool) error {
 	// Check Azure Key Vault URL
 	vaultURL := viper.GetString("key-vault-url")
-	if vaultURL == "" {
+	if requireKeyVault && vaultURL == "" {
 		return fmt.Errorf("AZURE_KEY_VAULT_URL environment variable is required")
 	}
 
@@ -97,6 +98,45 @@ func SetupViper() {
 	viper.BindEnv("resource-group", "AZURE_RESOURCE_GROUP")
 	viper.BindEnv("key-vault-url", "AZURE_KEY_VAULT_URL")
 	viper.BindEnv("email", "LEGO_EMAIL")
+	viper.BindEnv("key-type", "LEGO_KEY_TYPE")
+	viper.BindEnv("preferred-chain", "LEGO_PREFERRED_CHAIN")
+	viper.BindEnv("dns-resolvers", "DNS_RESOLVERS")
+	viper.BindEnv("challenge", "ACME_CHALLENGE")
+	viper.BindEnv("http-responder", "HTTP_RESPONDER")
+	viper.BindEnv("http-listen", "HTTP_LISTEN")
+	viper.BindEnv("http-directory", "HTTP_DIRECTORY")
+	viper.BindEnv("http-storage-account-url", "HTTP_STORAGE_ACCOUNT_URL")
+	viper.BindEnv("http-storage-container", "HTTP_STORAGE_CONTAINER")
+	viper.BindEnv("challenge-aliases", "CHALLENGE_ALIASES")
+	viper.BindEnv("dns-propagation-check", "DNS_PROPAGATION_CHECK")
+	viper.BindEnv("dns-propagation-timeout", "DNS_PROPAGATION_TIMEOUT")
+	viper.BindEnv("dns-polling-interval", "DNS_POLLING_INTERVAL")
+	viper.BindEnv("dns-ttl", "DNS_TTL")
+	viper.BindEnv("cleanup-challenges", "CLEANUP_CHALLENGES")
+	viper.BindEnv("challenge-max-age", "CHALLENGE_MAX_AGE")
+	viper.BindEnv("zone-delegation-check", "ZONE_DELEGATION_CHECK")
+	viper.BindEnv("discovery", "DISCOVERY")
+	viper.BindEnv("discovery-subscriptions", "DISCOVERY_SUBSCRIPTIONS")
+	viper.BindEnv("discovery-management-groups", "DISCOVERY_MANAGEMENT_GROUPS")
+	viper.BindEnv("caa-check", "CAA_CHECK")
+	viper.BindEnv("caa-identities", "CAA_IDENTITIES")
+	viper.BindEnv("caa-records", "CAA_RECORDS")
+	viper.BindEnv("acme-server", "LEGO_SERVER")
+	viper.BindEnv("acme-ca-bundle", "LEGO_CA_CERTIFICATES")
+	viper.BindEnv("account-storage", "ACME_ACCOUNT_STORAGE")
+	viper.BindEnv("recreate-account", "ACME_RECREATE_ACCOUNT")
+	viper.BindEnv("eab-kid", "LEGO_EAB_KID")
+	viper.BindEnv("eab-hmac", "LEGO_EAB_HMAC")
+	viper.BindEnv("eab-hmac-secret", "AZURE_KEY_VAULT_EAB_HMAC_SECRET")
+	viper.BindEnv("stores", "CERTIFICATE_STORES")
+	viper.BindEnv("certificate-path", "CERTIFICATE_PATH")
+	viper.BindEnv("lego-path", "LEGO_PATH")
+	viper.BindEnv("certificate-name-template", "CERTIFICATE_NAME_TEMPLATE")
+	viper.BindEnv("key-vault-generate-keys", "AZURE_KEY_VAULT_GENERATE_KEYS")
+	viper.BindEnv("key-vault-hsm", "AZURE_KEY_VAULT_HSM")
+	viper.BindEnv("key-vault-exportable", "AZURE_KEY_VAULT_EXPORTABLE")
+	viper.BindEnv("key-vault-content-type", "AZURE_KEY_VAULT_CONTENT_TYPE")
+	viper.BindEnv("key-vault-lifetime-actions", "AZURE_KEY_VAULT_LIFETIME_ACTIONS")
 
 	// Azure authentication environment variables for lego DNS provider
 	viper.BindEnv("azure-client-id", "AZURE_CLIENT_ID")
@@ -107,6 +147,19 @@ func SetupViper() {
 
 	// Set defaults
 	viper.SetDefault("staging", true)
+	viper.SetDefault("key-type", "rsa2048")
+	viper.SetDefault("stores", []string{"keyvault"})
+	viper.SetDefault("certificate-path", "certificates")
+	viper.SetDefault("account-storage", "filesystem")
+	viper.SetDefault("dns-propagation-check", "authoritative")
+	viper.SetDefault("challenge", "dns-01")
+	viper.SetDefault("http-responder", "standalone")
+	viper.SetDefault("http-listen", ":80")
+	viper.SetDefault("http-storage-container", "$web")
+	viper.SetDefault("zone-delegation-check", true)
+	viper.SetDefault("discovery", "resource-group")
+	viper.SetDefault("caa-check", true)
+	viper.SetDefault("caa-records", "off")
 	viper.SetDefault("azure-auth-method", "")
 	viper.SetDefault("azure-auth-msi-timeout", "2s")
 }